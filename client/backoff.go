@@ -0,0 +1,31 @@
+package client
+
+import (
+	"math/rand"
+	"time"
+
+	"google.golang.org/grpc/backoff"
+)
+
+// backoffDelay returns the delay before reconnect attempt number attempt (starting with 1),
+// it grows exponentially and is randomized by cfg.Jitter so that clients don't reconnect all at once.
+func backoffDelay(cfg backoff.Config, attempt int) time.Duration {
+	if attempt <= 0 {
+		return 0
+	}
+
+	delay := float64(cfg.BaseDelay)
+	for i := 1; i < attempt && delay < float64(cfg.MaxDelay); i++ {
+		delay *= cfg.Multiplier
+	}
+	if delay > float64(cfg.MaxDelay) {
+		delay = float64(cfg.MaxDelay)
+	}
+
+	delay *= 1 + cfg.Jitter*(rand.Float64()*2-1)
+	if delay < 0 {
+		return 0
+	}
+
+	return time.Duration(delay)
+}
@@ -0,0 +1,171 @@
+// Package client is a Go SDK for streaming service, it wraps gengrpc.StreamingServiceClient
+// and takes care of dialing, reconnecting (and resubscribing) streams and validating responses.
+package client
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+
+	gengrpc "github.com/LasTshaMAN/streaming/gen/grpc"
+)
+
+// Options configure Client, see DefaultOptions.
+type Options struct {
+	// DialTimeout limits the time it takes to establish a connection to the server.
+	DialTimeout time.Duration
+	// Backoff defines delays between reconnect attempts, it applies both to the underlying connection
+	// and to the streams.
+	Backoff backoff.Config
+	// Reconnect makes streams reconnect (and resubscribe) after they break due to a transient error
+	// or the server going away.
+	Reconnect bool
+	// MaxReconnectAttempts is how many times in a row a stream tries to reconnect before it gives up,
+	// 0 means it never gives up (unless its context is done).
+	MaxReconnectAttempts int
+	// Validator (if set) checks every response received, see ValidationError and Rules.
+	Validator Validator
+	// DialOptions are passed to grpc.DialContext as is.
+	DialOptions []grpc.DialOption
+}
+
+// DefaultOptions returns options suitable for most of the clients.
+func DefaultOptions() Options {
+	return Options{
+		DialTimeout: 5 * time.Second,
+		Backoff:     backoff.DefaultConfig,
+		Reconnect:   true,
+		DialOptions: []grpc.DialOption{grpc.WithInsecure()},
+	}
+}
+
+// Client is a client of streaming service.
+type Client struct {
+	conn *grpc.ClientConn
+	rpc  gengrpc.StreamingServiceClient
+	opts Options
+}
+
+// Dial connects to the server at addr, it blocks until the connection is established.
+func Dial(ctx context.Context, addr string, opts Options) (*Client, error) {
+	if opts.DialTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.DialTimeout)
+		defer cancel()
+	}
+
+	dialOpts := append([]grpc.DialOption{
+		grpc.WithBlock(),
+		grpc.WithConnectParams(grpc.ConnectParams{
+			Backoff:           opts.Backoff,
+			MinConnectTimeout: opts.DialTimeout,
+		}),
+	}, opts.DialOptions...)
+
+	conn, err := grpc.DialContext(ctx, addr, dialOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("dial target: %s, err: %w", addr, err)
+	}
+
+	return &Client{
+		conn: conn,
+		rpc:  gengrpc.NewStreamingServiceClient(conn),
+		opts: opts,
+	}, nil
+}
+
+// Conn returns the underlying connection, it's useful to call other services (such as health) the server exposes.
+func (c *Client) Conn() *grpc.ClientConn {
+	return c.conn
+}
+
+// Close closes the underlying connection, all the streams are closed along with it.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Get returns the data behind url.
+//
+// If the response fails validation it's returned along with ValidationError.
+func (c *Client) Get(ctx context.Context, url string) (*gengrpc.Response, error) {
+	resp, err := c.rpc.Get(ctx, &gengrpc.GetRequest{Url: url})
+	if err != nil {
+		return nil, fmt.Errorf("get, err: %w", err)
+	}
+
+	return resp, c.validate(resp)
+}
+
+// GetMany returns the data behind urls, responses come in the same order as urls.
+//
+// If any of the responses fails validation, all the responses are returned along with ValidationError
+// (of the first invalid one).
+func (c *Client) GetMany(ctx context.Context, urls []string) ([]*gengrpc.Response, error) {
+	resp, err := c.rpc.GetMany(ctx, &gengrpc.GetManyRequest{Urls: urls})
+	if err != nil {
+		return nil, fmt.Errorf("get many, err: %w", err)
+	}
+
+	for _, r := range resp.GetResponses() {
+		err := c.validate(r)
+		if err != nil {
+			return resp.GetResponses(), err
+		}
+	}
+
+	return resp.GetResponses(), nil
+}
+
+// Stream opens random data stream (see gengrpc.Request), the stream lasts until it's over, ctx is done
+// or it's closed.
+//
+// When the stream is resubscribed to, it's resumed from the last response received, and it's asked
+// for the remaining amount of items (if req.Count is set), responses the server replays count toward it.
+func (c *Client) Stream(ctx context.Context, req *gengrpc.Request) *Stream {
+	count := req.GetCount()
+
+	return c.newStream(ctx, req.GetResumeToken(), func(ctx context.Context, resumeToken string, received uint64) (responseStream, error) {
+		r := &gengrpc.Request{
+			Urls:        req.GetUrls(),
+			Group:       req.GetGroup(),
+			Count:       count,
+			Interval:    req.GetInterval(),
+			KeepOpen:    req.GetKeepOpen(),
+			ResumeToken: resumeToken,
+		}
+		if count > 0 {
+			if received >= uint64(count) {
+				return nil, errStreamOver
+			}
+			r.Count = count - uint32(received)
+		}
+
+		return c.rpc.GetRandomDataStream(ctx, r)
+	})
+}
+
+// Watch opens a stream of changes of the data behind req.Urls, it lasts until ctx is done or it's closed.
+func (c *Client) Watch(ctx context.Context, req *gengrpc.WatchRequest) *Stream {
+	return c.newStream(ctx, req.GetResumeToken(), func(ctx context.Context, resumeToken string, _ uint64) (responseStream, error) {
+		return c.rpc.Watch(ctx, &gengrpc.WatchRequest{
+			Urls:        req.GetUrls(),
+			ResumeToken: resumeToken,
+		})
+	})
+}
+
+func (c *Client) validate(resp *gengrpc.Response) error {
+	if c.opts.Validator == nil {
+		return nil
+	}
+
+	err := c.opts.Validator(resp)
+	if err != nil {
+		return newValidationError(resp, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,361 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/LasTshaMAN/streaming/client"
+	gengrpc "github.com/LasTshaMAN/streaming/gen/grpc"
+)
+
+func TestStream(t *testing.T) {
+	t.Run("resubscribes after transient error", func(t *testing.T) {
+		srv := &fakeServer{
+			streams: [][]*gengrpc.Response{
+				{response("a", 1), response("b", 2)},
+				{response("c", 3)},
+			},
+			streamErr: status.Error(codes.Unavailable, "connection reset"),
+		}
+		c := dialFake(t, srv, testOptions())
+
+		stream := c.Stream(context.Background(), &gengrpc.Request{Count: 3})
+		defer stream.Close()
+
+		urls, err := recvAll(stream)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"a", "b", "c"}, urls)
+		assert.Equal(t, 1, stream.Reconnects())
+		assert.Equal(t, "token-3", stream.ResumeToken())
+
+		reqs := srv.requests()
+		require.Len(t, reqs, 2)
+		assert.Equal(t, "", reqs[0].GetResumeToken())
+		assert.EqualValues(t, 3, reqs[0].GetCount())
+		// The second stream must continue where the first one has left off.
+		assert.Equal(t, "token-2", reqs[1].GetResumeToken())
+		assert.EqualValues(t, 1, reqs[1].GetCount())
+	})
+
+	t.Run("replayed responses count toward the amount of items", func(t *testing.T) {
+		// Responses 4 and 5 are lost along with the first stream, they are replayed once the stream is resumed.
+		srv := &replayingServer{count: 5, lose: 2, streamErr: status.Error(codes.Unavailable, "connection reset")}
+		c := dialReplaying(t, srv)
+
+		stream := c.Stream(context.Background(), &gengrpc.Request{Count: 5})
+		defer stream.Close()
+
+		urls, err := recvAll(stream)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"url 1", "url 2", "url 3", "url 4", "url 5"}, urls)
+		assert.Equal(t, 1, stream.Reconnects())
+
+		reqs := srv.requests()
+		require.Len(t, reqs, 2)
+		assert.Equal(t, "token-3", reqs[1].GetResumeToken())
+		assert.EqualValues(t, 2, reqs[1].GetCount())
+	})
+
+	t.Run("reconnects when server goes away", func(t *testing.T) {
+		srv := &fakeServer{
+			streams: [][]*gengrpc.Response{
+				{response("a", 1), {Status: gengrpc.Response_GOING_AWAY}},
+				{response("b", 2)},
+			},
+		}
+		c := dialFake(t, srv, testOptions())
+
+		stream := c.Stream(context.Background(), &gengrpc.Request{})
+		defer stream.Close()
+
+		urls, err := recvAll(stream)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"a", "b"}, urls)
+		assert.Equal(t, "token-1", srv.requests()[1].GetResumeToken())
+	})
+
+	t.Run("gives up after max reconnect attempts", func(t *testing.T) {
+		srv := &fakeServer{
+			streamErr: status.Error(codes.Unavailable, "try again later"),
+		}
+		opts := testOptions()
+		opts.MaxReconnectAttempts = 2
+		c := dialFake(t, srv, opts)
+
+		stream := c.Stream(context.Background(), &gengrpc.Request{})
+		defer stream.Close()
+
+		_, err := stream.Recv()
+		assert.Equal(t, codes.Unavailable, status.Code(errors.Unwrap(err)), err)
+		assert.Len(t, srv.requests(), 3)
+	})
+
+	t.Run("doesn't reconnect after non-transient error", func(t *testing.T) {
+		srv := &fakeServer{
+			streamErr: status.Error(codes.InvalidArgument, "unknown url group"),
+		}
+		c := dialFake(t, srv, testOptions())
+
+		stream := c.Stream(context.Background(), &gengrpc.Request{Group: "unknown"})
+		defer stream.Close()
+
+		_, err := stream.Recv()
+		assert.NotNil(t, err)
+		assert.Len(t, srv.requests(), 1)
+	})
+
+	t.Run("invalid response doesn't break the stream", func(t *testing.T) {
+		errInvalid := errors.New("invalid")
+
+		srv := &fakeServer{
+			streams: [][]*gengrpc.Response{
+				{response("bad", 1), response("good", 2)},
+			},
+		}
+		opts := testOptions()
+		opts.Validator = func(resp *gengrpc.Response) error {
+			if resp.GetUrl() == "bad" {
+				return errInvalid
+			}
+			return nil
+		}
+		c := dialFake(t, srv, opts)
+
+		stream := c.Stream(context.Background(), &gengrpc.Request{})
+		defer stream.Close()
+
+		resp, err := stream.Recv()
+		var validationErr *client.ValidationError
+		assert.True(t, errors.As(err, &validationErr), err)
+		assert.True(t, errors.Is(err, errInvalid), err)
+		assert.Equal(t, "bad", resp.GetUrl())
+
+		resp, err = stream.Recv()
+		assert.Nil(t, err)
+		assert.Equal(t, "good", resp.GetUrl())
+	})
+}
+
+func TestClient_Get(t *testing.T) {
+	srv := &fakeServer{}
+	opts := testOptions()
+	opts.Validator = func(resp *gengrpc.Response) error {
+		if resp.GetStatus() != gengrpc.Response_OK {
+			return errors.New("not ok")
+		}
+		return nil
+	}
+	c := dialFake(t, srv, opts)
+
+	resp, err := c.Get(context.Background(), "some url")
+	assert.Nil(t, err)
+	assert.Equal(t, "some url", resp.GetUrl())
+
+	resps, err := c.GetMany(context.Background(), []string{"url 1", "url 2"})
+	assert.Nil(t, err)
+	require.Len(t, resps, 2)
+	assert.Equal(t, "url 2", resps[1].GetUrl())
+}
+
+func testOptions() client.Options {
+	opts := client.DefaultOptions()
+	opts.DialTimeout = time.Second
+	opts.Backoff = backoff.Config{
+		BaseDelay:  time.Millisecond,
+		Multiplier: 2,
+		Jitter:     0.2,
+		MaxDelay:   10 * time.Millisecond,
+	}
+	return opts
+}
+
+func dialFake(t *testing.T, srv *fakeServer, opts client.Options) *client.Client {
+	return dial(t, srv, opts)
+}
+
+func dialReplaying(t *testing.T, srv *replayingServer) *client.Client {
+	return dial(t, srv, testOptions())
+}
+
+func dial(t *testing.T, srv gengrpc.StreamingServiceServer, opts client.Options) *client.Client {
+	listener := bufconn.Listen(1 << 20)
+
+	grpcServer := grpc.NewServer()
+	gengrpc.RegisterStreamingServiceServer(grpcServer, srv)
+	go func() {
+		_ = grpcServer.Serve(listener)
+	}()
+	t.Cleanup(grpcServer.Stop)
+
+	opts.DialOptions = append(opts.DialOptions, grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+		return listener.Dial()
+	}))
+
+	c, err := client.Dial(context.Background(), "bufnet", opts)
+	require.Nil(t, err)
+	t.Cleanup(func() {
+		_ = c.Close()
+	})
+
+	return c
+}
+
+func recvAll(stream *client.Stream) ([]string, error) {
+	var urls []string
+	for {
+		resp, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return urls, nil
+		}
+		if err != nil {
+			return urls, err
+		}
+		urls = append(urls, resp.GetUrl())
+	}
+}
+
+func response(url string, seq uint64) *gengrpc.Response {
+	return &gengrpc.Response{
+		Url:         url,
+		Sequence:    seq,
+		ResumeToken: fmt.Sprintf("token-%d", seq),
+	}
+}
+
+// fakeServer serves streams one after another, each stream but the last one ends with streamErr.
+type fakeServer struct {
+	gengrpc.UnimplementedStreamingServiceServer
+
+	streams   [][]*gengrpc.Response
+	streamErr error
+
+	mu   sync.Mutex
+	reqs []*gengrpc.Request
+}
+
+func (s *fakeServer) GetRandomDataStream(req *gengrpc.Request, stream gengrpc.StreamingService_GetRandomDataStreamServer) error {
+	s.mu.Lock()
+	idx := len(s.reqs)
+	s.reqs = append(s.reqs, req)
+	s.mu.Unlock()
+
+	if idx >= len(s.streams) {
+		return s.streamErr
+	}
+
+	for _, resp := range s.streams[idx] {
+		err := stream.Send(resp)
+		if err != nil {
+			return err
+		}
+	}
+
+	if idx < len(s.streams)-1 {
+		return s.streamErr
+	}
+
+	return nil
+}
+
+func (s *fakeServer) Get(_ context.Context, req *gengrpc.GetRequest) (*gengrpc.Response, error) {
+	return &gengrpc.Response{Url: req.GetUrl()}, nil
+}
+
+func (s *fakeServer) GetMany(_ context.Context, req *gengrpc.GetManyRequest) (*gengrpc.GetManyResponse, error) {
+	result := &gengrpc.GetManyResponse{}
+	for _, url := range req.GetUrls() {
+		result.Responses = append(result.Responses, &gengrpc.Response{Url: url})
+	}
+	return result, nil
+}
+
+func (s *fakeServer) requests() []*gengrpc.Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]*gengrpc.Request(nil), s.reqs...)
+}
+
+// replayingServer follows the contract of the real server: a resumed stream replays the responses following
+// resume token (they count toward the amount of items requested) before sending new ones.
+//
+// The first stream sends count items, but the last lose of them never reach the client (the stream breaks with streamErr).
+type replayingServer struct {
+	gengrpc.UnimplementedStreamingServiceServer
+
+	count     int
+	lose      int
+	streamErr error
+
+	mu   sync.Mutex
+	reqs []*gengrpc.Request
+	// sent are all the responses sent (or lost) so far, response with sequence number seq is sent[seq-1].
+	sent []*gengrpc.Response
+}
+
+func (s *replayingServer) GetRandomDataStream(req *gengrpc.Request, stream gengrpc.StreamingService_GetRandomDataStreamServer) error {
+	s.mu.Lock()
+	first := len(s.reqs) == 0
+	s.reqs = append(s.reqs, req)
+	s.mu.Unlock()
+
+	count := int(req.GetCount())
+
+	if req.GetResumeToken() != "" {
+		var seq int
+		_, err := fmt.Sscanf(req.GetResumeToken(), "token-%d", &seq)
+		if err != nil {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+
+		for _, resp := range s.sent[seq:] {
+			err := stream.Send(resp)
+			if err != nil {
+				return err
+			}
+			count--
+		}
+	}
+
+	for i := 0; i < count; i++ {
+		seq := uint64(len(s.sent) + 1)
+		resp := response(fmt.Sprintf("url %d", seq), seq)
+		s.sent = append(s.sent, resp)
+
+		if first && i >= count-s.lose {
+			continue
+		}
+
+		err := stream.Send(resp)
+		if err != nil {
+			return err
+		}
+	}
+
+	if first {
+		return s.streamErr
+	}
+
+	return nil
+}
+
+func (s *replayingServer) requests() []*gengrpc.Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]*gengrpc.Request(nil), s.reqs...)
+}
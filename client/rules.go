@@ -0,0 +1,181 @@
+package client
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"regexp"
+	"strings"
+
+	gengrpc "github.com/LasTshaMAN/streaming/gen/grpc"
+)
+
+var (
+	ErrResponseError   = errors.New("server failed to get data")
+	ErrDataUnavailable = errors.New("data is currently unavailable")
+	ErrDataTooShort    = errors.New("data is too short")
+	ErrDataTooLong     = errors.New("data is too long")
+	ErrNoSubstring     = errors.New("data doesn't contain required substring")
+	ErrNoMatch         = errors.New("data doesn't match required pattern")
+	ErrContentType     = errors.New("unexpected content type")
+	ErrHashMismatch    = errors.New("content hash doesn't match data")
+)
+
+// RulesConfig defines validation rules, zero value of a field disables the corresponding rule.
+type RulesConfig struct {
+	// AllowUnavailable makes UNAVAILABLE responses (that carry no data) pass validation.
+	AllowUnavailable bool `yaml:"AllowUnavailable" json:"allow_unavailable"`
+	MinLength        int  `yaml:"MinLength" json:"min_length"`
+	MaxLength        int  `yaml:"MaxLength" json:"max_length"`
+	// Contains lists substrings data must contain.
+	Contains []string `yaml:"Contains" json:"contains"`
+	// Matches lists regular expressions data must match.
+	Matches []string `yaml:"Matches" json:"matches"`
+	// ContentType is the media type (such as text/html) data must have, it's detected by http.DetectContentType.
+	ContentType string `yaml:"ContentType" json:"content_type"`
+	// VerifyHash makes sure content hash of the response matches its data.
+	VerifyHash bool `yaml:"VerifyHash" json:"verify_hash"`
+}
+
+// Rule is a single validation rule.
+type Rule struct {
+	// Name identifies the rule in RuleError.
+	Name  string
+	Check func(resp *gengrpc.Response) error
+}
+
+// RuleError is returned by Rules.Validate, it tells which rule the response has failed.
+type RuleError struct {
+	Rule string
+	Err  error
+}
+
+func (e *RuleError) Error() string {
+	return fmt.Sprintf("rule: %s, err: %s", e.Rule, e.Err)
+}
+
+func (e *RuleError) Unwrap() error {
+	return e.Err
+}
+
+// Rules is a set of validation rules, Rules.Validate is meant to be used as Options.Validator.
+type Rules struct {
+	allowUnavailable bool
+	rules            []Rule
+}
+
+// NewRules builds validation rules out of cfg, custom rules (if any) are checked after the configured ones.
+func NewRules(cfg RulesConfig, custom ...Rule) (Rules, error) {
+	var rules []Rule
+
+	if cfg.MinLength > 0 {
+		rules = append(rules, Rule{
+			Name: "min_length",
+			Check: func(resp *gengrpc.Response) error {
+				if len(resp.GetBody()) < cfg.MinLength {
+					return fmt.Errorf("len: %d, min: %d, err: %w", len(resp.GetBody()), cfg.MinLength, ErrDataTooShort)
+				}
+				return nil
+			},
+		})
+	}
+
+	if cfg.MaxLength > 0 {
+		rules = append(rules, Rule{
+			Name: "max_length",
+			Check: func(resp *gengrpc.Response) error {
+				if len(resp.GetBody()) > cfg.MaxLength {
+					return fmt.Errorf("len: %d, max: %d, err: %w", len(resp.GetBody()), cfg.MaxLength, ErrDataTooLong)
+				}
+				return nil
+			},
+		})
+	}
+
+	for _, substr := range cfg.Contains {
+		substr := substr
+		rules = append(rules, Rule{
+			Name: "contains:" + substr,
+			Check: func(resp *gengrpc.Response) error {
+				if !strings.Contains(string(resp.GetBody()), substr) {
+					return fmt.Errorf("substring: %s, err: %w", substr, ErrNoSubstring)
+				}
+				return nil
+			},
+		})
+	}
+
+	for _, pattern := range cfg.Matches {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return Rules{}, fmt.Errorf("compile pattern: %s, err: %w", pattern, err)
+		}
+
+		rules = append(rules, Rule{
+			Name: "matches:" + pattern,
+			Check: func(resp *gengrpc.Response) error {
+				if !re.Match(resp.GetBody()) {
+					return fmt.Errorf("pattern: %s, err: %w", re, ErrNoMatch)
+				}
+				return nil
+			},
+		})
+	}
+
+	if cfg.ContentType != "" {
+		rules = append(rules, Rule{
+			Name: "content_type",
+			Check: func(resp *gengrpc.Response) error {
+				detected := http.DetectContentType(resp.GetBody())
+				mediaType, _, err := mime.ParseMediaType(detected)
+				if err != nil || !strings.EqualFold(mediaType, cfg.ContentType) {
+					return fmt.Errorf("expected: %s, detected: %s, err: %w", cfg.ContentType, detected, ErrContentType)
+				}
+				return nil
+			},
+		})
+	}
+
+	if cfg.VerifyHash {
+		rules = append(rules, Rule{
+			Name: "hash",
+			Check: func(resp *gengrpc.Response) error {
+				sum := sha256.Sum256(resp.GetBody())
+				if hex.EncodeToString(sum[:]) != resp.GetContentHash() {
+					return ErrHashMismatch
+				}
+				return nil
+			},
+		})
+	}
+
+	return Rules{
+		allowUnavailable: cfg.AllowUnavailable,
+		rules:            append(rules, custom...),
+	}, nil
+}
+
+// Validate checks resp against the rules one by one, it returns RuleError for the first rule resp fails.
+func (r Rules) Validate(resp *gengrpc.Response) error {
+	switch resp.GetStatus() {
+	case gengrpc.Response_ERROR:
+		return &RuleError{Rule: "status", Err: ErrResponseError}
+	case gengrpc.Response_UNAVAILABLE:
+		if r.allowUnavailable {
+			return nil
+		}
+		return &RuleError{Rule: "status", Err: ErrDataUnavailable}
+	}
+
+	for _, rule := range r.rules {
+		err := rule.Check(resp)
+		if err != nil {
+			return &RuleError{Rule: rule.Name, Err: err}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,139 @@
+package client_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/LasTshaMAN/streaming/client"
+	gengrpc "github.com/LasTshaMAN/streaming/gen/grpc"
+)
+
+func TestRules(t *testing.T) {
+	page := "<html><head><title>page</title></head><body>" + strings.Repeat("content ", 20) + "</body></html>"
+
+	rules, err := client.NewRules(client.RulesConfig{
+		AllowUnavailable: true,
+		MinLength:        100,
+		MaxLength:        1000,
+		Contains:         []string{"<title>"},
+		Matches:          []string{"(?i)html>"},
+		ContentType:      "text/html",
+		VerifyHash:       true,
+	})
+	require.Nil(t, err)
+
+	tests := []struct {
+		name string
+		resp *gengrpc.Response
+		rule string
+		err  error
+	}{
+		{
+			name: "valid page",
+			resp: okResponse(page),
+		},
+		{
+			name: "unavailable is allowed",
+			resp: &gengrpc.Response{Status: gengrpc.Response_UNAVAILABLE},
+		},
+		{
+			name: "error",
+			resp: &gengrpc.Response{Status: gengrpc.Response_ERROR},
+			rule: "status",
+			err:  client.ErrResponseError,
+		},
+		{
+			name: "too short",
+			resp: okResponse("<html></html>"),
+			rule: "min_length",
+			err:  client.ErrDataTooShort,
+		},
+		{
+			name: "too long",
+			resp: okResponse(page + strings.Repeat(" ", 1000)),
+			rule: "max_length",
+			err:  client.ErrDataTooLong,
+		},
+		{
+			name: "no substring",
+			resp: okResponse(strings.Replace(page, "<title>", "<h1>", 1)),
+			rule: "contains:<title>",
+			err:  client.ErrNoSubstring,
+		},
+		{
+			name: "no match",
+			resp: okResponse(strings.Replace(page, "html>", "div>", -1)),
+			rule: "matches:(?i)html>",
+			err:  client.ErrNoMatch,
+		},
+		{
+			name: "wrong content type",
+			resp: okResponse(`{"html>": "<title>` + strings.Repeat("x", 100) + `"}`),
+			rule: "content_type",
+			err:  client.ErrContentType,
+		},
+		{
+			name: "hash mismatch",
+			resp: &gengrpc.Response{Body: []byte(page), ContentHash: "garbage"},
+			rule: "hash",
+			err:  client.ErrHashMismatch,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := rules.Validate(tt.resp)
+			if tt.err == nil {
+				assert.Nil(t, err)
+				return
+			}
+
+			var ruleErr *client.RuleError
+			require.True(t, errors.As(err, &ruleErr), err)
+			assert.Equal(t, tt.rule, ruleErr.Rule)
+			assert.True(t, errors.Is(err, tt.err), err)
+		})
+	}
+
+	t.Run("invalid pattern", func(t *testing.T) {
+		_, err := client.NewRules(client.RulesConfig{Matches: []string{"("}})
+		assert.NotNil(t, err)
+	})
+
+	t.Run("unavailable isn't allowed", func(t *testing.T) {
+		rules, err := client.NewRules(client.RulesConfig{})
+		require.Nil(t, err)
+
+		err = rules.Validate(&gengrpc.Response{Status: gengrpc.Response_UNAVAILABLE})
+		assert.True(t, errors.Is(err, client.ErrDataUnavailable), err)
+	})
+
+	t.Run("custom rule", func(t *testing.T) {
+		errCustom := errors.New("custom")
+
+		rules, err := client.NewRules(client.RulesConfig{}, client.Rule{
+			Name: "custom",
+			Check: func(*gengrpc.Response) error {
+				return errCustom
+			},
+		})
+		require.Nil(t, err)
+
+		err = rules.Validate(okResponse(page))
+		assert.True(t, errors.Is(err, errCustom), err)
+	})
+}
+
+func okResponse(body string) *gengrpc.Response {
+	sum := sha256.Sum256([]byte(body))
+
+	return &gengrpc.Response{
+		Body:        []byte(body),
+		ContentHash: hex.EncodeToString(sum[:]),
+	}
+}
@@ -0,0 +1,152 @@
+package client
+
+import (
+	"context"
+	"sync"
+
+	gengrpc "github.com/LasTshaMAN/streaming/gen/grpc"
+)
+
+// Session is a Stream whose subscriptions can be changed while it's open (see gengrpc.StreamingService_SessionClient).
+//
+// The server forgets the subscriptions of a session once its stream breaks, so Session keeps track of them
+// (as well as of whether it's paused) and sends them over every stream it reconnects with.
+//
+// Subscribe, Unsubscribe, Pause and Resume can be called concurrently with Recv, otherwise Session isn't safe
+// for concurrent use.
+type Session struct {
+	*Stream
+
+	mu sync.Mutex
+	// current is the stream control messages are sent over, nil until the session connects for the first time.
+	current gengrpc.StreamingService_SessionClient
+	// urls are the active subscriptions, in the order they were made.
+	urls   []string
+	paused bool
+}
+
+// Session opens a session subscribed to urls, it lasts until ctx is done or it's closed.
+func (c *Client) Session(ctx context.Context, urls ...string) *Session {
+	s := &Session{}
+	s.add(urls)
+
+	s.Stream = c.newStream(ctx, "", func(ctx context.Context, _ string, _ uint64) (responseStream, error) {
+		stream, err := c.rpc.Session(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		// Subscriptions made later on must reach the server after the ones made by now.
+		s.current = stream
+		s.resubscribe()
+
+		return stream, nil
+	})
+
+	return s
+}
+
+// Subscribe adds urls to the session.
+//
+// Control messages are sent best-effort: if the stream is broken, Recv reconnects it and the session
+// is resubscribed to whatever it's subscribed to by then.
+func (s *Session) Subscribe(urls ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.add(urls)
+	s.send(&gengrpc.SessionRequest{
+		Control: &gengrpc.SessionRequest_Subscribe_{Subscribe: &gengrpc.SessionRequest_Subscribe{Urls: urls}},
+	})
+}
+
+// Unsubscribe removes urls from the session.
+func (s *Session) Unsubscribe(urls ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.remove(urls)
+	s.send(&gengrpc.SessionRequest{
+		Control: &gengrpc.SessionRequest_Unsubscribe_{Unsubscribe: &gengrpc.SessionRequest_Unsubscribe{Urls: urls}},
+	})
+}
+
+// Pause stops the server from sending responses until the session is resumed, the server retains
+// the latest version of the data per URL in the meantime.
+func (s *Session) Pause() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.paused = true
+	s.send(&gengrpc.SessionRequest{Control: &gengrpc.SessionRequest_Pause_{Pause: &gengrpc.SessionRequest_Pause{}}})
+}
+
+// Resume undoes Pause.
+func (s *Session) Resume() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.paused = false
+	s.send(&gengrpc.SessionRequest{Control: &gengrpc.SessionRequest_Resume_{Resume: &gengrpc.SessionRequest_Resume{}}})
+}
+
+// URLs returns the active subscriptions of the session.
+func (s *Session) URLs() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string(nil), s.urls...)
+}
+
+// resubscribe tells the current stream about the state of the session.
+func (s *Session) resubscribe() {
+	if len(s.urls) > 0 {
+		s.send(&gengrpc.SessionRequest{
+			Control: &gengrpc.SessionRequest_Subscribe_{Subscribe: &gengrpc.SessionRequest_Subscribe{Urls: s.urls}},
+		})
+	}
+	if s.paused {
+		s.send(&gengrpc.SessionRequest{Control: &gengrpc.SessionRequest_Pause_{Pause: &gengrpc.SessionRequest_Pause{}}})
+	}
+}
+
+// send sends req over the current stream (if there is one). Errors are ignored: Send fails only if the stream
+// is broken, in which case Recv finds out why and reconnects (and resubscribes) the session.
+func (s *Session) send(req *gengrpc.SessionRequest) {
+	if s.current == nil {
+		return
+	}
+
+	_ = s.current.Send(req)
+}
+
+func (s *Session) add(urls []string) {
+	for _, url := range urls {
+		if !contains(s.urls, url) {
+			s.urls = append(s.urls, url)
+		}
+	}
+}
+
+func (s *Session) remove(urls []string) {
+	kept := s.urls[:0]
+	for _, url := range s.urls {
+		if !contains(urls, url) {
+			kept = append(kept, url)
+		}
+	}
+	s.urls = kept
+}
+
+func contains(urls []string, url string) bool {
+	for _, u := range urls {
+		if u == url {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,144 @@
+package client_test
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	gengrpc "github.com/LasTshaMAN/streaming/gen/grpc"
+)
+
+func TestSession(t *testing.T) {
+	t.Run("control messages reach the server", func(t *testing.T) {
+		srv := &sessionServer{}
+		c := dial(t, srv, testOptions())
+
+		session := c.Session(context.Background(), "a")
+		defer session.Close()
+
+		resp, err := session.Recv()
+		require.Nil(t, err)
+		assert.Equal(t, "a", resp.GetUrl())
+
+		session.Subscribe("b", "a")
+		session.Unsubscribe("a")
+		session.Pause()
+		session.Resume()
+		assert.Equal(t, []string{"b"}, session.URLs())
+
+		assert.Eventually(t, func() bool {
+			return len(srv.controls()) == 1 && len(srv.controls()[0]) == 5
+		}, time.Second, time.Millisecond)
+		assert.Equal(t, [][]string{{"subscribe a", "subscribe b,a", "unsubscribe a", "pause", "resume"}}, srv.controls())
+	})
+
+	t.Run("resubscribes after reconnect", func(t *testing.T) {
+		srv := &sessionServer{breakAfter: 4, streamErr: status.Error(codes.Unavailable, "connection reset")}
+		c := dial(t, srv, testOptions())
+
+		session := c.Session(context.Background(), "a")
+		defer session.Close()
+
+		resp, err := session.Recv()
+		require.Nil(t, err)
+		assert.Equal(t, "a", resp.GetUrl())
+
+		session.Subscribe("b")
+		session.Unsubscribe("a")
+		// The first stream breaks once the server gets this one.
+		session.Pause()
+
+		resp, err = session.Recv()
+		require.Nil(t, err)
+		assert.Equal(t, "b", resp.GetUrl())
+
+		// The second stream is told about the subscriptions (and the pause) made over the first one.
+		resp, err = session.Recv()
+		require.Nil(t, err)
+		assert.Equal(t, "b", resp.GetUrl())
+		assert.Equal(t, 1, session.Reconnects())
+
+		assert.Eventually(t, func() bool {
+			return len(srv.controls()) == 2 && len(srv.controls()[1]) == 2
+		}, time.Second, time.Millisecond)
+		assert.Equal(t, []string{"subscribe b", "pause"}, srv.controls()[1])
+	})
+}
+
+// sessionServer sends a response per URL subscribed to, the first session breaks with streamErr after
+// it receives breakAfter control messages (0 means it never breaks).
+type sessionServer struct {
+	gengrpc.UnimplementedStreamingServiceServer
+
+	breakAfter int
+	streamErr  error
+
+	mu sync.Mutex
+	// received are control messages received over every session so far.
+	received [][]string
+}
+
+func (s *sessionServer) Session(stream gengrpc.StreamingService_SessionServer) error {
+	s.mu.Lock()
+	idx := len(s.received)
+	s.received = append(s.received, nil)
+	s.mu.Unlock()
+
+	for seq := uint64(1); ; {
+		req, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+
+		s.mu.Lock()
+		s.received[idx] = append(s.received[idx], describe(req))
+		n := len(s.received[idx])
+		s.mu.Unlock()
+
+		for _, url := range req.GetSubscribe().GetUrls() {
+			err := stream.Send(response(url, seq))
+			if err != nil {
+				return err
+			}
+			seq++
+		}
+
+		if idx == 0 && n == s.breakAfter {
+			return s.streamErr
+		}
+	}
+}
+
+func (s *sessionServer) controls() [][]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := make([][]string, len(s.received))
+	for i, received := range s.received {
+		result[i] = append([]string(nil), received...)
+	}
+
+	return result
+}
+
+func describe(req *gengrpc.SessionRequest) string {
+	switch {
+	case req.GetSubscribe() != nil:
+		return "subscribe " + strings.Join(req.GetSubscribe().GetUrls(), ",")
+	case req.GetUnsubscribe() != nil:
+		return "unsubscribe " + strings.Join(req.GetUnsubscribe().GetUrls(), ",")
+	case req.GetPause() != nil:
+		return "pause"
+	case req.GetResume() != nil:
+		return "resume"
+	default:
+		return "unknown"
+	}
+}
@@ -0,0 +1,200 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	gengrpc "github.com/LasTshaMAN/streaming/gen/grpc"
+	"github.com/LasTshaMAN/streaming/internal/wait"
+)
+
+// errStreamOver is returned by openFunc when there is nothing more to ask the server for.
+var errStreamOver = errors.New("stream is over")
+
+// errGoingAway is recorded as the reason of reconnect when the server sends GOING_AWAY response.
+var errGoingAway = errors.New("server is going away")
+
+// responseStream is implemented by every client stream of Response messages.
+type responseStream interface {
+	Recv() (*gengrpc.Response, error)
+}
+
+// openFunc opens a stream that continues after resumeToken (if it's set), received is the amount of responses
+// already received over the previous streams.
+type openFunc func(ctx context.Context, resumeToken string, received uint64) (responseStream, error)
+
+// Stream is a stream of responses that survives server restarts and network failures (see Options.Reconnect).
+//
+// Stream isn't safe for concurrent use.
+type Stream struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	open openFunc
+	opts Options
+	// validate is set by the client the stream belongs to.
+	validate func(resp *gengrpc.Response) error
+
+	// current is the stream responses are being received from, nil means there is none at the moment.
+	current       responseStream
+	currentCancel context.CancelFunc
+
+	resumeToken string
+	received    uint64
+	// attempts counts reconnect attempts made since the last response was received.
+	attempts int
+	// reconnects counts reconnects made over the stream lifetime.
+	reconnects int
+	// lastErr is the error that made the stream reconnect last time.
+	lastErr error
+}
+
+func (c *Client) newStream(ctx context.Context, resumeToken string, open openFunc) *Stream {
+	ctx, cancel := context.WithCancel(ctx)
+
+	return &Stream{
+		ctx:         ctx,
+		cancel:      cancel,
+		open:        open,
+		opts:        c.opts,
+		validate:    c.validate,
+		resumeToken: resumeToken,
+	}
+}
+
+// Recv returns the next response, it blocks until there is one.
+//
+// io.EOF is returned when the stream is over. If the response fails validation it's returned along with
+// ValidationError, the stream remains usable in this case.
+func (s *Stream) Recv() (*gengrpc.Response, error) {
+	for {
+		if s.current == nil {
+			err := s.connect()
+			if errors.Is(err, errStreamOver) {
+				return nil, io.EOF
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		resp, err := s.current.Recv()
+		if errors.Is(err, io.EOF) {
+			s.drop()
+			return nil, io.EOF
+		}
+		if err != nil {
+			s.drop()
+
+			if s.ctx.Err() != nil || !s.opts.Reconnect || !retryable(err) {
+				return nil, fmt.Errorf("receive response, err: %w", err)
+			}
+			s.lastErr = err
+			if status.Code(err) == codes.OutOfRange {
+				// The server no longer remembers what we've missed, so all we can do is to start over.
+				s.resumeToken = ""
+			}
+
+			continue
+		}
+
+		if resp.GetStatus() == gengrpc.Response_GOING_AWAY {
+			// The server is shutting down, let's continue with another one.
+			s.drop()
+
+			if !s.opts.Reconnect {
+				return nil, io.EOF
+			}
+			s.lastErr = errGoingAway
+
+			continue
+		}
+
+		s.attempts = 0
+		s.received++
+		if resp.GetResumeToken() != "" {
+			s.resumeToken = resp.GetResumeToken()
+		}
+
+		return resp, s.validate(resp)
+	}
+}
+
+// ResumeToken returns the token of the last response received, it allows to resume the stream later on.
+func (s *Stream) ResumeToken() string {
+	return s.resumeToken
+}
+
+// Reconnects returns how many times the stream has been reconnected.
+func (s *Stream) Reconnects() int {
+	return s.reconnects
+}
+
+// Close closes the stream, Recv returns an error after that.
+func (s *Stream) Close() {
+	s.cancel()
+	s.drop()
+}
+
+// connect opens a new stream, it keeps trying (with backoff) until it succeeds or runs out of attempts.
+func (s *Stream) connect() error {
+	for {
+		if s.attempts > 0 {
+			if s.opts.MaxReconnectAttempts > 0 && s.attempts > s.opts.MaxReconnectAttempts {
+				return fmt.Errorf("give up reconnecting after %d attempts, err: %w", s.opts.MaxReconnectAttempts, s.lastErr)
+			}
+
+			err := wait.Sleep(s.ctx, backoffDelay(s.opts.Backoff, s.attempts))
+			if err != nil {
+				return fmt.Errorf("wait before reconnect, err: %w", err)
+			}
+		}
+		if s.received > 0 || s.attempts > 0 {
+			s.reconnects++
+		}
+		s.attempts++
+
+		ctx, cancel := context.WithCancel(s.ctx)
+
+		stream, err := s.open(ctx, s.resumeToken, s.received)
+		if err != nil {
+			cancel()
+
+			if errors.Is(err, errStreamOver) || s.ctx.Err() != nil || !s.opts.Reconnect || !retryable(err) {
+				return fmt.Errorf("open stream, err: %w", err)
+			}
+			s.lastErr = err
+
+			continue
+		}
+
+		s.current = stream
+		s.currentCancel = cancel
+
+		return nil
+	}
+}
+
+// drop releases the current stream (if there is one).
+func (s *Stream) drop() {
+	if s.currentCancel != nil {
+		s.currentCancel()
+	}
+	s.current = nil
+	s.currentCancel = nil
+}
+
+// retryable tells whether it makes sense to reconnect after err.
+func retryable(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.ResourceExhausted, codes.Aborted, codes.OutOfRange:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,40 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+
+	gengrpc "github.com/LasTshaMAN/streaming/gen/grpc"
+)
+
+// Validator checks the response makes sense (it isn't just some garbage), it returns an error when it doesn't.
+type Validator func(resp *gengrpc.Response) error
+
+// ValidationError is returned (along with the response) when the response fails validation,
+// the stream the response came from remains usable.
+type ValidationError struct {
+	Response *gengrpc.Response
+	// Rule is the name of the rule the response has failed (see RuleError), it's empty when Validator doesn't
+	// report rules.
+	Rule string
+	Err  error
+}
+
+func newValidationError(resp *gengrpc.Response, err error) *ValidationError {
+	result := &ValidationError{Response: resp, Err: err}
+
+	var ruleErr *RuleError
+	if errors.As(err, &ruleErr) {
+		result.Rule = ruleErr.Rule
+	}
+
+	return result
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid response, url: %s, err: %s", e.Response.GetUrl(), e.Err)
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/go-kit/kit/log"
+	protov1 "github.com/golang/protobuf/proto"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/LasTshaMAN/streaming/client"
+	gengrpc "github.com/LasTshaMAN/streaming/gen/grpc"
+)
+
+// urlList collects URLs passed with repeated -url flags.
+type urlList []string
+
+func (l *urlList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *urlList) Set(url string) error {
+	*l = append(*l, url)
+	return nil
+}
+
+func runStream(ctx context.Context, args []string, logger log.Logger) error {
+	var urls urlList
+
+	fs := flag.NewFlagSet("stream", flag.ExitOnError)
+	addr := fs.String("addr", defaultAddr, "server address")
+	fs.Var(&urls, "url", "URL to pick random data from (can be repeated)")
+	group := fs.String("group", "", "URL group to pick random data from")
+	count := fs.Uint("count", 0, "amount of items to get (0 means server default)")
+	interval := fs.Duration("interval", 0, "interval between items")
+	keepOpen := fs.Bool("keep-open", false, "keep the stream open after all the items are received")
+	resumeToken := fs.String("resume-token", "", "resume the stream after the response with this token")
+	reconnect := fs.Bool("reconnect", true, "reconnect (and resume) the stream after transient errors")
+	rulesPath := fs.String("rules", "", "YAML file with validation rules (see config/client_rules.yml), responses aren't validated if not set")
+	body := fs.Bool("body", true, "print response bodies")
+	_ = fs.Parse(args)
+
+	validator, err := newValidator(*rulesPath)
+	if err != nil {
+		return err
+	}
+
+	opts := client.DefaultOptions()
+	opts.Reconnect = *reconnect
+	opts.Validator = validator
+
+	c, err := client.Dial(ctx, *addr, opts)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	stream := c.Stream(ctx, &gengrpc.Request{
+		Urls:        urls,
+		Group:       *group,
+		Count:       uint32(*count),
+		Interval:    durationpb.New(*interval),
+		KeepOpen:    *keepOpen,
+		ResumeToken: *resumeToken,
+	})
+	defer stream.Close()
+
+	return printStream(ctx, stream, *body, logger)
+}
+
+func runGet(ctx context.Context, args []string, logger log.Logger) error {
+	var urls urlList
+
+	fs := flag.NewFlagSet("get", flag.ExitOnError)
+	addr := fs.String("addr", defaultAddr, "server address")
+	fs.Var(&urls, "url", "URL to get the data behind (can be repeated)")
+	rulesPath := fs.String("rules", "", "YAML file with validation rules (see config/client_rules.yml), responses aren't validated if not set")
+	body := fs.Bool("body", true, "print response bodies")
+	_ = fs.Parse(args)
+
+	if len(urls) == 0 {
+		return errors.New("at least one -url must be set")
+	}
+
+	validator, err := newValidator(*rulesPath)
+	if err != nil {
+		return err
+	}
+
+	opts := client.DefaultOptions()
+	opts.Validator = validator
+
+	c, err := client.Dial(ctx, *addr, opts)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if len(urls) == 1 {
+		resp, err := c.Get(ctx, urls[0])
+		err = logInvalid(logger, err)
+		if err != nil {
+			return err
+		}
+
+		return printResponse(resp, *body)
+	}
+
+	resps, err := c.GetMany(ctx, urls)
+	err = logInvalid(logger, err)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range resps {
+		err := printResponse(r, *body)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func runWatch(ctx context.Context, args []string, logger log.Logger) error {
+	var urls urlList
+
+	fs := flag.NewFlagSet("watch", flag.ExitOnError)
+	addr := fs.String("addr", defaultAddr, "server address")
+	fs.Var(&urls, "url", "URL to watch (can be repeated)")
+	resumeToken := fs.String("resume-token", "", "resume the stream after the response with this token")
+	reconnect := fs.Bool("reconnect", true, "reconnect (and resume) the stream after transient errors")
+	rulesPath := fs.String("rules", "", "YAML file with validation rules (see config/client_rules.yml), responses aren't validated if not set")
+	body := fs.Bool("body", true, "print response bodies")
+	_ = fs.Parse(args)
+
+	validator, err := newValidator(*rulesPath)
+	if err != nil {
+		return err
+	}
+
+	opts := client.DefaultOptions()
+	opts.Reconnect = *reconnect
+	opts.Validator = validator
+
+	c, err := client.Dial(ctx, *addr, opts)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	stream := c.Watch(ctx, &gengrpc.WatchRequest{
+		Urls:        urls,
+		ResumeToken: *resumeToken,
+	})
+	defer stream.Close()
+
+	return printStream(ctx, stream, *body, logger)
+}
+
+func runHealth(ctx context.Context, args []string, _ log.Logger) error {
+	fs := flag.NewFlagSet("health", flag.ExitOnError)
+	addr := fs.String("addr", defaultAddr, "server address")
+	service := fs.String("service", "", "service to check (empty means the server as a whole)")
+	_ = fs.Parse(args)
+
+	c, err := client.Dial(ctx, *addr, client.DefaultOptions())
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	resp, err := healthpb.NewHealthClient(c.Conn()).Check(ctx, &healthpb.HealthCheckRequest{Service: *service})
+	if err != nil {
+		return fmt.Errorf("check health, err: %w", err)
+	}
+
+	// Health service messages are generated with the older protobuf API.
+	err = printJSON(protov1.MessageV2(resp))
+	if err != nil {
+		return err
+	}
+
+	if resp.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		return fmt.Errorf("server is not serving, status: %s", resp.GetStatus())
+	}
+
+	return nil
+}
+
+// printStream prints responses until the stream is over or ctx is done.
+func printStream(ctx context.Context, stream *client.Stream, body bool, logger log.Logger) error {
+	for {
+		resp, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil && ctx.Err() != nil {
+			// We've been interrupted.
+			return nil
+		}
+		err = logInvalid(logger, err)
+		if err != nil {
+			return err
+		}
+
+		err = printResponse(resp, body)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func printResponse(resp *gengrpc.Response, body bool) error {
+	if !body {
+		resp = proto.Clone(resp).(*gengrpc.Response)
+		resp.Body = nil
+	}
+
+	return printJSON(resp)
+}
+
+func printJSON(m proto.Message) error {
+	b, err := protojson.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("marshal to JSON, err: %w", err)
+	}
+
+	_, err = fmt.Fprintln(os.Stdout, string(b))
+	if err != nil {
+		return fmt.Errorf("print, err: %w", err)
+	}
+
+	return nil
+}
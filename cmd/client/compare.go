@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+// errRegression is returned by compare command when the new report is worse than the base one.
+var errRegression = errors.New("performance regression")
+
+// runCompare diffs 2 load test reports (see load command) and fails if the second one regresses
+// past the thresholds.
+func runCompare(_ context.Context, args []string, _ log.Logger) error {
+	fs := flag.NewFlagSet("compare", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: client compare [flags] <base report> <new report>\n")
+		fs.PrintDefaults()
+	}
+	p99Threshold := fs.Float64("p99-threshold", 10, "max allowed growth of p99 latency (in percent)")
+	failureRateThreshold := fs.Float64("failure-rate-threshold", 1, "max allowed growth of failure rate (in percentage points)")
+	invalidReplyRateThreshold := fs.Float64("invalid-reply-rate-threshold", 0, "max allowed growth of invalid reply rate (in percentage points)")
+	_ = fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return fmt.Errorf("expected 2 reports to compare, got: %d", fs.NArg())
+	}
+
+	base, err := readReport(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	current, err := readReport(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	regressions := compareReports(os.Stdout, base, current, *p99Threshold, *failureRateThreshold, *invalidReplyRateThreshold)
+	if len(regressions) > 0 {
+		return fmt.Errorf("%w: %v", errRegression, regressions)
+	}
+
+	return nil
+}
+
+// compareReports prints the difference between base and current reports to w and returns the list
+// of regressions (metrics that got worse past the thresholds).
+func compareReports(
+	w io.Writer,
+	base loadReport,
+	current loadReport,
+	p99Threshold float64,
+	failureRateThreshold float64,
+	invalidReplyRateThreshold float64,
+) []string {
+	var regressions []string
+
+	fmt.Fprintf(w, "%-32s %14s %14s %10s\n", "metric", "base", "new", "diff")
+
+	baseRate, currentRate := base.Counters.FailureRate()*100, current.Counters.FailureRate()*100
+	fmt.Fprintf(w, "%-32s %13.2f%% %13.2f%% %+9.2fpp\n", "failure rate", baseRate, currentRate, currentRate-baseRate)
+	if currentRate-baseRate > failureRateThreshold {
+		regressions = append(regressions, "failure rate")
+	}
+
+	// Invalid replies are compared on their own, they are counted per reply rather than per connection.
+	baseRate, currentRate = base.Counters.InvalidReplyRate()*100, current.Counters.InvalidReplyRate()*100
+	fmt.Fprintf(w, "%-32s %13.2f%% %13.2f%% %+9.2fpp\n", "invalid reply rate", baseRate, currentRate, currentRate-baseRate)
+	if currentRate-baseRate > invalidReplyRateThreshold {
+		regressions = append(regressions, "invalid reply rate")
+	}
+
+	fmt.Fprintf(w, "%-32s %14d %14d %+9.2f%%\n",
+		"replies",
+		base.Counters.Replies,
+		current.Counters.Replies,
+		growth(float64(base.Counters.Replies), float64(current.Counters.Replies)),
+	)
+
+	for _, name := range latencyNames {
+		baseLatency, currentLatency := base.Latencies[name], current.Latencies[name]
+
+		// Percentiles of an empty histogram are zeros, comparing them would only make up a regression (or an improvement).
+		if baseLatency.Count == 0 || currentLatency.Count == 0 {
+			note := "no baseline"
+			if baseLatency.Count != 0 {
+				note = "no data"
+			}
+			fmt.Fprintf(w, "%-32s %14d %14d %10s\n", name+" count", baseLatency.Count, currentLatency.Count, note)
+			continue
+		}
+
+		for _, p := range []struct {
+			name          string
+			base, current time.Duration
+		}{
+			{"p50", baseLatency.P50, currentLatency.P50},
+			{"p90", baseLatency.P90, currentLatency.P90},
+			{"p99", baseLatency.P99, currentLatency.P99},
+			{"max", baseLatency.Max, currentLatency.Max},
+		} {
+			diff := growth(float64(p.base), float64(p.current))
+			fmt.Fprintf(w, "%-32s %14s %14s %+9.2f%%\n",
+				name+" "+p.name,
+				p.base.Round(time.Microsecond),
+				p.current.Round(time.Microsecond),
+				diff,
+			)
+
+			if p.name == "p99" && diff > p99Threshold {
+				regressions = append(regressions, name+" p99")
+			}
+		}
+	}
+
+	return regressions
+}
+
+// growth returns the growth from base to current in percent.
+func growth(base, current float64) float64 {
+	if base == 0 {
+		if current == 0 {
+			return 0
+		}
+		// Anything is infinitely greater than 0, but we'd rather keep the output readable.
+		return 100
+	}
+
+	return (current - base) / base * 100
+}
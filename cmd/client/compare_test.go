@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompareReports(t *testing.T) {
+	const (
+		p99Threshold              = 10
+		failureRateThreshold      = 1
+		invalidReplyRateThreshold = 0
+	)
+
+	newReport := func(counters loadCounters, p99 time.Duration) loadReport {
+		latencies := make(map[string]histogramReport, len(latencyNames))
+		for _, name := range latencyNames {
+			latencies[name] = histogramReport{Count: 100, P50: p99 / 2, P90: p99 / 2, P99: p99, Max: p99}
+		}
+
+		return loadReport{Counters: counters, Latencies: latencies}
+	}
+
+	counters := loadCounters{ConnectAttempts: 100, Replies: 1000}
+	base := newReport(counters, 100*time.Millisecond)
+
+	tests := []struct {
+		name    string
+		current loadReport
+		want    []string
+	}{
+		{
+			name:    "same report",
+			current: base,
+		},
+		{
+			name:    "improvement",
+			current: newReport(counters, 50*time.Millisecond),
+		},
+		{
+			name:    "p99 growth within threshold",
+			current: newReport(counters, 110*time.Millisecond),
+		},
+		{
+			name:    "p99 growth past threshold",
+			current: newReport(counters, 111*time.Millisecond),
+			want:    []string{"dial p99", "time_to_first_reply p99", "inter_reply_gap p99"},
+		},
+		{
+			name: "p50 and max growth",
+			current: func() loadReport {
+				r := newReport(counters, 100*time.Millisecond)
+				h := r.Latencies[latencyDial]
+				h.P50, h.Max = time.Second, time.Second
+				r.Latencies[latencyDial] = h
+				return r
+			}(),
+		},
+		{
+			name: "p99 growth of a single latency",
+			current: func() loadReport {
+				r := newReport(counters, 100*time.Millisecond)
+				h := r.Latencies[latencyInterReplyGap]
+				h.P99 = time.Second
+				r.Latencies[latencyInterReplyGap] = h
+				return r
+			}(),
+			want: []string{"inter_reply_gap p99"},
+		},
+		{
+			name:    "failure rate growth within threshold",
+			current: newReport(loadCounters{ConnectAttempts: 100, ConnectFailures: 1, Replies: 1000}, 100*time.Millisecond),
+		},
+		{
+			name:    "failure rate growth past threshold",
+			current: newReport(loadCounters{ConnectAttempts: 100, ConnectFailures: 1, StreamFailures: 1, Replies: 1000}, 100*time.Millisecond),
+			want:    []string{"failure rate"},
+		},
+		{
+			name:    "invalid reply rate growth",
+			current: newReport(loadCounters{ConnectAttempts: 100, Replies: 1000, InvalidReplies: 1}, 100*time.Millisecond),
+			want:    []string{"invalid reply rate"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+
+			got := compareReports(&out, base, tt.current, p99Threshold, failureRateThreshold, invalidReplyRateThreshold)
+			assert.Equal(t, tt.want, got)
+			assert.Contains(t, out.String(), "failure rate")
+			assert.Contains(t, out.String(), "invalid reply rate")
+			assert.Contains(t, out.String(), "time_to_first_reply p99")
+		})
+	}
+
+	t.Run("latency missing from base report", func(t *testing.T) {
+		var out bytes.Buffer
+
+		got := compareReports(&out, loadReport{Counters: counters}, base, p99Threshold, failureRateThreshold, invalidReplyRateThreshold)
+		assert.Empty(t, got)
+		assert.Contains(t, out.String(), "no baseline")
+	})
+
+	t.Run("empty latency histograms", func(t *testing.T) {
+		empty := func(r loadReport) loadReport {
+			latencies := make(map[string]histogramReport, len(r.Latencies))
+			for name, h := range r.Latencies {
+				h.Count = 0
+				latencies[name] = h
+			}
+			r.Latencies = latencies
+			return r
+		}
+
+		var out bytes.Buffer
+
+		// Nothing has been recorded in base report.
+		got := compareReports(&out, empty(newReport(counters, 0)), base, p99Threshold, failureRateThreshold, invalidReplyRateThreshold)
+		assert.Empty(t, got)
+		assert.Contains(t, out.String(), "no baseline")
+
+		out.Reset()
+
+		got = compareReports(&out, base, empty(newReport(counters, 0)), p99Threshold, failureRateThreshold, invalidReplyRateThreshold)
+		assert.Empty(t, got)
+		assert.Contains(t, out.String(), "no data")
+	})
+}
+
+func TestGrowth(t *testing.T) {
+	tests := []struct {
+		name    string
+		base    float64
+		current float64
+		want    float64
+	}{
+		{name: "no change", base: 100, current: 100, want: 0},
+		{name: "growth", base: 100, current: 110, want: 10},
+		{name: "decline", base: 100, current: 90, want: -10},
+		{name: "double", base: 50, current: 100, want: 100},
+		{name: "from zero to zero", base: 0, current: 0, want: 0},
+		{name: "from zero", base: 0, current: 5, want: 100},
+		{name: "to zero", base: 5, current: 0, want: -100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.InDelta(t, tt.want, growth(tt.base, tt.current), 1e-9)
+		})
+	}
+}
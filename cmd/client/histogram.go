@@ -0,0 +1,147 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// histogramGrowth is the ratio between upper bounds of 2 neighbouring histogram buckets,
+	// it defines the precision percentiles are reported with (5%).
+	histogramGrowth = 1.05
+	// histogramBuckets is enough to cover durations from 1µs up to several hours.
+	histogramBuckets = 512
+)
+
+// histogram records durations in exponentially growing buckets, so it takes the same amount of memory
+// no matter how many durations are recorded.
+//
+// histogram can be safely used concurrently from multiple go-routines.
+type histogram struct {
+	mu      sync.Mutex
+	buckets [histogramBuckets]int64
+	count   int64
+	max     time.Duration
+}
+
+func (h *histogram) Record(d time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.buckets[bucketOf(d)]++
+	h.count++
+	if d > h.max {
+		h.max = d
+	}
+}
+
+// Percentile returns (an upper estimate of) the duration p percent of recorded durations don't exceed.
+func (h *histogram) Percentile(p float64) time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.count == 0 {
+		return 0
+	}
+
+	rank := int64(math.Ceil(p / 100 * float64(h.count)))
+	if rank < 1 {
+		rank = 1
+	}
+
+	seen := int64(0)
+	for i, cnt := range h.buckets {
+		seen += cnt
+		if seen >= rank {
+			upper := bucketUpperBound(i)
+			if upper > h.max {
+				// There is no point in reporting anything greater than what we've actually seen.
+				return h.max
+			}
+			return upper
+		}
+	}
+
+	return h.max
+}
+
+func (h *histogram) Count() int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.count
+}
+
+func (h *histogram) Max() time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.max
+}
+
+// bucketOf returns the index of the bucket d falls into, bucket i holds durations up to bucketUpperBound(i)
+// (the last bucket holds everything longer than that too).
+func bucketOf(d time.Duration) int {
+	us := float64(d) / float64(time.Microsecond)
+	if us <= 1 {
+		return 0
+	}
+
+	idx := int(math.Ceil(math.Log(us) / math.Log(histogramGrowth)))
+	if idx >= histogramBuckets {
+		return histogramBuckets - 1
+	}
+
+	return idx
+}
+
+func bucketUpperBound(idx int) time.Duration {
+	if idx >= histogramBuckets-1 {
+		return math.MaxInt64
+	}
+
+	return time.Duration(math.Pow(histogramGrowth, float64(idx)) * float64(time.Microsecond))
+}
+
+// histogramReport is a serializable snapshot of histogram.
+type histogramReport struct {
+	Count int64         `json:"count"`
+	P50   time.Duration `json:"p50_ns"`
+	P90   time.Duration `json:"p90_ns"`
+	P99   time.Duration `json:"p99_ns"`
+	Max   time.Duration `json:"max_ns"`
+	// Buckets lists non-empty buckets only.
+	Buckets []bucketReport `json:"buckets"`
+}
+
+type bucketReport struct {
+	UpperBound time.Duration `json:"le_ns"`
+	Count      int64         `json:"count"`
+}
+
+func (h *histogram) Report() histogramReport {
+	result := histogramReport{
+		Count:   h.Count(),
+		P50:     h.Percentile(50),
+		P90:     h.Percentile(90),
+		P99:     h.Percentile(99),
+		Max:     h.Max(),
+		Buckets: []bucketReport{},
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i, cnt := range h.buckets {
+		if cnt == 0 {
+			continue
+		}
+		result.Buckets = append(result.Buckets, bucketReport{
+			UpperBound: bucketUpperBound(i),
+			Count:      cnt,
+		})
+	}
+
+	return result
+}
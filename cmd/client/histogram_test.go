@@ -0,0 +1,122 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistogram_Percentile(t *testing.T) {
+	// upTo returns durations from step up to n*step.
+	upTo := func(n int, step time.Duration) []time.Duration {
+		result := make([]time.Duration, n)
+		for i := range result {
+			result[i] = time.Duration(i+1) * step
+		}
+		return result
+	}
+
+	// Percentiles are upper estimates, precise within histogramGrowth.
+	tests := []struct {
+		name     string
+		recorded []time.Duration
+		p        float64
+		min      time.Duration
+		max      time.Duration
+	}{
+		{
+			name: "empty histogram, p0",
+			p:    0,
+		},
+		{
+			name: "empty histogram, p50",
+			p:    50,
+		},
+		{
+			name: "empty histogram, p100",
+			p:    100,
+		},
+		{
+			name:     "single duration, p0",
+			recorded: []time.Duration{10 * time.Millisecond},
+			p:        0,
+			min:      10 * time.Millisecond,
+			max:      10 * time.Millisecond,
+		},
+		{
+			name:     "single duration, p100",
+			recorded: []time.Duration{10 * time.Millisecond},
+			p:        100,
+			min:      10 * time.Millisecond,
+			max:      10 * time.Millisecond,
+		},
+		{
+			name:     "p0 is the smallest duration",
+			recorded: upTo(100, time.Millisecond),
+			p:        0,
+			min:      time.Millisecond,
+			max:      time.Duration(histogramGrowth * float64(time.Millisecond)),
+		},
+		{
+			name:     "p50",
+			recorded: upTo(100, time.Millisecond),
+			p:        50,
+			min:      50 * time.Millisecond,
+			max:      time.Duration(histogramGrowth * float64(50*time.Millisecond)),
+		},
+		{
+			name:     "p99",
+			recorded: upTo(100, time.Millisecond),
+			p:        99,
+			min:      99 * time.Millisecond,
+			max:      100 * time.Millisecond,
+		},
+		{
+			name:     "p100 is the largest duration",
+			recorded: upTo(100, time.Millisecond),
+			p:        100,
+			min:      100 * time.Millisecond,
+			max:      100 * time.Millisecond,
+		},
+		{
+			name:     "duration on the upper bound of a bucket stays in that bucket",
+			recorded: []time.Duration{bucketUpperBound(100), bucketUpperBound(101)},
+			p:        50,
+			min:      bucketUpperBound(100),
+			max:      bucketUpperBound(100),
+		},
+		{
+			name:     "duration just above the upper bound of a bucket goes to the next bucket",
+			recorded: []time.Duration{bucketUpperBound(100) + 1, bucketUpperBound(102)},
+			p:        50,
+			min:      bucketUpperBound(101),
+			max:      bucketUpperBound(101),
+		},
+		{
+			name:     "durations up to 1µs share the first bucket",
+			recorded: []time.Duration{0, time.Nanosecond, time.Microsecond},
+			p:        50,
+			min:      time.Microsecond,
+			max:      time.Microsecond,
+		},
+		{
+			name:     "durations past the last bucket are reported as the largest one",
+			recorded: []time.Duration{time.Millisecond, 1000 * time.Hour},
+			p:        100,
+			min:      1000 * time.Hour,
+			max:      1000 * time.Hour,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var h histogram
+			for _, d := range tt.recorded {
+				h.Record(d)
+			}
+
+			got := h.Percentile(tt.p)
+			assert.True(t, got >= tt.min && got <= tt.max, "got: %s, want: [%s, %s]", got, tt.min, tt.max)
+		})
+	}
+}
@@ -0,0 +1,464 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"gopkg.in/yaml.v2"
+
+	"github.com/LasTshaMAN/streaming/client"
+	gengrpc "github.com/LasTshaMAN/streaming/gen/grpc"
+	"github.com/LasTshaMAN/streaming/internal/wait"
+)
+
+// scenario describes the shape of the load.
+type scenario struct {
+	Addr string `yaml:"Addr" json:"addr"`
+	// Connections is the amount of connections (each carrying a single stream) kept open at once.
+	Connections int `yaml:"Connections" json:"connections"`
+	// RampUpRate is how many new connections are opened per second until there are Connections of them,
+	// 0 means all of them are opened at once.
+	RampUpRate float64 `yaml:"RampUpRate" json:"ramp_up_rate"`
+	// Duration of the test, 0 means the test runs until interrupted.
+	Duration time.Duration `yaml:"Duration" json:"duration_ns"`
+	// HoldTime is how long each stream is held open, 0 means until the end of the test.
+	HoldTime time.Duration `yaml:"HoldTime" json:"hold_time_ns"`
+	// Reconnect makes a connection be re-established once its stream is over (due to HoldTime or an error).
+	Reconnect bool `yaml:"Reconnect" json:"reconnect"`
+	// ReconnectDelay is the pause before re-establishing a connection.
+	ReconnectDelay time.Duration `yaml:"ReconnectDelay" json:"reconnect_delay_ns"`
+	// Group is the URL group to request random data from, empty means all the URLs.
+	Group string `yaml:"Group" json:"group"`
+	// Interval between items sent over each stream.
+	Interval time.Duration `yaml:"Interval" json:"interval_ns"`
+	// Validation rules replies are checked against, they are set with -rules flag.
+	Validation client.RulesConfig `yaml:"-" json:"validation"`
+}
+
+func defaultScenario() scenario {
+	return scenario{
+		Addr:        defaultAddr,
+		Connections: 1000,
+		Interval:    time.Second,
+		Validation:  defaultRules(),
+	}
+}
+
+// loadStats gathers the statistics of a load test.
+type loadStats struct {
+	connectAttempts int64
+	connectFailures int64
+	streamFailures  int64
+	invalidReplies  int64
+	replies         int64
+
+	dialTime          histogram
+	timeToFirstReply  histogram
+	interReplyLatency histogram
+
+	mu sync.Mutex
+	// errors counts failed connections and streams by gRPC status code.
+	errors map[codes.Code]int64
+	// invalidByRule counts invalid replies by the validation rule they've failed.
+	invalidByRule map[string]int64
+}
+
+func newLoadStats() *loadStats {
+	return &loadStats{
+		errors:        make(map[codes.Code]int64),
+		invalidByRule: make(map[string]int64),
+	}
+}
+
+// recordError counts connection (or stream) failure caused by err.
+func (s *loadStats) recordError(err error) {
+	code := codes.Unknown
+	var grpcErr interface{ GRPCStatus() *status.Status }
+	switch {
+	case errors.As(err, &grpcErr):
+		code = grpcErr.GRPCStatus().Code()
+	case errors.Is(err, context.DeadlineExceeded):
+		code = codes.DeadlineExceeded
+	case errors.Is(err, context.Canceled):
+		code = codes.Canceled
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.errors[code]++
+}
+
+// recordInvalidReply counts reply that has failed validation.
+func (s *loadStats) recordInvalidReply(err *client.ValidationError) {
+	atomic.AddInt64(&s.invalidReplies, 1)
+
+	rule := err.Rule
+	if rule == "" {
+		rule = "unknown"
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.invalidByRule[rule]++
+}
+
+func (s *loadStats) errorCodes() map[string]int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := make(map[string]int64, len(s.errors))
+	for code, cnt := range s.errors {
+		result[code.String()] = cnt
+	}
+
+	return result
+}
+
+func (s *loadStats) invalidRepliesByRule() map[string]int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := make(map[string]int64, len(s.invalidByRule))
+	for rule, cnt := range s.invalidByRule {
+		result[rule] = cnt
+	}
+
+	return result
+}
+
+// runLoad opens lots of connections (streams) to the server and measures how well the server handles them.
+func runLoad(ctx context.Context, args []string, logger log.Logger) error {
+	sc, reportPath, err := parseScenario(args)
+	if err != nil {
+		return err
+	}
+
+	rules, err := client.NewRules(sc.Validation)
+	if err != nil {
+		return err
+	}
+
+	_ = level.Info(logger).Log("msg", fmt.Sprintf("start load test, scenario: %+v", sc))
+
+	if sc.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, sc.Duration)
+		defer cancel()
+	}
+
+	var (
+		stats = newLoadStats()
+
+		startTime = time.Now()
+
+		wg sync.WaitGroup
+	)
+
+	go reportProgress(ctx, logger, stats, startTime)
+
+	for i := 0; i < sc.Connections; i++ {
+		if sc.RampUpRate > 0 && i > 0 {
+			err := wait.Sleep(ctx, time.Duration(float64(time.Second)/sc.RampUpRate))
+			if err != nil {
+				break
+			}
+		}
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			runConnection(ctx, sc, rules, stats, logger)
+		}()
+	}
+
+	wg.Wait()
+
+	report := newLoadReport(sc, stats, startTime, time.Since(startTime))
+
+	printReport(os.Stdout, report)
+
+	if reportPath != "" {
+		return writeReport(reportPath, report)
+	}
+
+	return nil
+}
+
+// parseScenario returns load scenario along with the path to write the JSON report to.
+func parseScenario(args []string) (scenario, string, error) {
+	sc := defaultScenario()
+
+	fs := flag.NewFlagSet("load", flag.ExitOnError)
+	scenarioFile := fs.String("scenario", "", "YAML file with load scenario, flags set explicitly take precedence over it")
+	addr := fs.String("addr", sc.Addr, "server address")
+	connections := fs.Int("connections", sc.Connections, "amount of connections kept open at once")
+	rampUpRate := fs.Float64("ramp-up-rate", sc.RampUpRate, "connections opened per second (0 means all at once)")
+	duration := fs.Duration("duration", sc.Duration, "test duration (0 means until interrupted)")
+	holdTime := fs.Duration("hold-time", sc.HoldTime, "how long each stream is held open (0 means until the end of the test)")
+	reconnect := fs.Bool("reconnect", sc.Reconnect, "re-establish connection once its stream is over")
+	reconnectDelay := fs.Duration("reconnect-delay", sc.ReconnectDelay, "pause before re-establishing connection")
+	group := fs.String("group", sc.Group, "URL group to request random data from")
+	interval := fs.Duration("interval", sc.Interval, "interval between items sent over each stream")
+	reportPath := fs.String("report", "", "file to write JSON report to (see compare command)")
+	rulesPath := fs.String("rules", "", "YAML file with validation rules (see config/client_rules.yml), default rules are used if not set")
+	_ = fs.Parse(args)
+
+	if *scenarioFile != "" {
+		b, err := ioutil.ReadFile(*scenarioFile)
+		if err != nil {
+			return sc, "", fmt.Errorf("read scenario file: %s, err: %w", *scenarioFile, err)
+		}
+
+		err = yaml.UnmarshalStrict(b, &sc)
+		if err != nil {
+			return sc, "", fmt.Errorf("decode scenario file: %s, err: %w", *scenarioFile, err)
+		}
+	}
+
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "addr":
+			sc.Addr = *addr
+		case "connections":
+			sc.Connections = *connections
+		case "ramp-up-rate":
+			sc.RampUpRate = *rampUpRate
+		case "duration":
+			sc.Duration = *duration
+		case "hold-time":
+			sc.HoldTime = *holdTime
+		case "reconnect":
+			sc.Reconnect = *reconnect
+		case "reconnect-delay":
+			sc.ReconnectDelay = *reconnectDelay
+		case "group":
+			sc.Group = *group
+		case "interval":
+			sc.Interval = *interval
+		}
+	})
+
+	rules, err := loadRules(*rulesPath)
+	if err != nil {
+		return sc, "", err
+	}
+	sc.Validation = rules
+
+	if sc.Connections <= 0 {
+		return sc, "", fmt.Errorf("connections must be positive, got: %d", sc.Connections)
+	}
+
+	return sc, *reportPath, nil
+}
+
+// runConnection keeps a connection (and a stream over it) open according to scenario until ctx is done.
+func runConnection(ctx context.Context, sc scenario, rules client.Rules, stats *loadStats, logger log.Logger) {
+	for {
+		err := connect(ctx, sc, rules, stats)
+		if err != nil && ctx.Err() == nil {
+			_ = level.Error(logger).Log("err", fmt.Errorf("connect, err: %w", err))
+		}
+
+		if !sc.Reconnect || ctx.Err() != nil {
+			return
+		}
+
+		if wait.Sleep(ctx, sc.ReconnectDelay) != nil {
+			return
+		}
+	}
+}
+
+// connect establishes a connection, opens a stream over it and consumes this stream until hold time elapses,
+// ctx is done or the first error is encountered.
+func connect(ctx context.Context, sc scenario, rules client.Rules, stats *loadStats) error {
+	atomic.AddInt64(&stats.connectAttempts, 1)
+
+	dialStart := time.Now()
+
+	// Reconnects are part of the scenario, so we don't want the client to do them behind our back.
+	opts := client.DefaultOptions()
+	opts.Reconnect = false
+	opts.Validator = rules.Validate
+
+	c, err := client.Dial(ctx, sc.Addr, opts)
+	if err != nil {
+		atomic.AddInt64(&stats.connectFailures, 1)
+		stats.recordError(err)
+		return err
+	}
+	defer c.Close()
+
+	stats.dialTime.Record(time.Since(dialStart))
+
+	streamCtx := ctx
+	if sc.HoldTime > 0 {
+		var cancel context.CancelFunc
+		streamCtx, cancel = context.WithTimeout(ctx, sc.HoldTime)
+		defer cancel()
+	}
+
+	streamStart := time.Now()
+
+	// Ask the server to hold on to the stream, so that we can keep lots of connections open at once.
+	stream := c.Stream(streamCtx, &gengrpc.Request{
+		Group:    sc.Group,
+		Count:    uint32(streamItemsCnt(sc)),
+		Interval: durationpb.New(sc.Interval),
+		KeepOpen: true,
+	})
+	defer stream.Close()
+
+	last := time.Time{}
+
+	for {
+		_, err := stream.Recv()
+		if streamCtx.Err() != nil {
+			// Either hold time has elapsed, or the test is over.
+			return nil
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+
+		// Invalid reply is a reply nevertheless.
+		var validationErr *client.ValidationError
+		if err != nil && !errors.As(err, &validationErr) {
+			if last.IsZero() {
+				atomic.AddInt64(&stats.connectFailures, 1)
+			} else {
+				atomic.AddInt64(&stats.streamFailures, 1)
+			}
+			stats.recordError(err)
+			return err
+		}
+
+		now := time.Now()
+		if last.IsZero() {
+			stats.timeToFirstReply.Record(now.Sub(streamStart))
+		} else {
+			stats.interReplyLatency.Record(now.Sub(last))
+		}
+		last = now
+
+		atomic.AddInt64(&stats.replies, 1)
+
+		if validationErr != nil {
+			// One bad page doesn't make the stream as a whole a failure.
+			stats.recordInvalidReply(validationErr)
+		}
+	}
+}
+
+// streamItemsCnt returns the amount of items to request over a single stream.
+func streamItemsCnt(sc scenario) int {
+	const maxItems = 1<<32 - 1
+
+	if sc.Interval <= 0 {
+		return 0
+	}
+
+	holdTime := sc.HoldTime
+	if holdTime <= 0 {
+		holdTime = sc.Duration
+	}
+	if holdTime <= 0 {
+		// There is no telling how long the stream is going to last.
+		return maxItems
+	}
+
+	cnt := int64(holdTime/sc.Interval) + 1
+	if cnt > maxItems {
+		return maxItems
+	}
+
+	return int(cnt)
+}
+
+func reportProgress(ctx context.Context, logger log.Logger, stats *loadStats, startTime time.Time) {
+	const period = 5 * time.Second
+
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		_ = level.Info(logger).Log(
+			"msg", "load test progress",
+			"connect_attempts", atomic.LoadInt64(&stats.connectAttempts),
+			"connect_failures", atomic.LoadInt64(&stats.connectFailures),
+			"replies", atomic.LoadInt64(&stats.replies),
+			"took_ms", time.Since(startTime).Milliseconds(),
+		)
+	}
+}
+
+func printReport(w io.Writer, report loadReport) {
+	fmt.Fprintf(w, "load test took: %s\n", report.Took.Round(time.Millisecond))
+	fmt.Fprintf(w, "connect attempts: %d, connect failures: %d, stream failures: %d, failure rate: %.2f%%\n",
+		report.Counters.ConnectAttempts,
+		report.Counters.ConnectFailures,
+		report.Counters.StreamFailures,
+		report.Counters.FailureRate()*100,
+	)
+	fmt.Fprintf(w, "replies: %d, invalid replies: %d, invalid reply rate: %.2f%%\n",
+		report.Counters.Replies,
+		report.Counters.InvalidReplies,
+		report.Counters.InvalidReplyRate()*100,
+	)
+	printBreakdown(w, "invalid replies by rule:", report.InvalidRepliesByRule)
+	printBreakdown(w, "errors by status code:", report.Errors)
+
+	fmt.Fprintf(w, "%-22s %10s %12s %12s %12s %12s\n", "latency", "count", "p50", "p90", "p99", "max")
+	for _, name := range latencyNames {
+		h := report.Latencies[name]
+		fmt.Fprintf(w, "%-22s %10d %12s %12s %12s %12s\n",
+			name,
+			h.Count,
+			h.P50.Round(time.Microsecond),
+			h.P90.Round(time.Microsecond),
+			h.P99.Round(time.Microsecond),
+			h.Max.Round(time.Microsecond),
+		)
+	}
+}
+
+func printBreakdown(w io.Writer, title string, counts map[string]int64) {
+	if len(counts) == 0 {
+		return
+	}
+
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	fmt.Fprintln(w, title)
+	for _, key := range keys {
+		fmt.Fprintf(w, "  %-30s %d\n", key, counts[key])
+	}
+}
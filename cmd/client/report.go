@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sync/atomic"
+	"time"
+)
+
+// Latency names used as keys in loadReport.Latencies.
+const (
+	latencyDial             = "dial"
+	latencyTimeToFirstReply = "time_to_first_reply"
+	latencyInterReplyGap    = "inter_reply_gap"
+)
+
+// latencyNames lists latencies in the order they are printed in.
+var latencyNames = []string{latencyDial, latencyTimeToFirstReply, latencyInterReplyGap}
+
+// loadReport is the machine-readable outcome of a load test, it's meant to be compared against the reports
+// of previous runs (see runCompare).
+type loadReport struct {
+	Scenario  scenario                   `json:"scenario"`
+	StartedAt time.Time                  `json:"started_at"`
+	Took      time.Duration              `json:"took_ns"`
+	Counters  loadCounters               `json:"counters"`
+	Latencies map[string]histogramReport `json:"latencies"`
+	// Errors maps gRPC status codes (of failed connections and streams) to the amount of such failures.
+	Errors map[string]int64 `json:"errors"`
+	// InvalidRepliesByRule maps validation rules to the amount of replies that have failed them.
+	InvalidRepliesByRule map[string]int64 `json:"invalid_replies_by_rule"`
+}
+
+type loadCounters struct {
+	ConnectAttempts int64 `json:"connect_attempts"`
+	ConnectFailures int64 `json:"connect_failures"`
+	StreamFailures  int64 `json:"stream_failures"`
+	Replies         int64 `json:"replies"`
+	InvalidReplies  int64 `json:"invalid_replies"`
+}
+
+// FailureRate returns the share of connection attempts that ended up with a failure.
+// Invalid replies don't fail connections, see InvalidReplyRate.
+func (c loadCounters) FailureRate() float64 {
+	if c.ConnectAttempts == 0 {
+		return 0
+	}
+
+	return float64(c.ConnectFailures+c.StreamFailures) / float64(c.ConnectAttempts)
+}
+
+// InvalidReplyRate returns the share of replies that have failed validation.
+func (c loadCounters) InvalidReplyRate() float64 {
+	if c.Replies == 0 {
+		return 0
+	}
+
+	return float64(c.InvalidReplies) / float64(c.Replies)
+}
+
+func newLoadReport(sc scenario, stats *loadStats, startedAt time.Time, took time.Duration) loadReport {
+	return loadReport{
+		Scenario:  sc,
+		StartedAt: startedAt.UTC(),
+		Took:      took,
+		Counters: loadCounters{
+			ConnectAttempts: atomic.LoadInt64(&stats.connectAttempts),
+			ConnectFailures: atomic.LoadInt64(&stats.connectFailures),
+			StreamFailures:  atomic.LoadInt64(&stats.streamFailures),
+			Replies:         atomic.LoadInt64(&stats.replies),
+			InvalidReplies:  atomic.LoadInt64(&stats.invalidReplies),
+		},
+		Latencies: map[string]histogramReport{
+			latencyDial:             stats.dialTime.Report(),
+			latencyTimeToFirstReply: stats.timeToFirstReply.Report(),
+			latencyInterReplyGap:    stats.interReplyLatency.Report(),
+		},
+		Errors:               stats.errorCodes(),
+		InvalidRepliesByRule: stats.invalidRepliesByRule(),
+	}
+}
+
+func writeReport(path string, report loadReport) error {
+	b, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode report, err: %w", err)
+	}
+
+	err = ioutil.WriteFile(path, append(b, '\n'), 0644)
+	if err != nil {
+		return fmt.Errorf("write report file: %s, err: %w", path, err)
+	}
+
+	return nil
+}
+
+func readReport(path string) (loadReport, error) {
+	var report loadReport
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return report, fmt.Errorf("read report file: %s, err: %w", path, err)
+	}
+
+	err = json.Unmarshal(b, &report)
+	if err != nil {
+		return report, fmt.Errorf("decode report file: %s, err: %w", path, err)
+	}
+
+	return report, nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadCounters(t *testing.T) {
+	tests := []struct {
+		name             string
+		counters         loadCounters
+		failureRate      float64
+		invalidReplyRate float64
+	}{
+		{
+			name: "nothing happened",
+		},
+		{
+			name:     "no failures",
+			counters: loadCounters{ConnectAttempts: 10, Replies: 100},
+		},
+		{
+			name:        "connect and stream failures",
+			counters:    loadCounters{ConnectAttempts: 10, ConnectFailures: 1, StreamFailures: 2, Replies: 100},
+			failureRate: 0.3,
+		},
+		{
+			name:             "invalid replies don't fail connections",
+			counters:         loadCounters{ConnectAttempts: 10, Replies: 100, InvalidReplies: 5},
+			invalidReplyRate: 0.05,
+		},
+		{
+			name:        "failures without replies",
+			counters:    loadCounters{ConnectAttempts: 10, ConnectFailures: 10},
+			failureRate: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.InDelta(t, tt.failureRate, tt.counters.FailureRate(), 1e-9)
+			assert.InDelta(t, tt.invalidReplyRate, tt.counters.InvalidReplyRate(), 1e-9)
+		})
+	}
+}
@@ -1,35 +1,81 @@
 package main
 
 import (
+	"errors"
 	"fmt"
-	"strings"
-)
+	"io/ioutil"
 
-const (
-	minDataLen = 100
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"gopkg.in/yaml.v2"
 
-	mandatoryTag     = "html>"
-	expectedErrReply = "unavailable"
+	"github.com/LasTshaMAN/streaming/client"
 )
 
-var (
-	ErrDataLack  = fmt.Errorf("data len must be at least %d characters", minDataLen)
-	ErrNoHeadTag = fmt.Errorf("data must contain tag: %s", mandatoryTag)
-)
+// defaultRules make sure data isn't just some garbage.
+func defaultRules() client.RulesConfig {
+	return client.RulesConfig{
+		AllowUnavailable: true,
+		MinLength:        100,
+		Matches:          []string{"(?i)html>"},
+		VerifyHash:       true,
+	}
+}
+
+// loadRules reads validation rules from YAML file at path, empty path means default rules.
+func loadRules(path string) (client.RulesConfig, error) {
+	if path == "" {
+		return defaultRules(), nil
+	}
+
+	cfg := client.RulesConfig{}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return cfg, fmt.Errorf("read rules file: %s, err: %w", path, err)
+	}
 
-// ValidateReply performs some checks on data to make sure it isn't just some garbage.
-func ValidateReply(data string) error {
-	if data == expectedErrReply {
-		return nil
+	err = yaml.UnmarshalStrict(b, &cfg)
+	if err != nil {
+		return cfg, fmt.Errorf("decode rules file: %s, err: %w", path, err)
 	}
 
-	if len(data) < minDataLen {
-		return fmt.Errorf("data: %s, err: %w", data, ErrDataLack)
+	return cfg, nil
+}
+
+// newValidator returns validator that checks responses against the rules from YAML file at path,
+// empty path means responses aren't validated.
+func newValidator(path string) (client.Validator, error) {
+	if path == "" {
+		return nil, nil
 	}
 
-	if !strings.Contains(strings.ToLower(data), mandatoryTag) {
-		return fmt.Errorf("data: %s, err: %w", data, ErrNoHeadTag)
+	cfg, err := loadRules(path)
+	if err != nil {
+		return nil, err
 	}
 
+	rules, err := client.NewRules(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return rules.Validate, nil
+}
+
+// logInvalid logs err if it's client.ValidationError (such responses are still printed), other errors are returned as is.
+func logInvalid(logger log.Logger, err error) error {
+	var validationErr *client.ValidationError
+	if !errors.As(err, &validationErr) {
+		return err
+	}
+
+	_ = level.Warn(logger).Log(
+		"msg", "invalid response",
+		"url", validationErr.Response.GetUrl(),
+		"rule", validationErr.Rule,
+		"err", validationErr.Err,
+	)
+
 	return nil
 }
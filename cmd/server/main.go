@@ -1,25 +1,41 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
+	kitprometheus "github.com/go-kit/kit/metrics/prometheus"
 	"github.com/go-resty/resty/v2"
+	redigo "github.com/gomodule/redigo/redis"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
 
+	"github.com/LasTshaMAN/streaming"
 	gengrpc "github.com/LasTshaMAN/streaming/gen/grpc"
 	"github.com/LasTshaMAN/streaming/internal/api"
 	"github.com/LasTshaMAN/streaming/internal/config"
+	"github.com/LasTshaMAN/streaming/internal/flow"
+	"github.com/LasTshaMAN/streaming/internal/healthcheck"
 	"github.com/LasTshaMAN/streaming/internal/inmemory"
 	"github.com/LasTshaMAN/streaming/internal/internet"
+	"github.com/LasTshaMAN/streaming/internal/locking"
 	"github.com/LasTshaMAN/streaming/internal/proxy"
 	"github.com/LasTshaMAN/streaming/internal/random"
 	"github.com/LasTshaMAN/streaming/internal/redis"
+	"github.com/LasTshaMAN/streaming/internal/replay"
+	"github.com/LasTshaMAN/streaming/internal/watch"
 )
 
 func main() {
@@ -48,10 +64,17 @@ func main() {
 
 		redisInetProxyCodeExecutionUpperEstimate = 1 * time.Second
 
+		// redisLockRetryDelay is how often we check whether distributed lock has been released while waiting for it.
+		redisLockRetryDelay = 50 * time.Millisecond
+
+		// Locker sizes are the amounts of lock stripes (only used in stripe lock mode).
 		//redisLockerSize = 1
 		redisLockerSize = 100
 		//inmemLockerSize = 1
 		inmemLockerSize = 100
+
+		// watchMinRefreshInterval limits how often watched URLs are re-fetched (when their data expires too soon).
+		watchMinRefreshInterval = time.Second
 	)
 
 	redisClient := redis.NewClient(
@@ -71,23 +94,117 @@ func main() {
 		}
 	}()
 
-	redisStorage := redis.NewStorage(redisClient)
-
-	// We need to make sure distributed lock won't expire before the protected section of code finishes its execution.
-	// Also, we don't want distributed lock to be held for longer than necessary (cause that might affect service availability).
+	// Distributed lock is extended in background for as long as the protected section of code is executing,
+	// so dLockExpiry mostly defines how long the lock outlives an instance that has crashed while holding it.
+	// Still, we'd rather not depend on extensions in the usual case (when nothing takes longer than expected),
+	// and we don't want distributed lock to be held for longer than necessary (cause that might affect service availability).
 	// Thus, we are defining dLockExpiry below based on these considerations.
 	dLockExpiry := redisInetProxyCodeExecutionUpperEstimate +
 		redisDialTimeout + redisRequestTimeout +
 		inetRequestTimeout +
 		redisDialTimeout + redisRequestTimeout
 
-	redisLocker := redis.NewLocker(redisLockerSize, dLockExpiry, redisClient)
+	// The lock is held for about as long as it takes to execute the protected section of code (that's what dLockExpiry
+	// is estimated from), and it outlives an instance that has crashed while holding it by dLockExpiry.
+	// This is how long a write made under an older fencing token might be late for, see redis.Storage.
+	dLockMaxLifetime := 2 * dLockExpiry
+
+	redisStorage := redis.NewStorage(redisClient, dLockMaxLifetime)
+
+	// Nobody waits for background fetches (revalidation, refresh-ahead), so they are given up on once they take
+	// longer than expected. A fetch of redis tier is the protected section of code dLockExpiry is estimated from.
+	// A fetch of inmem tier goes through redis tier, it might wait for the lock of redis tier to be released
+	// (which takes up to dLockExpiry) and then fetch the data on its own (dLockExpiry) after checking redis storage.
+	redisBackgroundFetchTimeout := dLockExpiry
+	inmemBackgroundFetchTimeout := 2*dLockExpiry + redisDialTimeout + redisRequestTimeout
+
+	var lockMetrics locking.Metrics
+	{
+		durationBuckets := []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30}
+
+		lockMetrics = locking.Metrics{
+			WaitTime: kitprometheus.NewHistogramFrom(stdprometheus.HistogramOpts{
+				Namespace: "streaming",
+				Subsystem: "locker",
+				Name:      "wait_seconds",
+				Help:      "Time it takes to acquire a lock (or to give up on it).",
+				Buckets:   durationBuckets,
+			}, []string{"locker"}),
+			HoldTime: kitprometheus.NewHistogramFrom(stdprometheus.HistogramOpts{
+				Namespace: "streaming",
+				Subsystem: "locker",
+				Name:      "hold_seconds",
+				Help:      "Time locks are held for.",
+				Buckets:   durationBuckets,
+			}, []string{"locker"}),
+			AcquireFailures: kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+				Namespace: "streaming",
+				Subsystem: "locker",
+				Name:      "acquire_failures_total",
+				Help:      "Number of locks that haven't been acquired.",
+			}, []string{"locker", "reason"}),
+			UnlockFailures: kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+				Namespace: "streaming",
+				Subsystem: "locker",
+				Name:      "unlock_failures_total",
+				Help:      "Number of unlocks that have failed.",
+			}, []string{"locker"}),
+			Holders: kitprometheus.NewGaugeFrom(stdprometheus.GaugeOpts{
+				Namespace: "streaming",
+				Subsystem: "locker",
+				Name:      "holders",
+				Help:      "Number of current holders of locks (per stripe, if locks are striped).",
+			}, []string{"locker", "stripe"}),
+		}
+	}
+
+	// Distributed locks are held in a quorum of independent Redis nodes, so that a single node failure
+	// doesn't make us lose mutual exclusion (or stop working altogether).
+	redisLockClients := make([]*redigo.Pool, 0, len(cfg.RedisLockAddrs))
+	for _, addr := range cfg.RedisLockAddrs {
+		client := redis.NewClient(
+			addr,
+			0,
+			redisDialTimeout,
+			redisRequestTimeout,
+			redisRequestTimeout,
+			redisConnCount,
+			redisConnCount,
+			redisIdleConnTimeout,
+		)
+		redisLockClients = append(redisLockClients, client)
+	}
+	defer func() {
+		for _, client := range redisLockClients {
+			err := client.Close()
+			if err != nil {
+				_ = level.Error(logger).Log("err", fmt.Errorf("close Redis (lock) client, err: %w", err))
+			}
+		}
+	}()
+
+	redisBaseLocker := redis.NewURLLocker(dLockExpiry, redisLockRetryDelay, redisLockClients)
+	if cfg.RedisProxy.LockMode == config.LockModeStripe {
+		redisBaseLocker = redis.NewLocker(redisLockerSize, dLockExpiry, redisLockRetryDelay, redisLockClients)
+	}
+
+	redisLocker := locking.NewInstrumentingMiddleware(
+		"redis",
+		redisBaseLocker,
+		redisBaseLocker.Key,
+		cfg.RedisProxy.LockMode == config.LockModeStripe,
+		lockMetrics,
+		time.Now,
+	)
 
 	inetClient := resty.NewWithClient(&http.Client{Timeout: inetRequestTimeout})
 
 	//inetSimpleProvider := internet.NewSimpleProvider(logger, cfg.MinTimeout, cfg.MaxTimeout, inetDataUnavailablePeriod, inetClient)
 	inetProvider := internet.NewProvider(logger, cfg.MinTimeout, cfg.MaxTimeout, inetDataUnavailablePeriod, inetClient)
 
+	// Keep track of how well we are doing fetching data from the internet, this affects our health status.
+	inetTracker := healthcheck.NewTrackingProvider(inetProvider)
+
 	//redisInetProxy := proxy.NewProxy(
 	//	logger,
 	//	redisStorage,
@@ -117,11 +234,29 @@ func main() {
 	//		return result
 	//	},
 	//)
+	var proxyMetrics proxy.Metrics
+	{
+		proxyMetrics = proxy.Metrics{
+			ModeTransitions: kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+				Namespace: "streaming",
+				Subsystem: "proxy",
+				Name:      "mode_transitions_total",
+				Help:      "Number of transitions between normal and degraded modes of proxy tiers.",
+			}, []string{"tier", "from", "to"}),
+		}
+	}
+
+	redisProxyOptions, err := proxyOptions("redis", cfg.RedisProxy, redisBackgroundFetchTimeout)
+	if err != nil {
+		_ = level.Error(logger).Log("err", fmt.Errorf("build redis proxy options, err: %w", err))
+		return
+	}
+
 	redisInetProxy := proxy.NewProxy(
 		logger,
 		redisStorage,
 		redisLocker,
-		inetProvider,
+		inetTracker,
 		func(fallbackTTL time.Duration) time.Duration {
 			const (
 				// fallbackRoundTripTime is an upper estimate on the time it takes to fetch data from fallback provider.
@@ -145,11 +280,72 @@ func main() {
 
 			return result
 		},
+		redisProxyOptions,
+		proxyMetrics,
+		time.Now,
 	)
 
-	inmemLocker := inmemory.NewLocker(inmemLockerSize)
+	var inmemBaseLocker keyedLocker = inmemory.NewURLLocker()
+	if cfg.InmemProxy.LockMode == config.LockModeStripe {
+		inmemBaseLocker = inmemory.NewLocker(inmemLockerSize)
+	}
 
-	inmemStorage := inmemory.NewStorage(time.Now)
+	inmemLocker := locking.NewInstrumentingMiddleware(
+		"inmem",
+		inmemBaseLocker,
+		inmemBaseLocker.Key,
+		cfg.InmemProxy.LockMode == config.LockModeStripe,
+		lockMetrics,
+		time.Now,
+	)
+
+	evictionPolicy, err := inmemory.ParseEvictionPolicy(cfg.InmemStorage.EvictionPolicy)
+	if err != nil {
+		_ = level.Error(logger).Log("err", fmt.Errorf("parse eviction policy, err: %w", err))
+		return
+	}
+
+	var storageMetrics inmemory.StorageMetrics
+	{
+		storageMetrics = inmemory.StorageMetrics{
+			Evictions: kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+				Namespace: "streaming",
+				Subsystem: "inmem_storage",
+				Name:      "evictions_total",
+				Help:      "Number of entries evicted from in-memory storage.",
+			}, []string{"reason"}),
+			Entries: kitprometheus.NewGaugeFrom(stdprometheus.GaugeOpts{
+				Namespace: "streaming",
+				Subsystem: "inmem_storage",
+				Name:      "entries",
+				Help:      "Number of entries in in-memory storage.",
+			}, []string{}),
+			Bytes: kitprometheus.NewGaugeFrom(stdprometheus.GaugeOpts{
+				Namespace: "streaming",
+				Subsystem: "inmem_storage",
+				Name:      "bytes",
+				Help:      "Size of entries in in-memory storage.",
+			}, []string{}),
+		}
+	}
+
+	inmemStorage, err := inmemory.NewBoundedStorage(
+		cfg.InmemStorage.MaxEntries,
+		cfg.InmemStorage.MaxBytes,
+		evictionPolicy,
+		storageMetrics,
+		time.Now,
+	)
+	if err != nil {
+		_ = level.Error(logger).Log("err", fmt.Errorf("create in-memory storage, err: %w", err))
+		return
+	}
+
+	inmemProxyOptions, err := proxyOptions("inmem", cfg.InmemProxy, inmemBackgroundFetchTimeout)
+	if err != nil {
+		_ = level.Error(logger).Log("err", fmt.Errorf("build inmem proxy options, err: %w", err))
+		return
+	}
 
 	inmemRedisProxy := proxy.NewProxy(
 		logger,
@@ -179,33 +375,214 @@ func main() {
 
 			return result
 		},
+		inmemProxyOptions,
+		proxyMetrics,
+		time.Now,
 	)
 
-	//randProvider := random.NewLoggingMiddleware(logger, random.NewService(cfg.URLs, inetSimpleProvider))
-	//randProvider := random.NewLoggingMiddleware(logger, random.NewService(cfg.URLs, redisInetProxy))
-	randProvider := random.NewLoggingMiddleware(logger, random.NewService(cfg.URLs, inmemRedisProxy))
+	//randProvider := random.NewLoggingMiddleware(logger, random.NewService(cfg.URLs, cfg.URLGroups, inetSimpleProvider))
+	//randProvider := random.NewLoggingMiddleware(logger, random.NewService(cfg.URLs, cfg.URLGroups, redisInetProxy))
+	randProvider := random.NewLoggingMiddleware(logger, random.NewService(cfg.URLs, cfg.URLGroups, inmemRedisProxy))
+
+	watchHub := watch.NewHub(logger, inmemRedisProxy, watchMinRefreshInterval, time.Now)
 
-	server := api.NewServer(logger, cfg.NumberOfRequests, randProvider)
+	// Replay buffer is kept in Redis, so that a client can resume its stream on any server instance.
+	replayBuffer := replay.NewBuffer(redisStorage, cfg.ReplayBufferSize, cfg.ReplayTTL)
+
+	streamOverflowPolicy, err := flow.ParsePolicy(cfg.StreamOverflowPolicy)
+	if err != nil {
+		_ = level.Error(logger).Log("err", fmt.Errorf("parse stream overflow policy, err: %w", err))
+		return
+	}
+
+	var apiMetrics api.Metrics
+	{
+		fieldKeys := []string{"method"}
+
+		apiMetrics = api.Metrics{
+			SendLag: kitprometheus.NewHistogramFrom(stdprometheus.HistogramOpts{
+				Namespace: "streaming",
+				Subsystem: "api",
+				Name:      "send_lag_seconds",
+				Help:      "Time responses spend in stream queue before they are sent.",
+				Buckets:   []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10},
+			}, fieldKeys),
+			QueuedResponses: kitprometheus.NewGaugeFrom(stdprometheus.GaugeOpts{
+				Namespace: "streaming",
+				Subsystem: "api",
+				Name:      "queued_responses",
+				Help:      "Number of responses waiting in stream queues.",
+			}, fieldKeys),
+			DroppedResponses: kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+				Namespace: "streaming",
+				Subsystem: "api",
+				Name:      "dropped_responses_total",
+				Help:      "Number of responses dropped due to stream queue overflow.",
+			}, fieldKeys),
+			Disconnects: kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+				Namespace: "streaming",
+				Subsystem: "api",
+				Name:      "slow_consumer_disconnects_total",
+				Help:      "Number of streams closed due to stream queue overflow.",
+			}, fieldKeys),
+		}
+	}
+
+	server := api.NewServer(
+		logger,
+		cfg.NumberOfRequests,
+		randProvider,
+		cfg.URLs,
+		inmemRedisProxy,
+		watchHub,
+		replayBuffer,
+		api.FlowControl{
+			QueueSize: cfg.StreamQueueSize,
+			Policy:    streamOverflowPolicy,
+		},
+		apiMetrics,
+	)
+
+	debugServer := newDebugServer(cfg.DebugAddr, locking.NewDebugHandler(inmemLocker, redisLocker))
+	go func() {
+		err := debugServer.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			_ = level.Error(logger).Log("err", fmt.Errorf("serve debug endpoints, err: %w", err))
+		}
+	}()
+	defer func() {
+		err := debugServer.Close()
+		if err != nil {
+			_ = level.Error(logger).Log("err", fmt.Errorf("close debug server, err: %w", err))
+		}
+	}()
 
 	grpcServer := grpc.NewServer()
-	defer grpcServer.GracefulStop()
 
 	gengrpc.RegisterStreamingServiceServer(grpcServer, server)
 
+	// Let tools (such as grpcurl) discover our services.
+	reflection.Register(grpcServer)
+
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(grpcServer, healthServer)
+
+	healthChecker := healthcheck.NewChecker(
+		logger,
+		healthServer,
+		// Empty service name stands for the overall health of the server.
+		[]string{"", "StreamingService"},
+		[]healthcheck.Check{
+			healthcheck.RedisCheck(redisClient),
+			inetTracker.Check("internet", cfg.UpstreamMinSuccessRatio, cfg.UpstreamMinRequests),
+		},
+		cfg.HealthCheckInterval,
+		cfg.HealthCheckTimeout,
+		cfg.HealthFailureThreshold,
+		cfg.HealthSuccessThreshold,
+	)
+
+	healthCtx, stopHealthChecker := context.WithCancel(context.Background())
+	defer stopHealthChecker()
+
+	go healthChecker.Run(healthCtx)
+
 	conn, err := net.Listen("tcp", ":50051")
 	if err != nil {
 		_ = level.Error(logger).Log("err", fmt.Errorf("listen, err: %w", err))
 		return
 	}
-	defer func() {
-		err := conn.Close()
-		if err != nil {
-			_ = level.Error(logger).Log("err", fmt.Errorf("close connection, err: %w", err))
-		}
+	// Note, we don't need to close conn ourselves, grpcServer closes it once it's stopped.
+
+	serveErrs := make(chan error, 1)
+	go func() {
+		serveErrs <- grpcServer.Serve(conn)
 	}()
 
-	if err := grpcServer.Serve(conn); err != nil {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	select {
+	case err := <-serveErrs:
 		_ = level.Error(logger).Log("err", fmt.Errorf("serve, err: %w", err))
 		return
+	case sig := <-signals:
+		_ = level.Info(logger).Log("msg", fmt.Sprintf("received signal: %s, shutting down", sig))
+	}
+
+	stopHealthChecker()
+
+	shutdown(logger, grpcServer, healthServer, server, cfg.DrainTimeout)
+}
+
+// keyedLocker is a locker that can tell the name of the lock a URL maps to (for instrumentation).
+type keyedLocker interface {
+	streaming.Locker
+	Key(url string) string
+}
+
+func proxyOptions(name string, tier config.ProxyTier, backgroundFetchTimeout time.Duration) (proxy.Options, error) {
+	degradedPolicy, err := proxy.ParseDegradedPolicy(tier.DegradedPolicy)
+	if err != nil {
+		return proxy.Options{}, fmt.Errorf("parse degraded policy, err: %w", err)
+	}
+
+	return proxy.Options{
+		Name:                   name,
+		Grace:                  tier.Grace,
+		RefreshAheadShare:      tier.RefreshAheadShare,
+		RefreshAheadMinHits:    tier.RefreshAheadMinHits,
+		RefreshAheadWindow:     tier.RefreshAheadWindow,
+		BackgroundFetchTimeout: backgroundFetchTimeout,
+		Degraded: proxy.DegradedOptions{
+			Policy:           degradedPolicy,
+			FailureThreshold: tier.BreakerFailureThreshold,
+			ProbeInterval:    tier.BreakerProbeInterval,
+		},
+	}, nil
+}
+
+// newDebugServer returns HTTP server exposing debug endpoints, such as metrics and currently held locks.
+func newDebugServer(addr string, locks http.Handler) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.Handle("/debug/locks", locks)
+
+	return &http.Server{
+		Addr:    addr,
+		Handler: mux,
+	}
+}
+
+// shutdown stops accepting new streams, tells open streams the server is going away and waits (up to drainTimeout)
+// for them to finish, after drainTimeout elapses all the remaining streams are closed forcefully.
+func shutdown(
+	logger log.Logger,
+	grpcServer *grpc.Server,
+	healthServer *health.Server,
+	server *api.Server,
+	drainTimeout time.Duration,
+) {
+	// Let load balancers know they shouldn't route new requests to us.
+	healthServer.Shutdown()
+
+	server.Drain()
+
+	stopped := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
+		close(stopped)
+	}()
+
+	timer := time.NewTimer(drainTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-stopped:
+		_ = level.Info(logger).Log("msg", "all streams have been drained")
+	case <-timer.C:
+		_ = level.Error(logger).Log("err", fmt.Sprintf("streams haven't been drained within: %s, stop forcefully", drainTimeout))
+
+		grpcServer.Stop()
 	}
 }
@@ -10,4 +10,20 @@ var (
 	// ErrDataCurrentlyUnavailable is returned by DataProvider when data is not available at this very moment,
 	// and will not be available in the near future (for example, during the following 30 seconds - that depends on data source).
 	ErrDataCurrentlyUnavailable = errors.New("data is currently unavailable")
+	// ErrUnknownURL is returned when the requested URL isn't known to this service.
+	ErrUnknownURL = errors.New("unknown URL")
+	// ErrUnknownURLGroup is returned when the requested URL group isn't known to this service.
+	ErrUnknownURLGroup = errors.New("unknown URL group")
+	// ErrResumeTokenInvalid is returned when resume token is malformed or doesn't refer to an existing position.
+	ErrResumeTokenInvalid = errors.New("resume token is invalid")
+	// ErrLockTimeout is returned by Locker when it gives up waiting for a lock (because the caller's deadline has passed,
+	// or the caller has gone away), as opposed to failing to acquire the lock.
+	ErrLockTimeout = errors.New("timed out waiting for lock")
+	// ErrFencingTokenRejected is returned by FencedDataStorage when data has been stored with a newer fencing token already.
+	ErrFencingTokenRejected = errors.New("fencing token is older than the last accepted one")
+	// ErrStorageUnavailable is returned by proxy.Proxy (under fail fast degraded policy) when its storage fails,
+	// or while it's considered unavailable.
+	ErrStorageUnavailable = errors.New("storage is unavailable")
+	// ErrResumeTokenExpired is returned when the items following resume token are no longer available for replay.
+	ErrResumeTokenExpired = errors.New("resume token has expired")
 )
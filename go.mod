@@ -3,12 +3,15 @@ module github.com/LasTshaMAN/streaming
 go 1.14
 
 require (
+	github.com/alicebob/miniredis/v2 v2.14.1
 	github.com/go-kit/kit v0.10.0
 	github.com/go-redsync/redsync v1.4.2
 	github.com/go-resty/resty/v2 v2.3.0
 	github.com/golang/protobuf v1.4.2
 	github.com/gomodule/redigo v2.0.0+incompatible
+	github.com/prometheus/client_golang v1.3.0
 	github.com/stretchr/testify v1.4.0
+	google.golang.org/genproto v0.0.0-20200526211855-cb27e3aa2013
 	google.golang.org/grpc v1.31.0
 	google.golang.org/protobuf v1.25.0
 	gopkg.in/yaml.v2 v2.3.0
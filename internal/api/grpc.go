@@ -1,59 +1,190 @@
 package api
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
 	"github.com/LasTshaMAN/streaming"
 	gengrpc "github.com/LasTshaMAN/streaming/gen/grpc"
+	"github.com/LasTshaMAN/streaming/internal/replay"
+	"github.com/LasTshaMAN/streaming/internal/wait"
+	"github.com/LasTshaMAN/streaming/internal/watch"
 )
 
+// errServerDraining is returned to new streams once the server starts shutting down.
+var errServerDraining = status.Error(codes.Unavailable, "server is shutting down")
+
 type Server struct {
 	gengrpc.UnimplementedStreamingServiceServer
 
 	logger log.Logger
 
+	// randReqNumber is the amount of items sent over random data stream when client doesn't specify it.
 	randReqNumber int
 
-	provider streaming.RandomDataProvider
+	randProvider streaming.RandomDataProvider
+
+	// urls are the only URLs clients are allowed to ask provider about.
+	urls     map[string]struct{}
+	provider streaming.DataProvider
+
+	hub *watch.Hub
+
+	replay *replay.Buffer
+
+	flow    FlowControl
+	metrics Metrics
+
+	// draining is closed once the server starts shutting down.
+	draining  chan struct{}
+	drainOnce sync.Once
 }
 
-func NewServer(logger log.Logger, randReqNumber int, provider streaming.RandomDataProvider) *Server {
+func NewServer(
+	logger log.Logger,
+	randReqNumber int,
+	randProvider streaming.RandomDataProvider,
+	urls []string,
+	provider streaming.DataProvider,
+	hub *watch.Hub,
+	replay *replay.Buffer,
+	flow FlowControl,
+	metrics Metrics,
+) *Server {
+	known := make(map[string]struct{}, len(urls))
+	for _, url := range urls {
+		known[url] = struct{}{}
+	}
+
 	return &Server{
 		logger:        logger,
 		randReqNumber: randReqNumber,
+		randProvider:  randProvider,
+		urls:          known,
 		provider:      provider,
+		hub:           hub,
+		replay:        replay,
+		flow:          flow,
+		metrics:       metrics,
+		draining:      make(chan struct{}),
+	}
+}
+
+// Drain tells every open stream the server is going away and makes them finish, new streams are refused.
+//
+// Drain doesn't wait for streams to finish, it's up to grpc.Server.GracefulStop to do so.
+func (srv *Server) Drain() {
+	srv.drainOnce.Do(func() {
+		close(srv.draining)
+	})
+}
+
+func (srv *Server) isDraining() bool {
+	select {
+	case <-srv.draining:
+		return true
+	default:
+		return false
 	}
 }
 
+// withDrain returns a copy of ctx that is also done once the server starts draining.
+func (srv *Server) withDrain(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	go func() {
+		select {
+		case <-srv.draining:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, cancel
+}
+
 func (srv *Server) GetRandomDataStream(
-	_ *gengrpc.Request,
+	req *gengrpc.Request,
 	stream gengrpc.StreamingService_GetRandomDataStreamServer,
 ) error {
 	_ = level.Info(srv.logger).Log("msg", "received a request")
 
-	for i := 0; i < 3; i++ {
-		data, err := srv.provider.GetNext(stream.Context())
-		if err != nil && !errors.Is(err, streaming.ErrDataCurrentlyUnavailable) {
-			_ = level.Error(srv.logger).Log("err", fmt.Errorf("get next random data, err: %w", err))
+	if srv.isDraining() {
+		return errServerDraining
+	}
+
+	ctx, cancel := srv.withDrain(stream.Context())
+	defer cancel()
+
+	count := int(req.GetCount())
+	if count == 0 {
+		count = srv.randReqNumber
+	}
+
+	if req.GetInterval() != nil {
+		if err := req.GetInterval().CheckValid(); err != nil {
+			return status.Errorf(codes.InvalidArgument, "invalid interval, err: %v", err)
+		}
+	}
+	interval := req.GetInterval().AsDuration()
+	if interval < 0 {
+		return status.Error(codes.InvalidArgument, "interval must not be negative")
+	}
+
+	sender, err := srv.newSender(stream, "GetRandomDataStream", req.GetResumeToken())
+	if err != nil {
+		return err
+	}
+	defer sender.stop()
 
-			data = "unexpected err"
+	filter := streaming.URLFilter{
+		URLs:  req.GetUrls(),
+		Group: req.GetGroup(),
+	}
+
+	// Responses replayed to the client count toward the amount of items it has asked for, since the client
+	// asks for the remaining amount of items when it resumes the stream.
+	count -= sender.replayed
+
+	for i := 0; i < count; i++ {
+		if i > 0 && interval > 0 {
+			err := wait.Sleep(ctx, interval)
+			if err != nil {
+				return sender.finish()
+			}
+		}
+
+		url, data, ttl, err := srv.randProvider.GetNext(ctx, filter)
+		if errors.Is(err, streaming.ErrUnknownURL) || errors.Is(err, streaming.ErrUnknownURLGroup) {
+			return status.Error(codes.InvalidArgument, err.Error())
 		}
-		if errors.Is(err, streaming.ErrDataCurrentlyUnavailable) {
-			data = "unavailable"
+		if err != nil && ctx.Err() != nil {
+			// Either client has gone away, or the server is shutting down.
+			return sender.finish()
 		}
-		resp := &gengrpc.Response{
-			Reply: data,
+		if err != nil && !errors.Is(err, streaming.ErrDataCurrentlyUnavailable) {
+			_ = level.Error(srv.logger).Log("err", fmt.Errorf("get next random data, err: %w", err))
 		}
-		err = stream.Send(resp)
+		err = sender.send(newResponse(url, data, ttl, err, time.Now()))
 		if err != nil {
-			return fmt.Errorf("send response, err: %w", err)
+			return err
 		}
 	}
 
-	// Hold on to connection just to prove that we can.
-	select {}
+	if req.GetKeepOpen() {
+		// Hold on to the stream until the client is done with it (or the server is shutting down).
+		<-ctx.Done()
+
+		return sender.finish()
+	}
+
+	return sender.close(nil)
 }
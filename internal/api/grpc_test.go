@@ -0,0 +1,369 @@
+package api_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics/discard"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/LasTshaMAN/streaming"
+	gengrpc "github.com/LasTshaMAN/streaming/gen/grpc"
+	"github.com/LasTshaMAN/streaming/internal/api"
+	"github.com/LasTshaMAN/streaming/internal/flow"
+	"github.com/LasTshaMAN/streaming/internal/inmemory"
+	"github.com/LasTshaMAN/streaming/internal/random"
+	"github.com/LasTshaMAN/streaming/internal/replay"
+	"github.com/LasTshaMAN/streaming/internal/watch"
+)
+
+const (
+	urlA = "url a"
+	urlB = "url b"
+
+	dataTTL = time.Minute
+)
+
+func TestServer_GetRandomDataStream(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("stream ends after count items", func(t *testing.T) {
+		srv := newServer(serverOptions{})
+
+		stream := newStream(ctx)
+		err := srv.GetRandomDataStream(&gengrpc.Request{Count: 3}, stream)
+		assert.Nil(t, err)
+		assert.Equal(t, []uint64{1, 2, 3}, sequences(stream.responses()))
+	})
+
+	t.Run("server default count", func(t *testing.T) {
+		srv := newServer(serverOptions{})
+
+		stream := newStream(ctx)
+		err := srv.GetRandomDataStream(&gengrpc.Request{}, stream)
+		assert.Nil(t, err)
+		assert.Len(t, stream.responses(), 10)
+	})
+
+	t.Run("items are picked out of requested urls", func(t *testing.T) {
+		srv := newServer(serverOptions{})
+
+		for _, req := range []*gengrpc.Request{
+			{Count: 10, Urls: []string{urlB}},
+			{Count: 10, Group: "group"},
+		} {
+			stream := newStream(ctx)
+			err := srv.GetRandomDataStream(req, stream)
+			require.Nil(t, err)
+
+			want := urlA
+			if len(req.GetUrls()) > 0 {
+				want = req.GetUrls()[0]
+			}
+			for _, resp := range stream.responses() {
+				assert.Equal(t, want, resp.GetUrl())
+			}
+		}
+	})
+
+	t.Run("items are sent at interval", func(t *testing.T) {
+		const interval = 20 * time.Millisecond
+
+		srv := newServer(serverOptions{})
+
+		start := time.Now()
+		stream := newStream(ctx)
+		err := srv.GetRandomDataStream(&gengrpc.Request{Count: 3, Interval: durationpb.New(interval)}, stream)
+		assert.Nil(t, err)
+		assert.Len(t, stream.responses(), 3)
+		assert.True(t, time.Since(start) >= 2*interval, time.Since(start))
+	})
+
+	t.Run("stream is kept open", func(t *testing.T) {
+		srv := newServer(serverOptions{})
+
+		stream := newStream(ctx)
+		errs := make(chan error, 1)
+		go func() {
+			errs <- srv.GetRandomDataStream(&gengrpc.Request{Count: 1, KeepOpen: true}, stream)
+		}()
+
+		assert.Eventually(t, func() bool {
+			return len(stream.responses()) == 1
+		}, time.Second, time.Millisecond)
+
+		select {
+		case err := <-errs:
+			t.Fatalf("stream is closed before the client is done with it, err: %v", err)
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		// The client is done with the stream.
+		stream.cancel()
+
+		err := <-errs
+		assert.Equal(t, codes.Canceled, status.Code(err), err)
+		assert.Len(t, stream.responses(), 1)
+	})
+
+	t.Run("invalid request", func(t *testing.T) {
+		srv := newServer(serverOptions{})
+
+		for _, req := range []*gengrpc.Request{
+			{Count: 1, Interval: durationpb.New(-time.Second)},
+			{Count: 1, Urls: []string{"unknown url"}},
+			{Count: 1, Group: "unknown group"},
+		} {
+			stream := newStream(ctx)
+			err := srv.GetRandomDataStream(req, stream)
+			assert.Equal(t, codes.InvalidArgument, status.Code(err), req)
+			assert.Empty(t, stream.responses())
+		}
+	})
+}
+
+func TestServer_Resume(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("missed responses are replayed", func(t *testing.T) {
+		srv := newServer(serverOptions{})
+
+		first := newStream(ctx)
+		err := srv.GetRandomDataStream(&gengrpc.Request{Count: 5}, first)
+		require.Nil(t, err)
+		sent := first.responses()
+		require.Len(t, sent, 5)
+
+		// The client has only received the first 2 responses.
+		second := newStream(ctx)
+		err = srv.GetRandomDataStream(&gengrpc.Request{Count: 5, ResumeToken: sent[1].GetResumeToken()}, second)
+		require.Nil(t, err)
+
+		resumed := second.responses()
+		require.True(t, len(resumed) >= 3, len(resumed))
+		for i, resp := range resumed[:3] {
+			assert.Equal(t, sent[2+i].GetSequence(), resp.GetSequence())
+			assert.Equal(t, sent[2+i].GetBody(), resp.GetBody())
+			assert.Equal(t, sent[2+i].GetResumeToken(), resp.GetResumeToken())
+		}
+	})
+
+	t.Run("replayed responses count toward the amount of items", func(t *testing.T) {
+		srv := newServer(serverOptions{})
+
+		first := newStream(ctx)
+		err := srv.GetRandomDataStream(&gengrpc.Request{Count: 5}, first)
+		require.Nil(t, err)
+		sent := first.responses()
+		require.Len(t, sent, 5)
+
+		second := newStream(ctx)
+		err = srv.GetRandomDataStream(&gengrpc.Request{Count: 5, ResumeToken: sent[1].GetResumeToken()}, second)
+		require.Nil(t, err)
+
+		// 3 responses are replayed, 2 new ones follow them.
+		assert.Equal(t, []uint64{3, 4, 5, 6, 7}, sequences(second.responses()))
+	})
+
+	t.Run("expired resume token", func(t *testing.T) {
+		srv := newServer(serverOptions{replaySize: 2})
+
+		first := newStream(ctx)
+		err := srv.GetRandomDataStream(&gengrpc.Request{Count: 5}, first)
+		require.Nil(t, err)
+		sent := first.responses()
+		require.Len(t, sent, 5)
+
+		// 4 responses were missed, but only the last 2 of them are kept for replay.
+		second := newStream(ctx)
+		err = srv.GetRandomDataStream(&gengrpc.Request{Count: 5, ResumeToken: sent[0].GetResumeToken()}, second)
+		assert.Equal(t, codes.OutOfRange, status.Code(err), err)
+		assert.Empty(t, second.responses())
+	})
+
+	t.Run("malformed resume token", func(t *testing.T) {
+		srv := newServer(serverOptions{})
+
+		stream := newStream(ctx)
+		err := srv.GetRandomDataStream(&gengrpc.Request{Count: 5, ResumeToken: "not a token"}, stream)
+		assert.Equal(t, codes.InvalidArgument, status.Code(err), err)
+		assert.Empty(t, stream.responses())
+	})
+}
+
+func TestServer_Drain(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("open streams are told the server is going away", func(t *testing.T) {
+		srv := newServer(serverOptions{})
+
+		randomStream := newStream(ctx)
+		watching := newStream(ctx)
+
+		errs := make(chan error, 2)
+		go func() {
+			errs <- srv.GetRandomDataStream(&gengrpc.Request{Count: 1, KeepOpen: true}, randomStream)
+		}()
+		go func() {
+			errs <- srv.Watch(&gengrpc.WatchRequest{Urls: []string{urlA}}, watching)
+		}()
+
+		// Wait for both streams to get going.
+		assert.Eventually(t, func() bool {
+			return len(randomStream.responses()) == 1 && len(watching.responses()) == 1
+		}, time.Second, time.Millisecond)
+
+		srv.Drain()
+
+		assert.Nil(t, <-errs)
+		assert.Nil(t, <-errs)
+		for _, stream := range []*fakeStream{randomStream, watching} {
+			assert.Equal(t, []gengrpc.Response_Status{gengrpc.Response_OK, gengrpc.Response_GOING_AWAY}, statuses(stream.responses()))
+		}
+	})
+
+	t.Run("new streams are refused", func(t *testing.T) {
+		srv := newServer(serverOptions{})
+		srv.Drain()
+
+		err := srv.GetRandomDataStream(&gengrpc.Request{Count: 1}, newStream(ctx))
+		assert.Equal(t, codes.Unavailable, status.Code(err), err)
+
+		err = srv.Watch(&gengrpc.WatchRequest{Urls: []string{urlA}}, newStream(ctx))
+		assert.Equal(t, codes.Unavailable, status.Code(err), err)
+	})
+}
+
+// serverOptions tune the server built by newServer, zero values stand for reasonable defaults.
+type serverOptions struct {
+	provider   streaming.DataProvider
+	replaySize int
+	flow       api.FlowControl
+	metrics    api.Metrics
+}
+
+// newServer returns the server that knows about urlA and urlB (urlA makes up the group named "group").
+func newServer(opts serverOptions) *api.Server {
+	urls := []string{urlA, urlB}
+
+	if opts.provider == nil {
+		opts.provider = &fakeProvider{}
+	}
+	if opts.replaySize == 0 {
+		opts.replaySize = 100
+	}
+	if opts.flow.QueueSize == 0 {
+		opts.flow = api.FlowControl{QueueSize: 100, Policy: flow.PolicyDisconnect}
+	}
+	if opts.metrics.SendLag == nil {
+		opts.metrics = noMetrics()
+	}
+
+	return api.NewServer(
+		log.NewNopLogger(),
+		10,
+		random.NewService(urls, map[string][]string{"group": {urlA}}, opts.provider),
+		urls,
+		opts.provider,
+		watch.NewHub(log.NewNopLogger(), opts.provider, time.Millisecond, time.Now),
+		replay.NewBuffer(inmemory.NewStorage(time.Now), opts.replaySize, time.Minute),
+		opts.flow,
+		opts.metrics,
+	)
+}
+
+func noMetrics() api.Metrics {
+	return api.Metrics{
+		SendLag:          discard.NewHistogram(),
+		QueuedResponses:  discard.NewGauge(),
+		DroppedResponses: discard.NewCounter(),
+		Disconnects:      discard.NewCounter(),
+	}
+}
+
+// fakeProvider returns "data of <url>", or the error set for url (if any).
+type fakeProvider struct {
+	errs map[string]error
+}
+
+func (p *fakeProvider) Get(_ context.Context, url string) (string, time.Duration, error) {
+	if err, ok := p.errs[url]; ok {
+		return "", dataTTL, err
+	}
+
+	return fmt.Sprintf("data of %s", url), dataTTL, nil
+}
+
+// fakeStream records the responses sent over it, Send blocks while block is set and isn't closed.
+type fakeStream struct {
+	grpc.ServerStream
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	block chan struct{}
+
+	mu   sync.Mutex
+	sent []*gengrpc.Response
+}
+
+func newStream(ctx context.Context) *fakeStream {
+	ctx, cancel := context.WithCancel(ctx)
+
+	return &fakeStream{ctx: ctx, cancel: cancel}
+}
+
+func (s *fakeStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *fakeStream) Send(resp *gengrpc.Response) error {
+	if s.block != nil {
+		select {
+		case <-s.block:
+		case <-s.ctx.Done():
+			return s.ctx.Err()
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sent = append(s.sent, resp)
+
+	return nil
+}
+
+func (s *fakeStream) responses() []*gengrpc.Response {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]*gengrpc.Response(nil), s.sent...)
+}
+
+func sequences(responses []*gengrpc.Response) []uint64 {
+	result := make([]uint64, len(responses))
+	for i, resp := range responses {
+		result[i] = resp.GetSequence()
+	}
+
+	return result
+}
+
+func statuses(responses []*gengrpc.Response) []gengrpc.Response_Status {
+	result := make([]gengrpc.Response_Status, len(responses))
+	for i, resp := range responses {
+		result[i] = resp.GetStatus()
+	}
+
+	return result
+}
@@ -0,0 +1,44 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/LasTshaMAN/streaming"
+	gengrpc "github.com/LasTshaMAN/streaming/gen/grpc"
+)
+
+// newResponse builds a response out of what DataProvider returned for url at asOf.
+func newResponse(url string, data string, ttl time.Duration, err error, asOf time.Time) *gengrpc.Response {
+	resp := &gengrpc.Response{
+		Url:  url,
+		Ttl:  durationpb.New(ttl),
+		AsOf: timestamppb.New(asOf),
+	}
+
+	switch {
+	case err == nil:
+		resp.Status = gengrpc.Response_OK
+		resp.Body = []byte(data)
+		resp.ContentHash = contentHash(resp.Body)
+	case errors.Is(err, streaming.ErrDataCurrentlyUnavailable):
+		resp.Status = gengrpc.Response_UNAVAILABLE
+	default:
+		resp.Status = gengrpc.Response_ERROR
+		// We don't know for how long the error is going to persist.
+		resp.Ttl = durationpb.New(0)
+	}
+
+	return resp
+}
+
+func contentHash(body []byte) string {
+	sum := sha256.Sum256(body)
+
+	return hex.EncodeToString(sum[:])
+}
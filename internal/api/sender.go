@@ -0,0 +1,311 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/go-kit/kit/metrics"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/LasTshaMAN/streaming"
+	gengrpc "github.com/LasTshaMAN/streaming/gen/grpc"
+	"github.com/LasTshaMAN/streaming/internal/flow"
+	"github.com/LasTshaMAN/streaming/internal/replay"
+)
+
+// FlowControl configures how responses are queued for every stream before they are sent to the client.
+type FlowControl struct {
+	// QueueSize is the amount of responses a stream can hold on to while its client isn't reading them.
+	QueueSize int
+	// Policy decides what happens when a stream queue is full.
+	Policy flow.Policy
+}
+
+// Metrics describes stream flow control, every metric has "method" label.
+type Metrics struct {
+	// SendLag observes how long (in seconds) responses wait in stream queue before they are sent.
+	SendLag metrics.Histogram
+	// QueuedResponses is the amount of responses waiting in stream queues.
+	QueuedResponses metrics.Gauge
+	// DroppedResponses counts responses dropped due to stream queue overflow.
+	DroppedResponses metrics.Counter
+	// Disconnects counts streams closed due to stream queue overflow.
+	Disconnects metrics.Counter
+}
+
+// responseStream is implemented by every server stream of Response messages.
+type responseStream interface {
+	Send(*gengrpc.Response) error
+	Context() context.Context
+}
+
+// sender numbers responses sent over a stream and records them in replay buffer,
+// so that a client can resume the stream (after reconnect) from where it left off.
+//
+// Responses are sent by a separate writer go-routine through a bounded queue, so a slow client
+// doesn't block whoever produces the responses, FlowControl decides what to do when the client falls too far behind.
+//
+// sender must be stopped once the stream is done.
+type sender struct {
+	srv *Server
+
+	stream responseStream
+	method string
+
+	// session is empty when responses aren't recorded in replay buffer (see newLiveSender).
+	session string
+	seq     uint64
+	// replayed is the amount of responses replayed when continuing the session.
+	replayed int
+
+	queue *flow.Queue
+	// final is sent after everything in the queue, it must be set before queue is closed.
+	final *gengrpc.Response
+
+	// cancel makes writer quit without waiting for the queue to be drained.
+	cancel context.CancelFunc
+	// done is closed once writer quits, writeErr (if any) is set by then.
+	done     chan struct{}
+	writeErr error
+
+	stopOnce sync.Once
+
+	// stats of this very stream.
+	sent    int
+	dropped int
+	maxLag  time.Duration
+}
+
+// newSender starts a new session, or continues the one resumeToken refers to (if it's set).
+//
+// When continuing a session, responses the client has missed are replayed before newSender returns.
+func (srv *Server) newSender(stream responseStream, method string, resumeToken string) (*sender, error) {
+	session := ""
+	seq := uint64(0)
+	replayed := 0
+
+	if resumeToken == "" {
+		var err error
+
+		session, err = replay.NewSession()
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "start new session, err: %v", err)
+		}
+	} else {
+		token, err := replay.ParseToken(resumeToken)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+
+		seq, replayed, err = srv.replayMissed(stream, token)
+		if err != nil {
+			return nil, err
+		}
+
+		session = token.Session
+	}
+
+	return srv.startSender(stream, method, session, seq, replayed), nil
+}
+
+// newLiveSender returns sender that doesn't record responses in replay buffer, it's meant for streams that can't be resumed
+// (responses are still numbered, but they carry no resume token).
+func (srv *Server) newLiveSender(stream responseStream, method string) *sender {
+	return srv.startSender(stream, method, "", 0, 0)
+}
+
+func (srv *Server) startSender(stream responseStream, method string, session string, seq uint64, replayed int) *sender {
+	writerCtx, cancel := context.WithCancel(stream.Context())
+
+	s := &sender{
+		srv:      srv,
+		stream:   stream,
+		method:   method,
+		session:  session,
+		seq:      seq,
+		replayed: replayed,
+		queue:    flow.NewQueue(srv.flow.QueueSize, srv.flow.Policy),
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+
+	go s.write(writerCtx)
+
+	return s
+}
+
+// replayMissed sends the responses that follow token, it returns the sequence number of the last one
+// along with the amount of responses sent.
+func (srv *Server) replayMissed(stream responseStream, token replay.Token) (uint64, int, error) {
+	items, head, err := srv.replay.Since(stream.Context(), token.Session, token.Seq)
+	if errors.Is(err, streaming.ErrResumeTokenInvalid) {
+		return 0, 0, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if errors.Is(err, streaming.ErrResumeTokenExpired) {
+		return 0, 0, status.Error(codes.OutOfRange, err.Error())
+	}
+	if err != nil {
+		return 0, 0, status.Errorf(codes.Internal, "get missed responses, err: %v", err)
+	}
+
+	for _, item := range items {
+		resp := &gengrpc.Response{}
+
+		err := proto.Unmarshal(item, resp)
+		if err != nil {
+			return 0, 0, status.Errorf(codes.Internal, "unmarshal missed response, err: %v", err)
+		}
+
+		err = stream.Send(resp)
+		if err != nil {
+			return 0, 0, fmt.Errorf("send missed response, err: %w", err)
+		}
+	}
+
+	return head, len(items), nil
+}
+
+// send queues resp to be sent to the client.
+func (s *sender) send(resp *gengrpc.Response) error {
+	select {
+	case <-s.done:
+		return s.writeErr
+	default:
+	}
+
+	s.seq++
+
+	resp.Sequence = s.seq
+
+	if s.session != "" {
+		resp.ResumeToken = replay.Token{Session: s.session, Seq: s.seq}.String()
+
+		item, err := proto.Marshal(resp)
+		if err != nil {
+			return fmt.Errorf("marshal response, err: %w", err)
+		}
+
+		// Replay is best-effort, we'd rather deliver the response without it than not deliver it at all.
+		// Such response carries no resume token though, since the stream can't be resumed from it
+		// (the client keeps the token of the last response recorded).
+		//
+		// Note, recording costs synchronous writes to replay buffer storage (see replay.Buffer.Append) per response.
+		err = s.srv.replay.Append(s.stream.Context(), s.session, s.seq, item)
+		if err != nil {
+			_ = level.Error(s.srv.logger).Log("err", fmt.Errorf("append response to replay buffer, err: %w", err))
+
+			resp.ResumeToken = ""
+		}
+	}
+
+	dropped, err := s.queue.Push(flow.Item{
+		Key:        resp.GetUrl(),
+		Value:      resp,
+		EnqueuedAt: time.Now(),
+	})
+	if errors.Is(err, flow.ErrOverflow) {
+		s.srv.metrics.Disconnects.With("method", s.method).Add(1)
+
+		return status.Error(codes.ResourceExhausted, "client doesn't keep up with the stream")
+	}
+	if err != nil {
+		return fmt.Errorf("queue response, err: %w", err)
+	}
+
+	if dropped {
+		s.dropped++
+		s.srv.metrics.DroppedResponses.With("method", s.method).Add(1)
+	} else {
+		s.srv.metrics.QueuedResponses.With("method", s.method).Add(1)
+	}
+
+	return nil
+}
+
+// finish closes the stream once its context (obtained with withDrain) is done.
+//
+// When the server is draining, the client gets a final GOING_AWAY response so that it knows to reconnect.
+func (s *sender) finish() error {
+	if err := s.stream.Context().Err(); err != nil {
+		return status.FromContextError(err).Err()
+	}
+
+	return s.close(&gengrpc.Response{
+		Status: gengrpc.Response_GOING_AWAY,
+	})
+}
+
+// close waits until all the queued responses (followed by final, if it's set) are sent.
+func (s *sender) close(final *gengrpc.Response) error {
+	s.final = final
+	s.queue.Close()
+
+	<-s.done
+
+	s.stop()
+
+	return s.writeErr
+}
+
+// stop makes writer quit without waiting for the queued responses to be sent.
+func (s *sender) stop() {
+	s.stopOnce.Do(func() {
+		s.cancel()
+
+		<-s.done
+
+		// Whatever is left in the queue is never going to be sent.
+		s.srv.metrics.QueuedResponses.With("method", s.method).Add(-float64(s.queue.Len()))
+
+		_ = level.Info(s.srv.logger).Log(
+			"msg", "stream is done",
+			"method", s.method,
+			"sent", s.sent,
+			"dropped", s.dropped,
+			"max_lag", s.maxLag,
+		)
+	})
+}
+
+// write sends queued responses to the client until the queue is closed and drained, or ctx is done.
+func (s *sender) write(ctx context.Context) {
+	defer close(s.done)
+
+	for {
+		item, err := s.queue.Pop(ctx)
+		if errors.Is(err, flow.ErrClosed) {
+			if s.final != nil {
+				err := s.stream.Send(s.final)
+				if err != nil {
+					s.writeErr = fmt.Errorf("send final response, err: %w", err)
+				}
+			}
+			return
+		}
+		if err != nil {
+			return
+		}
+
+		s.srv.metrics.QueuedResponses.With("method", s.method).Add(-1)
+
+		lag := time.Since(item.EnqueuedAt)
+		if lag > s.maxLag {
+			s.maxLag = lag
+		}
+		s.srv.metrics.SendLag.With("method", s.method).Observe(lag.Seconds())
+
+		err = s.stream.Send(item.Value.(*gengrpc.Response))
+		if err != nil {
+			s.writeErr = fmt.Errorf("send response, err: %w", err)
+			return
+		}
+
+		s.sent++
+	}
+}
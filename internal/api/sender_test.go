@@ -0,0 +1,166 @@
+package api_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/metrics/discard"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	gengrpc "github.com/LasTshaMAN/streaming/gen/grpc"
+	"github.com/LasTshaMAN/streaming/internal/api"
+	"github.com/LasTshaMAN/streaming/internal/flow"
+)
+
+func TestSender_Overflow(t *testing.T) {
+	const (
+		queueSize = 2
+		count     = 10
+	)
+
+	ctx := context.Background()
+
+	type overflowMetrics struct {
+		queued       *fakeGauge
+		dropped      *fakeCounter
+		disconnected *fakeCounter
+	}
+
+	// setup returns the server with stream queues of queueSize, and the stream that doesn't accept anything
+	// until it's unblocked.
+	setup := func(policy flow.Policy) (*api.Server, *fakeStream, overflowMetrics) {
+		m := overflowMetrics{
+			queued:       &fakeGauge{},
+			dropped:      &fakeCounter{},
+			disconnected: &fakeCounter{},
+		}
+
+		srv := newServer(serverOptions{
+			flow: api.FlowControl{QueueSize: queueSize, Policy: policy},
+			metrics: api.Metrics{
+				SendLag:          discard.NewHistogram(),
+				QueuedResponses:  m.queued,
+				DroppedResponses: m.dropped,
+				Disconnects:      m.disconnected,
+			},
+		})
+
+		stream := newStream(ctx)
+		stream.block = make(chan struct{})
+
+		return srv, stream, m
+	}
+
+	t.Run("disconnect", func(t *testing.T) {
+		srv, stream, m := setup(flow.PolicyDisconnect)
+
+		errs := make(chan error, 1)
+		go func() {
+			errs <- srv.GetRandomDataStream(&gengrpc.Request{Urls: []string{urlA}, Count: count}, stream)
+		}()
+
+		assert.Eventually(t, func() bool {
+			return m.disconnected.value() == 1
+		}, time.Second, time.Millisecond)
+
+		close(stream.block)
+
+		err := <-errs
+		assert.Equal(t, codes.ResourceExhausted, status.Code(err), err)
+		assert.True(t, len(stream.responses()) < count)
+		assert.Equal(t, float64(0), m.dropped.value())
+		assert.Equal(t, float64(0), m.queued.value())
+	})
+
+	for _, policy := range []flow.Policy{flow.PolicyDropOldest, flow.PolicyDropNewest, flow.PolicyCoalesce} {
+		policy := policy
+
+		t.Run(string(policy), func(t *testing.T) {
+			srv, stream, m := setup(policy)
+
+			errs := make(chan error, 1)
+			go func() {
+				// Every response comes from the same URL, so that they coalesce.
+				errs <- srv.GetRandomDataStream(&gengrpc.Request{Urls: []string{urlA}, Count: count}, stream)
+			}()
+
+			// While the client isn't reading, the writer is stuck with a single response, the rest of responses
+			// are either queued or dropped.
+			assert.Eventually(t, func() bool {
+				return m.queued.value()+m.dropped.value()+1 == count
+			}, time.Second, time.Millisecond)
+			assert.True(t, m.queued.value() > 0 && m.queued.value() <= queueSize, m.queued.value())
+
+			close(stream.block)
+
+			err := <-errs
+			assert.Nil(t, err)
+
+			sent := stream.responses()
+			assert.True(t, len(sent) < count, len(sent))
+			assert.Equal(t, float64(count-len(sent)), m.dropped.value())
+			assert.Equal(t, float64(0), m.queued.value())
+			assert.Equal(t, float64(0), m.disconnected.value())
+		})
+	}
+}
+
+// fakeCounter ignores labels.
+type fakeCounter struct {
+	mu  sync.Mutex
+	sum float64
+}
+
+func (c *fakeCounter) With(...string) metrics.Counter {
+	return c
+}
+
+func (c *fakeCounter) Add(delta float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.sum += delta
+}
+
+func (c *fakeCounter) value() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.sum
+}
+
+// fakeGauge ignores labels.
+type fakeGauge struct {
+	mu  sync.Mutex
+	cur float64
+}
+
+func (g *fakeGauge) With(...string) metrics.Gauge {
+	return g
+}
+
+func (g *fakeGauge) Set(value float64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.cur = value
+}
+
+func (g *fakeGauge) Add(delta float64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.cur += delta
+}
+
+func (g *fakeGauge) value() float64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.cur
+}
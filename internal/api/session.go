@@ -0,0 +1,94 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/go-kit/kit/log/level"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	gengrpc "github.com/LasTshaMAN/streaming/gen/grpc"
+	"github.com/LasTshaMAN/streaming/internal/watch"
+)
+
+func (srv *Server) Session(stream gengrpc.StreamingService_SessionServer) error {
+	_ = level.Info(srv.logger).Log("msg", "received a session request")
+
+	if srv.isDraining() {
+		return errServerDraining
+	}
+
+	ctx, cancel := srv.withDrain(stream.Context())
+	defer cancel()
+
+	// Session can't be resumed (the subscriptions are only known to this very stream), so there is no point
+	// in recording its responses for replay.
+	sender := srv.newLiveSender(stream, "Session")
+	defer sender.stop()
+
+	sub := srv.hub.Subscribe()
+	defer sub.Close()
+
+	controlErrs := make(chan error, 1)
+
+	go func() {
+		defer cancel()
+
+		controlErrs <- srv.control(stream, sub)
+	}()
+
+	for {
+		u, err := sub.Next(ctx)
+		if err != nil {
+			select {
+			case controlErr := <-controlErrs:
+				if controlErr != nil {
+					return controlErr
+				}
+			default:
+			}
+			return sender.finish()
+		}
+
+		err = sender.send(newResponse(u.URL, u.Data, u.TTL, u.Err, u.AsOf))
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// control applies control messages coming from the client to sub, until the client stops sending them.
+//
+// Returned error terminates the session.
+func (srv *Server) control(stream gengrpc.StreamingService_SessionServer, sub *watch.Subscription) error {
+	for {
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			// Client isn't going to change the session anymore, but it's still interested in the data.
+			<-stream.Context().Done()
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("receive control message, err: %w", err)
+		}
+
+		switch c := req.GetControl().(type) {
+		case *gengrpc.SessionRequest_Subscribe_:
+			err := srv.checkURLs(c.Subscribe.GetUrls()...)
+			if err != nil {
+				return err
+			}
+			sub.Add(c.Subscribe.GetUrls()...)
+		case *gengrpc.SessionRequest_Unsubscribe_:
+			sub.Remove(c.Unsubscribe.GetUrls()...)
+		case *gengrpc.SessionRequest_Pause_:
+			sub.Pause()
+		case *gengrpc.SessionRequest_Resume_:
+			sub.Resume()
+		default:
+			return status.Error(codes.InvalidArgument, "unknown control message")
+		}
+	}
+}
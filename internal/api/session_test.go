@@ -0,0 +1,204 @@
+package api_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	gengrpc "github.com/LasTshaMAN/streaming/gen/grpc"
+)
+
+func TestServer_Session(t *testing.T) {
+	// settle is how long it takes for the server to act on a control message.
+	const settle = 20 * time.Millisecond
+
+	ctx := context.Background()
+
+	// setup starts a session with the server whose data changes every few milliseconds.
+	setup := func() (*changingProvider, *fakeSessionStream, chan error) {
+		provider := &changingProvider{}
+		srv := newServer(serverOptions{provider: provider})
+
+		stream := newSessionStream(ctx)
+		errs := make(chan error, 1)
+		go func() {
+			errs <- srv.Session(stream)
+		}()
+
+		return provider, stream, errs
+	}
+
+	subscribe := func(urls ...string) *gengrpc.SessionRequest {
+		return &gengrpc.SessionRequest{
+			Control: &gengrpc.SessionRequest_Subscribe_{Subscribe: &gengrpc.SessionRequest_Subscribe{Urls: urls}},
+		}
+	}
+
+	t.Run("subscribe", func(t *testing.T) {
+		_, stream, _ := setup()
+		defer stream.cancel()
+
+		stream.recv <- subscribe(urlA)
+
+		assert.Eventually(t, func() bool {
+			return len(stream.responses()) > 1
+		}, time.Second, time.Millisecond)
+		assert.Equal(t, 0, count(stream.responses(), urlB))
+	})
+
+	t.Run("unsubscribe", func(t *testing.T) {
+		_, stream, _ := setup()
+		defer stream.cancel()
+
+		stream.recv <- subscribe(urlA, urlB)
+		assert.Eventually(t, func() bool {
+			return count(stream.responses(), urlA) > 0
+		}, time.Second, time.Millisecond)
+
+		stream.recv <- &gengrpc.SessionRequest{
+			Control: &gengrpc.SessionRequest_Unsubscribe_{Unsubscribe: &gengrpc.SessionRequest_Unsubscribe{Urls: []string{urlA}}},
+		}
+		time.Sleep(settle)
+
+		gotA, gotB := count(stream.responses(), urlA), count(stream.responses(), urlB)
+		time.Sleep(5 * settle)
+		assert.Equal(t, gotA, count(stream.responses(), urlA))
+		assert.True(t, count(stream.responses(), urlB) > gotB)
+	})
+
+	t.Run("pause and resume", func(t *testing.T) {
+		_, stream, _ := setup()
+		defer stream.cancel()
+
+		stream.recv <- subscribe(urlA, urlB)
+		assert.Eventually(t, func() bool {
+			return len(stream.responses()) > 0
+		}, time.Second, time.Millisecond)
+
+		stream.recv <- &gengrpc.SessionRequest{Control: &gengrpc.SessionRequest_Pause_{Pause: &gengrpc.SessionRequest_Pause{}}}
+		time.Sleep(settle)
+
+		got := len(stream.responses())
+		time.Sleep(5 * settle)
+		assert.Equal(t, got, len(stream.responses()))
+
+		stream.recv <- &gengrpc.SessionRequest{Control: &gengrpc.SessionRequest_Resume_{Resume: &gengrpc.SessionRequest_Resume{}}}
+
+		// Only the latest version of the data per URL is sent once the session is resumed.
+		assert.Eventually(t, func() bool {
+			return len(stream.responses()) >= got+2
+		}, time.Second, time.Millisecond)
+		resumed := stream.responses()[got:]
+		assert.ElementsMatch(t, []string{urlA, urlB}, []string{resumed[0].GetUrl(), resumed[1].GetUrl()})
+	})
+
+	t.Run("subscriptions are torn down once the stream is done", func(t *testing.T) {
+		provider, stream, errs := setup()
+
+		stream.recv <- subscribe(urlA, urlB)
+		assert.Eventually(t, func() bool {
+			return len(stream.responses()) > 0
+		}, time.Second, time.Millisecond)
+
+		stream.cancel()
+		assert.NotNil(t, <-errs)
+		time.Sleep(settle)
+
+		// Nobody refreshes the data anymore.
+		calls := provider.callCount()
+		time.Sleep(5 * settle)
+		assert.Equal(t, calls, provider.callCount())
+	})
+
+	t.Run("subscriptions stay after the client stops sending control messages", func(t *testing.T) {
+		_, stream, errs := setup()
+		defer stream.cancel()
+
+		stream.recv <- subscribe(urlA)
+		close(stream.recv)
+
+		got := len(stream.responses())
+		assert.Eventually(t, func() bool {
+			return len(stream.responses()) > got+1
+		}, time.Second, time.Millisecond)
+		assert.Empty(t, errs)
+	})
+
+	t.Run("subscribe to unknown url", func(t *testing.T) {
+		_, stream, errs := setup()
+		defer stream.cancel()
+
+		stream.recv <- subscribe("unknown url")
+
+		err := <-errs
+		assert.Equal(t, codes.InvalidArgument, status.Code(err), err)
+	})
+
+	t.Run("draining server refuses new sessions", func(t *testing.T) {
+		srv := newServer(serverOptions{})
+		srv.Drain()
+
+		err := srv.Session(newSessionStream(ctx))
+		assert.Equal(t, codes.Unavailable, status.Code(err), err)
+	})
+}
+
+// changingProvider returns new data every time it's asked, the data expires in a few milliseconds.
+type changingProvider struct {
+	calls int64
+}
+
+func (p *changingProvider) Get(_ context.Context, url string) (string, time.Duration, error) {
+	n := atomic.AddInt64(&p.calls, 1)
+
+	return fmt.Sprintf("data of %s, version %d", url, n), 5 * time.Millisecond, nil
+}
+
+func (p *changingProvider) callCount() int64 {
+	return atomic.LoadInt64(&p.calls)
+}
+
+// fakeSessionStream receives control messages from recv, closing recv stands for the client closing its side of the stream.
+type fakeSessionStream struct {
+	*fakeStream
+
+	recv chan *gengrpc.SessionRequest
+}
+
+func newSessionStream(ctx context.Context) *fakeSessionStream {
+	return &fakeSessionStream{
+		fakeStream: newStream(ctx),
+		recv:       make(chan *gengrpc.SessionRequest, 10),
+	}
+}
+
+func (s *fakeSessionStream) Recv() (*gengrpc.SessionRequest, error) {
+	select {
+	case req, ok := <-s.recv:
+		if !ok {
+			return nil, io.EOF
+		}
+		return req, nil
+	case <-s.ctx.Done():
+		return nil, status.FromContextError(s.ctx.Err()).Err()
+	}
+}
+
+// count returns the amount of responses that carry the data behind url.
+func count(responses []*gengrpc.Response, url string) int {
+	result := 0
+	for _, resp := range responses {
+		if resp.GetUrl() == url {
+			result++
+		}
+	}
+
+	return result
+}
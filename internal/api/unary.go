@@ -0,0 +1,111 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/LasTshaMAN/streaming"
+	gengrpc "github.com/LasTshaMAN/streaming/gen/grpc"
+)
+
+// maxGetManyURLs limits the amount of URLs a single GetMany request can ask for.
+const maxGetManyURLs = 100
+
+func (srv *Server) Get(ctx context.Context, req *gengrpc.GetRequest) (*gengrpc.Response, error) {
+	err := srv.checkURLs(req.GetUrl())
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := srv.get(ctx, req.GetUrl())
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.GetStatus() {
+	case gengrpc.Response_UNAVAILABLE:
+		st, detailsErr := status.New(codes.Unavailable, streaming.ErrDataCurrentlyUnavailable.Error()).WithDetails(
+			&errdetails.RetryInfo{RetryDelay: resp.GetTtl()},
+		)
+		if detailsErr != nil {
+			return nil, status.Error(codes.Unavailable, streaming.ErrDataCurrentlyUnavailable.Error())
+		}
+		return nil, st.Err()
+	case gengrpc.Response_ERROR:
+		return nil, status.Errorf(codes.Internal, "get data, url: %s", req.GetUrl())
+	}
+
+	return resp, nil
+}
+
+func (srv *Server) GetMany(ctx context.Context, req *gengrpc.GetManyRequest) (*gengrpc.GetManyResponse, error) {
+	urls := req.GetUrls()
+	if len(urls) > maxGetManyURLs {
+		return nil, status.Errorf(codes.InvalidArgument, "too many urls: %d, at most %d allowed", len(urls), maxGetManyURLs)
+	}
+
+	err := srv.checkURLs(urls...)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		responses = make([]*gengrpc.Response, len(urls))
+		errs      = make([]error, len(urls))
+
+		wg sync.WaitGroup
+	)
+
+	for i, url := range urls {
+		wg.Add(1)
+
+		go func(i int, url string) {
+			defer wg.Done()
+
+			responses[i], errs[i] = srv.get(ctx, url)
+		}(i, url)
+	}
+
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &gengrpc.GetManyResponse{
+		Responses: responses,
+	}, nil
+}
+
+// get fetches the data behind url, the only error it returns is the one caused by ctx being done.
+func (srv *Server) get(ctx context.Context, url string) (*gengrpc.Response, error) {
+	data, ttl, err := srv.provider.Get(ctx, url)
+	if err != nil && ctx.Err() != nil {
+		return nil, status.FromContextError(ctx.Err()).Err()
+	}
+	if err != nil && !errors.Is(err, streaming.ErrDataCurrentlyUnavailable) {
+		_ = level.Error(srv.logger).Log("err", fmt.Errorf("get data, url: %s, err: %w", url, err))
+	}
+
+	return newResponse(url, data, ttl, err, time.Now()), nil
+}
+
+func (srv *Server) checkURLs(urls ...string) error {
+	for _, url := range urls {
+		if _, ok := srv.urls[url]; !ok {
+			return status.Errorf(codes.InvalidArgument, "url: %s, err: %v", url, streaming.ErrUnknownURL)
+		}
+	}
+
+	return nil
+}
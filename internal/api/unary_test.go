@@ -0,0 +1,109 @@
+package api_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/LasTshaMAN/streaming"
+	gengrpc "github.com/LasTshaMAN/streaming/gen/grpc"
+)
+
+func TestServer_Get(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("data", func(t *testing.T) {
+		srv := newServer(serverOptions{})
+
+		before := time.Now()
+		resp, err := srv.Get(ctx, &gengrpc.GetRequest{Url: urlA})
+		require.Nil(t, err)
+		assert.Equal(t, gengrpc.Response_OK, resp.GetStatus())
+		assert.Equal(t, urlA, resp.GetUrl())
+		assert.Equal(t, "data of url a", string(resp.GetBody()))
+		assert.Equal(t, dataTTL, resp.GetTtl().AsDuration())
+		// ttl counts from the moment the server got the data.
+		asOf := resp.GetAsOf().AsTime()
+		assert.True(t, !asOf.Before(before) && !asOf.After(time.Now()), asOf)
+	})
+
+	t.Run("data is currently unavailable", func(t *testing.T) {
+		srv := newServer(serverOptions{
+			provider: &fakeProvider{errs: map[string]error{urlA: streaming.ErrDataCurrentlyUnavailable}},
+		})
+
+		_, err := srv.Get(ctx, &gengrpc.GetRequest{Url: urlA})
+		st := status.Convert(err)
+		assert.Equal(t, codes.Unavailable, st.Code(), err)
+
+		// The client is told when it makes sense to try again.
+		require.Len(t, st.Details(), 1)
+		retryInfo, ok := st.Details()[0].(*errdetails.RetryInfo)
+		require.True(t, ok, st.Details()[0])
+		assert.Equal(t, dataTTL, retryInfo.GetRetryDelay().AsDuration())
+	})
+
+	t.Run("error", func(t *testing.T) {
+		srv := newServer(serverOptions{
+			provider: &fakeProvider{errs: map[string]error{urlA: errors.New("connection refused")}},
+		})
+
+		_, err := srv.Get(ctx, &gengrpc.GetRequest{Url: urlA})
+		assert.Equal(t, codes.Internal, status.Code(err), err)
+	})
+
+	t.Run("unknown url", func(t *testing.T) {
+		srv := newServer(serverOptions{})
+
+		_, err := srv.Get(ctx, &gengrpc.GetRequest{Url: "unknown url"})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err), err)
+	})
+}
+
+func TestServer_GetMany(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("status per url", func(t *testing.T) {
+		srv := newServer(serverOptions{
+			provider: &fakeProvider{errs: map[string]error{urlB: streaming.ErrDataCurrentlyUnavailable}},
+		})
+
+		resp, err := srv.GetMany(ctx, &gengrpc.GetManyRequest{Urls: []string{urlB, urlA}})
+		require.Nil(t, err)
+		require.Len(t, resp.GetResponses(), 2)
+		assert.Equal(t, urlB, resp.GetResponses()[0].GetUrl())
+		assert.Equal(t, gengrpc.Response_UNAVAILABLE, resp.GetResponses()[0].GetStatus())
+		assert.Equal(t, urlA, resp.GetResponses()[1].GetUrl())
+		assert.Equal(t, gengrpc.Response_OK, resp.GetResponses()[1].GetStatus())
+	})
+
+	t.Run("unknown url", func(t *testing.T) {
+		srv := newServer(serverOptions{})
+
+		_, err := srv.GetMany(ctx, &gengrpc.GetManyRequest{Urls: []string{urlA, "unknown url"}})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err), err)
+	})
+
+	t.Run("too many urls", func(t *testing.T) {
+		srv := newServer(serverOptions{})
+
+		// maxGetManyURLs is 100.
+		urls := make([]string, 101)
+		for i := range urls {
+			urls[i] = urlA
+		}
+
+		_, err := srv.GetMany(ctx, &gengrpc.GetManyRequest{Urls: urls})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err), err)
+
+		_, err = srv.GetMany(ctx, &gengrpc.GetManyRequest{Urls: urls[:100]})
+		assert.Nil(t, err)
+	})
+}
@@ -0,0 +1,50 @@
+package api
+
+import (
+	"github.com/go-kit/kit/log/level"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	gengrpc "github.com/LasTshaMAN/streaming/gen/grpc"
+)
+
+func (srv *Server) Watch(req *gengrpc.WatchRequest, stream gengrpc.StreamingService_WatchServer) error {
+	_ = level.Info(srv.logger).Log("msg", "received a watch request")
+
+	if srv.isDraining() {
+		return errServerDraining
+	}
+
+	ctx, cancel := srv.withDrain(stream.Context())
+	defer cancel()
+
+	if len(req.GetUrls()) == 0 {
+		return status.Error(codes.InvalidArgument, "no urls to watch")
+	}
+
+	err := srv.checkURLs(req.GetUrls()...)
+	if err != nil {
+		return err
+	}
+
+	sender, err := srv.newSender(stream, "Watch", req.GetResumeToken())
+	if err != nil {
+		return err
+	}
+	defer sender.stop()
+
+	sub := srv.hub.Subscribe(req.GetUrls()...)
+	defer sub.Close()
+
+	for {
+		u, err := sub.Next(ctx)
+		if err != nil {
+			return sender.finish()
+		}
+
+		err = sender.send(newResponse(u.URL, u.Data, u.TTL, u.Err, u.AsOf))
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -10,10 +10,116 @@ import (
 
 // Config contains all configuration settings.
 type Config struct {
-	URLs             []string      `yaml:"URLs"`
-	MinTimeout       time.Duration `yaml:"MinTimeout"`
-	MaxTimeout       time.Duration `yaml:"MaxTimeout"`
-	NumberOfRequests int           `yaml:"NumberOfRequests"`
+	URLs []string `yaml:"URLs"`
+	// URLGroups maps group name to the URLs (out of URLs) this group consists of.
+	URLGroups        map[string][]string `yaml:"URLGroups"`
+	MinTimeout       time.Duration       `yaml:"MinTimeout"`
+	MaxTimeout       time.Duration       `yaml:"MaxTimeout"`
+	NumberOfRequests int                 `yaml:"NumberOfRequests"`
+	// ReplayBufferSize is the amount of latest responses kept per stream for replay (when client resumes the stream).
+	ReplayBufferSize int `yaml:"ReplayBufferSize"`
+	// ReplayTTL is how long responses are kept for replay.
+	ReplayTTL time.Duration `yaml:"ReplayTTL"`
+	// DrainTimeout is how long the server waits for open streams to finish when shutting down,
+	// 0 means open streams are closed right away.
+	DrainTimeout time.Duration `yaml:"DrainTimeout"`
+	// StreamQueueSize is the amount of responses a stream can hold on to while its client isn't reading them.
+	StreamQueueSize int `yaml:"StreamQueueSize"`
+	// StreamOverflowPolicy decides what happens when a stream queue is full,
+	// one of: drop_oldest, drop_newest, coalesce, disconnect.
+	StreamOverflowPolicy string `yaml:"StreamOverflowPolicy"`
+	// DebugAddr is the address to serve debug HTTP endpoints (such as metrics) on.
+	DebugAddr string `yaml:"DebugAddr"`
+	// HealthCheckInterval is how often the health of service dependencies (Redis, the internet) is checked.
+	HealthCheckInterval time.Duration `yaml:"HealthCheckInterval"`
+	// HealthCheckTimeout limits the duration of a single health check round.
+	HealthCheckTimeout time.Duration `yaml:"HealthCheckTimeout"`
+	// HealthFailureThreshold is the amount of consecutive failed health checks it takes to become NOT_SERVING.
+	HealthFailureThreshold int `yaml:"HealthFailureThreshold"`
+	// HealthSuccessThreshold is the amount of consecutive successful health checks it takes to become SERVING again.
+	HealthSuccessThreshold int `yaml:"HealthSuccessThreshold"`
+	// UpstreamMinSuccessRatio is the minimal share of successful requests to the internet for the service to be healthy.
+	UpstreamMinSuccessRatio float64 `yaml:"UpstreamMinSuccessRatio"`
+	// UpstreamMinRequests is the minimal amount of requests to the internet to judge the success ratio by.
+	UpstreamMinRequests int `yaml:"UpstreamMinRequests"`
+	// RedisLockAddrs are the addresses of independent Redis nodes distributed locks are held in,
+	// a lock is acquired once the majority of nodes agrees on it (so the majority of nodes must be up).
+	RedisLockAddrs []string `yaml:"RedisLockAddrs"`
+	// InmemStorage bounds the storage of in-memory cache tier.
+	InmemStorage InmemStorage `yaml:"InmemStorage"`
+	// InmemProxy configures in-memory cache tier (it sits in front of Redis tier).
+	InmemProxy ProxyTier `yaml:"InmemProxy"`
+	// RedisProxy configures Redis cache tier (it sits in front of the internet).
+	RedisProxy ProxyTier `yaml:"RedisProxy"`
+}
+
+// Lock modes, see ProxyTier.LockMode.
+const (
+	// LockModeStripe hashes URLs into a fixed amount of locks, so unrelated URLs might wait on each other.
+	LockModeStripe = "stripe"
+	// LockModeURL creates a lock per URL on demand.
+	LockModeURL = "url"
+)
+
+// InmemStorage configures in-memory storage (see inmemory.Storage).
+type InmemStorage struct {
+	// MaxEntries is the maximal amount of entries in storage, 0 means no limit.
+	MaxEntries int `yaml:"MaxEntries"`
+	// MaxBytes is the maximal size of entries (URLs and data) in storage, 0 means no limit.
+	// Storage is split into shards with a share of the budget each, an entry can take up to the budget of its shard.
+	MaxBytes int64 `yaml:"MaxBytes"`
+	// EvictionPolicy decides which entries are evicted once storage is full, one of: lru, lfu, tinylfu.
+	EvictionPolicy string `yaml:"EvictionPolicy"`
+}
+
+// ProxyTier configures a single cache tier (see proxy.Proxy).
+type ProxyTier struct {
+	// LockMode decides how URLs map to the locks this tier coordinates fetches with, one of: stripe, url.
+	LockMode string `yaml:"LockMode"`
+	// Grace is how long data is kept past its ttl, stale data is served right away while being refreshed
+	// and when the tier below fails to provide fresh data.
+	Grace time.Duration `yaml:"Grace"`
+	// RefreshAheadShare is the share of ttl (between 0 and 1) after which hot data is refreshed in background
+	// before it expires, 0 disables refresh-ahead.
+	RefreshAheadShare float64 `yaml:"RefreshAheadShare"`
+	// RefreshAheadMinHits is the amount of requests within RefreshAheadWindow it takes for data to become hot.
+	RefreshAheadMinHits int           `yaml:"RefreshAheadMinHits"`
+	RefreshAheadWindow  time.Duration `yaml:"RefreshAheadWindow"`
+	// DegradedPolicy decides what this tier does while its storage (or locker) is unavailable,
+	// one of: fail_fast, bypass (go straight to the tier below), l1_only (serve only from the tier in front of this one).
+	DegradedPolicy string `yaml:"DegradedPolicy"`
+	// BreakerFailureThreshold is the amount of consecutive storage failures it takes to switch to degraded mode,
+	// 0 means the tier never switches to it (but it still follows DegradedPolicy when storage fails).
+	BreakerFailureThreshold int `yaml:"BreakerFailureThreshold"`
+	// BreakerProbeInterval is how often storage is probed for recovery while in degraded mode.
+	BreakerProbeInterval time.Duration `yaml:"BreakerProbeInterval"`
+}
+
+func (t ProxyTier) validate() error {
+	if t.LockMode != LockModeStripe && t.LockMode != LockModeURL {
+		return fmt.Errorf("LockMode must be one of: %s, %s, got: %q", LockModeStripe, LockModeURL, t.LockMode)
+	}
+	if t.Grace < 0 {
+		return fmt.Errorf("Grace must not be negative, got: %s", t.Grace)
+	}
+	if t.RefreshAheadShare < 0 || t.RefreshAheadShare >= 1 {
+		return fmt.Errorf("RefreshAheadShare must be within [0, 1), got: %v", t.RefreshAheadShare)
+	}
+	if t.RefreshAheadShare > 0 && t.RefreshAheadWindow <= 0 {
+		return fmt.Errorf("RefreshAheadWindow must be positive, got: %s", t.RefreshAheadWindow)
+	}
+	if t.RefreshAheadShare > 0 && t.RefreshAheadMinHits <= 0 {
+		// Otherwise every URL would be considered hot, even the one that isn't requested at all.
+		return fmt.Errorf("RefreshAheadMinHits must be positive, got: %d", t.RefreshAheadMinHits)
+	}
+	if t.BreakerFailureThreshold < 0 {
+		return fmt.Errorf("BreakerFailureThreshold must not be negative, got: %d", t.BreakerFailureThreshold)
+	}
+	if t.BreakerFailureThreshold > 0 && t.BreakerProbeInterval <= 0 {
+		return fmt.Errorf("BreakerProbeInterval must be positive, got: %s", t.BreakerProbeInterval)
+	}
+
+	return nil
 }
 
 // Parse YAML configuration file.
@@ -38,5 +144,73 @@ func Parse(filePath string) (Config, error) {
 		return c, fmt.Errorf("cannot close file: %s, err: %w", filePath, err)
 	}
 
+	err = c.validate()
+	if err != nil {
+		return c, fmt.Errorf("invalid config file: %s, err: %w", filePath, err)
+	}
+
 	return c, nil
 }
+
+func (c Config) validate() error {
+	if c.ReplayBufferSize <= 0 {
+		return fmt.Errorf("ReplayBufferSize must be positive, got: %d", c.ReplayBufferSize)
+	}
+	if c.DrainTimeout < 0 {
+		return fmt.Errorf("DrainTimeout must not be negative, got: %s", c.DrainTimeout)
+	}
+	if c.StreamQueueSize <= 0 {
+		return fmt.Errorf("StreamQueueSize must be positive, got: %d", c.StreamQueueSize)
+	}
+	if c.HealthCheckInterval <= 0 {
+		return fmt.Errorf("HealthCheckInterval must be positive, got: %s", c.HealthCheckInterval)
+	}
+	if c.HealthCheckTimeout <= 0 {
+		return fmt.Errorf("HealthCheckTimeout must be positive, got: %s", c.HealthCheckTimeout)
+	}
+	if c.HealthFailureThreshold <= 0 || c.HealthSuccessThreshold <= 0 {
+		return fmt.Errorf(
+			"HealthFailureThreshold and HealthSuccessThreshold must be positive, got: %d, %d",
+			c.HealthFailureThreshold,
+			c.HealthSuccessThreshold,
+		)
+	}
+	if c.ReplayTTL < time.Second {
+		// Responses must outlive a reconnect of the client to be of any use.
+		return fmt.Errorf("ReplayTTL must be at least 1s, got: %s", c.ReplayTTL)
+	}
+
+	if len(c.RedisLockAddrs) == 0 {
+		return fmt.Errorf("RedisLockAddrs must list at least one address")
+	}
+
+	if c.InmemStorage.MaxEntries < 0 || c.InmemStorage.MaxBytes < 0 {
+		return fmt.Errorf(
+			"InmemStorage.MaxEntries and InmemStorage.MaxBytes must not be negative, got: %d, %d",
+			c.InmemStorage.MaxEntries,
+			c.InmemStorage.MaxBytes,
+		)
+	}
+
+	if err := c.InmemProxy.validate(); err != nil {
+		return fmt.Errorf("invalid InmemProxy, err: %w", err)
+	}
+	if err := c.RedisProxy.validate(); err != nil {
+		return fmt.Errorf("invalid RedisProxy, err: %w", err)
+	}
+
+	known := make(map[string]struct{}, len(c.URLs))
+	for _, url := range c.URLs {
+		known[url] = struct{}{}
+	}
+
+	for group, urls := range c.URLGroups {
+		for _, url := range urls {
+			if _, ok := known[url]; !ok {
+				return fmt.Errorf("URL group: %s refers to unknown URL: %s", group, url)
+			}
+		}
+	}
+
+	return nil
+}
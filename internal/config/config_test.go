@@ -1,10 +1,15 @@
 package config_test
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"github.com/LasTshaMAN/streaming/internal/config"
 )
@@ -22,9 +27,62 @@ func Test_configuredUsers(t *testing.T) {
 			"https://www.twitter.com",
 			"https://www.facebook.com",
 		},
-		MinTimeout:       10 * time.Second,
-		MaxTimeout:       100 * time.Second,
-		NumberOfRequests: 3,
+		URLGroups: map[string][]string{
+			"dev": {
+				"https://golang.org",
+				"https://www.github.com",
+				"https://www.gitlab.com",
+			},
+			"search": {
+				"https://www.google.com",
+				"https://www.duckduckgo.com",
+			},
+			"social": {
+				"https://www.twitter.com",
+				"https://www.facebook.com",
+			},
+		},
+		MinTimeout:              10 * time.Second,
+		MaxTimeout:              100 * time.Second,
+		NumberOfRequests:        3,
+		ReplayBufferSize:        100,
+		ReplayTTL:               5 * time.Minute,
+		DrainTimeout:            30 * time.Second,
+		StreamQueueSize:         64,
+		StreamOverflowPolicy:    "coalesce",
+		DebugAddr:               ":8081",
+		HealthCheckInterval:     5 * time.Second,
+		HealthCheckTimeout:      2 * time.Second,
+		HealthFailureThreshold:  3,
+		HealthSuccessThreshold:  2,
+		UpstreamMinSuccessRatio: 0.5,
+		UpstreamMinRequests:     10,
+		RedisLockAddrs:          []string{"localhost:6379"},
+		InmemStorage: config.InmemStorage{
+			MaxEntries:     10000,
+			MaxBytes:       256 << 20,
+			EvictionPolicy: "tinylfu",
+		},
+		InmemProxy: config.ProxyTier{
+			LockMode:                config.LockModeURL,
+			Grace:                   5 * time.Second,
+			RefreshAheadShare:       0.8,
+			RefreshAheadMinHits:     10,
+			RefreshAheadWindow:      time.Minute,
+			DegradedPolicy:          "fail_fast",
+			BreakerFailureThreshold: 0,
+			BreakerProbeInterval:    5 * time.Second,
+		},
+		RedisProxy: config.ProxyTier{
+			LockMode:                config.LockModeURL,
+			Grace:                   time.Minute,
+			RefreshAheadShare:       0.8,
+			RefreshAheadMinHits:     3,
+			RefreshAheadWindow:      time.Minute,
+			DegradedPolicy:          "bypass",
+			BreakerFailureThreshold: 5,
+			BreakerProbeInterval:    5 * time.Second,
+		},
 	}
 
 	got, err := config.Parse("../../config/config.yml")
@@ -32,3 +90,52 @@ func Test_configuredUsers(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, want, got)
 }
+
+func Test_invalidConfig(t *testing.T) {
+	valid, err := ioutil.ReadFile("../../config/config.yml")
+	require.Nil(t, err)
+
+	dir, err := ioutil.TempDir("", "config")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	// Every test breaks a single setting of a valid config.
+	tests := []struct {
+		name    string
+		setting string
+		value   string
+		err     string
+	}{
+		{
+			name:    "negative drain timeout",
+			setting: "DrainTimeout: 30s",
+			value:   "DrainTimeout: -1s",
+			err:     "DrainTimeout must not be negative",
+		},
+		{
+			name:    "no health check timeout",
+			setting: "HealthCheckTimeout: 2s",
+			value:   "HealthCheckTimeout: 0s",
+			err:     "HealthCheckTimeout must be positive",
+		},
+		{
+			name:    "no hits required for refresh-ahead",
+			setting: "  RefreshAheadMinHits: 10",
+			value:   "  RefreshAheadMinHits: 0",
+			err:     "RefreshAheadMinHits must be positive",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Contains(t, string(valid), tt.setting)
+
+			path := filepath.Join(dir, "config.yml")
+			err := ioutil.WriteFile(path, []byte(strings.Replace(string(valid), tt.setting, tt.value, 1)), 0644)
+			require.Nil(t, err)
+
+			_, err = config.Parse(path)
+			require.NotNil(t, err)
+			assert.Contains(t, err.Error(), tt.err)
+		})
+	}
+}
@@ -0,0 +1,164 @@
+// Package flow provides flow control primitives for sending data to consumers that might be slower than producers.
+package flow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Policy defines what Queue does when an item is pushed into it while it's full.
+type Policy string
+
+const (
+	// PolicyDropOldest discards the oldest queued item to make room for the new one.
+	PolicyDropOldest Policy = "drop_oldest"
+	// PolicyDropNewest discards the new item.
+	PolicyDropNewest Policy = "drop_newest"
+	// PolicyCoalesce replaces the queued item with the same key by the new one,
+	// when there is no such item it falls back to PolicyDropOldest.
+	PolicyCoalesce Policy = "coalesce"
+	// PolicyDisconnect rejects the new item with ErrOverflow, meaning the consumer should be disconnected.
+	PolicyDisconnect Policy = "disconnect"
+)
+
+// ParsePolicy returns Policy named s.
+func ParsePolicy(s string) (Policy, error) {
+	switch p := Policy(s); p {
+	case PolicyDropOldest, PolicyDropNewest, PolicyCoalesce, PolicyDisconnect:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown overflow policy: %s", s)
+	}
+}
+
+var (
+	// ErrOverflow is returned by Queue.Push when the queue is full and its policy is PolicyDisconnect.
+	ErrOverflow = errors.New("queue is full")
+	// ErrClosed is returned by Queue.Pop once the queue is closed and there is nothing left in it.
+	ErrClosed = errors.New("queue is closed")
+)
+
+// Item is a unit of data passed through Queue.
+type Item struct {
+	// Key identifies items that can replace each other (see PolicyCoalesce).
+	Key   string
+	Value interface{}
+	// EnqueuedAt lets the consumer find out how long the item has been waiting in the queue.
+	EnqueuedAt time.Time
+}
+
+// Queue is a bounded FIFO queue, it never blocks producers.
+//
+// Queue can be safely used concurrently from multiple go-routines.
+type Queue struct {
+	capacity int
+	policy   Policy
+
+	mu     sync.Mutex
+	items  []Item
+	closed bool
+
+	// notify signals that there are items in the queue (or that it's been closed).
+	notify chan struct{}
+}
+
+func NewQueue(capacity int, policy Policy) *Queue {
+	return &Queue{
+		capacity: capacity,
+		policy:   policy,
+		items:    make([]Item, 0, capacity),
+		notify:   make(chan struct{}, 1),
+	}
+}
+
+// Push adds item to the queue, when the queue is full its policy decides what to do.
+//
+// Push reports whether some item (either the new one or one of the queued) has been dropped.
+func (q *Queue) Push(item Item) (dropped bool, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return false, ErrClosed
+	}
+
+	if len(q.items) < q.capacity {
+		q.items = append(q.items, item)
+		q.signal()
+		return false, nil
+	}
+
+	switch q.policy {
+	case PolicyDropNewest:
+		return true, nil
+	case PolicyDisconnect:
+		return false, ErrOverflow
+	case PolicyCoalesce:
+		for i := range q.items {
+			if q.items[i].Key == item.Key {
+				q.items[i] = item
+				return true, nil
+			}
+		}
+	}
+
+	// PolicyDropOldest (as well as PolicyCoalesce that hasn't found anything to coalesce with).
+	copy(q.items, q.items[1:])
+	q.items[len(q.items)-1] = item
+	q.signal()
+
+	return true, nil
+}
+
+// Pop blocks until there is an item in the queue, ctx is done or the queue is closed (and empty).
+func (q *Queue) Pop(ctx context.Context) (Item, error) {
+	for {
+		q.mu.Lock()
+		if len(q.items) > 0 {
+			item := q.items[0]
+			q.items[0] = Item{}
+			q.items = q.items[1:]
+			q.mu.Unlock()
+			return item, nil
+		}
+		closed := q.closed
+		q.mu.Unlock()
+
+		if closed {
+			return Item{}, ErrClosed
+		}
+
+		select {
+		case <-q.notify:
+		case <-ctx.Done():
+			return Item{}, ctx.Err()
+		}
+	}
+}
+
+// Len returns the amount of items in the queue.
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.items)
+}
+
+// Close stops the queue from accepting new items, those already queued can still be popped.
+func (q *Queue) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.closed = true
+	q.signal()
+}
+
+func (q *Queue) signal() {
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
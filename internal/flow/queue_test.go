@@ -0,0 +1,99 @@
+package flow_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/LasTshaMAN/streaming/internal/flow"
+)
+
+func TestQueue(t *testing.T) {
+	ctx := context.Background()
+
+	// fill pushes items with keys a, b (in this order) into the queue of capacity 2.
+	fill := func(t *testing.T, policy flow.Policy) *flow.Queue {
+		q := flow.NewQueue(2, policy)
+
+		for _, key := range []string{"a", "b"} {
+			dropped, err := q.Push(flow.Item{Key: key, Value: key})
+			assert.Nil(t, err)
+			assert.False(t, dropped)
+		}
+
+		return q
+	}
+
+	// drain pops all the values left in the closed queue.
+	drain := func(t *testing.T, q *flow.Queue) []interface{} {
+		q.Close()
+
+		var values []interface{}
+		for {
+			item, err := q.Pop(ctx)
+			if errors.Is(err, flow.ErrClosed) {
+				return values
+			}
+			assert.Nil(t, err)
+
+			values = append(values, item.Value)
+		}
+	}
+
+	t.Run("drop oldest", func(t *testing.T) {
+		q := fill(t, flow.PolicyDropOldest)
+
+		dropped, err := q.Push(flow.Item{Key: "c", Value: "c"})
+
+		assert.Nil(t, err)
+		assert.True(t, dropped)
+		assert.Equal(t, []interface{}{"b", "c"}, drain(t, q))
+	})
+	t.Run("drop newest", func(t *testing.T) {
+		q := fill(t, flow.PolicyDropNewest)
+
+		dropped, err := q.Push(flow.Item{Key: "c", Value: "c"})
+
+		assert.Nil(t, err)
+		assert.True(t, dropped)
+		assert.Equal(t, []interface{}{"a", "b"}, drain(t, q))
+	})
+	t.Run("coalesce", func(t *testing.T) {
+		q := fill(t, flow.PolicyCoalesce)
+
+		dropped, err := q.Push(flow.Item{Key: "a", Value: "a2"})
+
+		assert.Nil(t, err)
+		assert.True(t, dropped)
+		assert.Equal(t, []interface{}{"a2", "b"}, drain(t, q))
+	})
+	t.Run("coalesce without a match", func(t *testing.T) {
+		q := fill(t, flow.PolicyCoalesce)
+
+		dropped, err := q.Push(flow.Item{Key: "c", Value: "c"})
+
+		assert.Nil(t, err)
+		assert.True(t, dropped)
+		assert.Equal(t, []interface{}{"b", "c"}, drain(t, q))
+	})
+	t.Run("disconnect", func(t *testing.T) {
+		q := fill(t, flow.PolicyDisconnect)
+
+		_, err := q.Push(flow.Item{Key: "c", Value: "c"})
+
+		assert.True(t, errors.Is(err, flow.ErrOverflow), err)
+		assert.Equal(t, []interface{}{"a", "b"}, drain(t, q))
+	})
+	t.Run("pop waits for ctx", func(t *testing.T) {
+		q := flow.NewQueue(2, flow.PolicyDropOldest)
+
+		ctx, cancel := context.WithCancel(ctx)
+		cancel()
+
+		_, err := q.Pop(ctx)
+
+		assert.Equal(t, context.Canceled, err)
+	})
+}
@@ -0,0 +1,132 @@
+// Package healthcheck keeps gRPC health status of the service in sync with the state of its dependencies.
+package healthcheck
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// Check reports whether a single dependency is healthy.
+type Check struct {
+	Name string
+	// Func returns an error when the dependency is unhealthy.
+	Func func(ctx context.Context) error
+}
+
+// Checker periodically runs checks and updates the status of services in the health server accordingly.
+//
+// To avoid flapping, Checker switches to NOT_SERVING only after failureThreshold consecutive failed rounds
+// (a round fails when any of the checks fails), and back to SERVING only after successThreshold consecutive
+// successful rounds. The very first round sets the status right away.
+type Checker struct {
+	logger log.Logger
+
+	server   *health.Server
+	services []string
+
+	checks []Check
+
+	interval time.Duration
+	timeout  time.Duration
+
+	failureThreshold int
+	successThreshold int
+
+	// State of the checker, only accessed from Run.
+	serving   bool
+	checked   bool
+	failures  int
+	successes int
+}
+
+func NewChecker(
+	logger log.Logger,
+	server *health.Server,
+	services []string,
+	checks []Check,
+	interval time.Duration,
+	timeout time.Duration,
+	failureThreshold int,
+	successThreshold int,
+) *Checker {
+	return &Checker{
+		logger:           logger,
+		server:           server,
+		services:         services,
+		checks:           checks,
+		interval:         interval,
+		timeout:          timeout,
+		failureThreshold: failureThreshold,
+		successThreshold: successThreshold,
+	}
+}
+
+// Run keeps checking until ctx is done.
+func (c *Checker) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		c.round(ctx)
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (c *Checker) round(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	healthy := true
+	for _, check := range c.checks {
+		err := check.Func(ctx)
+		if err != nil {
+			_ = level.Error(c.logger).Log("err", fmt.Errorf("health check: %s failed, err: %w", check.Name, err))
+
+			healthy = false
+		}
+	}
+
+	if healthy {
+		c.successes++
+		c.failures = 0
+	} else {
+		c.failures++
+		c.successes = 0
+	}
+
+	switch {
+	case !c.checked:
+		c.checked = true
+		c.setServing(healthy)
+	case c.serving && c.failures >= c.failureThreshold:
+		c.setServing(false)
+	case !c.serving && c.successes >= c.successThreshold:
+		c.setServing(true)
+	}
+}
+
+func (c *Checker) setServing(serving bool) {
+	c.serving = serving
+
+	status := healthpb.HealthCheckResponse_SERVING
+	if !serving {
+		status = healthpb.HealthCheckResponse_NOT_SERVING
+	}
+
+	_ = level.Info(c.logger).Log("msg", fmt.Sprintf("health status changes to: %s", status))
+
+	for _, service := range c.services {
+		c.server.SetServingStatus(service, status)
+	}
+}
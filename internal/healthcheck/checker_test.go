@@ -0,0 +1,127 @@
+package healthcheck_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/LasTshaMAN/streaming/internal/healthcheck"
+)
+
+func TestChecker(t *testing.T) {
+	const (
+		service  = "some service"
+		interval = 10 * time.Millisecond
+	)
+
+	var (
+		mu      sync.Mutex
+		healthy = true
+	)
+	setHealthy := func(h bool) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		healthy = h
+	}
+
+	server := health.NewServer()
+
+	checker := healthcheck.NewChecker(
+		log.NewNopLogger(),
+		server,
+		[]string{service},
+		[]healthcheck.Check{
+			{
+				Name: "some check",
+				Func: func(context.Context) error {
+					mu.Lock()
+					defer mu.Unlock()
+
+					if !healthy {
+						return errors.New("some error")
+					}
+					return nil
+				},
+			},
+		},
+		interval,
+		time.Second,
+		3,
+		2,
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go checker.Run(ctx)
+
+	status := func() healthpb.HealthCheckResponse_ServingStatus {
+		resp, err := server.Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+		assert.Nil(t, err)
+
+		return resp.GetStatus()
+	}
+
+	assert.Eventually(t, func() bool {
+		return status() == healthpb.HealthCheckResponse_SERVING
+	}, time.Second, interval)
+
+	setHealthy(false)
+
+	// A single failed round isn't enough to switch to NOT_SERVING.
+	time.Sleep(interval / 2)
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, status())
+
+	assert.Eventually(t, func() bool {
+		return status() == healthpb.HealthCheckResponse_NOT_SERVING
+	}, time.Second, interval)
+
+	setHealthy(true)
+
+	assert.Eventually(t, func() bool {
+		return status() == healthpb.HealthCheckResponse_SERVING
+	}, time.Second, interval)
+}
+
+func TestTrackingProvider(t *testing.T) {
+	ctx := context.Background()
+
+	provider := healthcheck.NewTrackingProvider(&failingProvider{failEvery: 2})
+
+	check := provider.Check("some provider", 0.6, 4)
+
+	for i := 0; i < 3; i++ {
+		_, _, _ = provider.Get(ctx, "some url")
+	}
+
+	// Not enough requests to judge by.
+	assert.Nil(t, check.Func(ctx))
+
+	_, _, _ = provider.Get(ctx, "some url")
+
+	// Half of the requests have failed.
+	assert.NotNil(t, check.Func(ctx))
+}
+
+type failingProvider struct {
+	failEvery int
+	calls     int
+}
+
+func (p *failingProvider) Get(context.Context, string) (string, time.Duration, error) {
+	p.calls++
+
+	if p.calls%p.failEvery == 0 {
+		return "", 0, errors.New("some error")
+	}
+
+	return "some data", time.Second, nil
+}
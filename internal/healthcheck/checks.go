@@ -0,0 +1,98 @@
+package healthcheck
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+
+	"github.com/LasTshaMAN/streaming"
+)
+
+// RedisCheck makes sure a connection can be borrowed from pool and Redis responds to PING.
+func RedisCheck(pool *redis.Pool) Check {
+	return Check{
+		Name: "redis",
+		Func: func(ctx context.Context) error {
+			conn, err := pool.GetContext(ctx)
+			if err != nil {
+				return fmt.Errorf("get Redis connection, err: %w", err)
+			}
+			defer conn.Close()
+
+			_, err = conn.Do("PING")
+			if err != nil {
+				return fmt.Errorf("ping Redis, err: %w", err)
+			}
+
+			return nil
+		},
+	}
+}
+
+// TrackingProvider keeps track of how often the data provider it wraps succeeds.
+//
+// TrackingProvider can be safely used concurrently from multiple go-routines.
+type TrackingProvider struct {
+	provider streaming.DataProvider
+
+	mu        sync.Mutex
+	successes int
+	failures  int
+}
+
+func NewTrackingProvider(provider streaming.DataProvider) *TrackingProvider {
+	return &TrackingProvider{
+		provider: provider,
+	}
+}
+
+func (p *TrackingProvider) Get(ctx context.Context, url string) (string, time.Duration, error) {
+	data, ttl, err := p.provider.Get(ctx, url)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err != nil && ctx.Err() == nil {
+		p.failures++
+	}
+	if err == nil {
+		p.successes++
+	}
+
+	return data, ttl, err
+}
+
+// Check fails when the share of successful requests (made since the previous check) is below minSuccessRatio.
+//
+// Until there are at least minRequests requests made, provider is considered to be healthy
+// (and requests are accumulated till the next check), that's because a few requests don't tell much.
+func (p *TrackingProvider) Check(name string, minSuccessRatio float64, minRequests int) Check {
+	return Check{
+		Name: name,
+		Func: func(context.Context) error {
+			p.mu.Lock()
+			successes, failures := p.successes, p.failures
+			total := successes + failures
+			if total < minRequests {
+				// Keep accumulating until there is enough requests to judge by.
+				p.mu.Unlock()
+				return nil
+			}
+			p.successes, p.failures = 0, 0
+			p.mu.Unlock()
+
+			ratio := float64(successes) / float64(total)
+			if ratio < minSuccessRatio {
+				return fmt.Errorf("success ratio: %.2f is below: %.2f, err: %w", ratio, minSuccessRatio, errUnhealthy)
+			}
+
+			return nil
+		},
+	}
+}
+
+var errUnhealthy = errors.New("unhealthy")
@@ -0,0 +1,381 @@
+package inmemory
+
+import (
+	"container/heap"
+	"container/list"
+	"fmt"
+	"hash/fnv"
+)
+
+// EvictionPolicy decides which entries Storage evicts once it runs out of its budget.
+type EvictionPolicy string
+
+const (
+	// EvictionLRU evicts the least recently used entry.
+	EvictionLRU EvictionPolicy = "lru"
+	// EvictionLFU evicts the least frequently used entry (the least recently used one out of those used equally often).
+	EvictionLFU EvictionPolicy = "lfu"
+	// EvictionTinyLFU keeps new entries in a small LRU window, an entry leaving the window is only admitted
+	// to the rest of storage if it's been used more often than the entry it would displace (W-TinyLFU).
+	// Frequencies are estimated with a sketch that forgets (halves) them over time, so that entries
+	// which used to be popular don't stick around forever.
+	EvictionTinyLFU EvictionPolicy = "tinylfu"
+)
+
+// ParseEvictionPolicy returns EvictionPolicy named s.
+func ParseEvictionPolicy(s string) (EvictionPolicy, error) {
+	switch p := EvictionPolicy(s); p {
+	case EvictionLRU, EvictionLFU, EvictionTinyLFU:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown eviction policy: %s", s)
+	}
+}
+
+// evictionPolicy tracks entries of Storage to pick the ones to evict, it isn't safe for concurrent use.
+type evictionPolicy interface {
+	// added starts tracking e, it returns the entries (possibly including e) to evict right away,
+	// the policy stops tracking them on its own.
+	added(e *entry) []*entry
+	// accessed tells the policy e has been read.
+	accessed(e *entry)
+	// removed stops tracking e.
+	removed(e *entry)
+	// victim returns the entry to evict next.
+	victim() *entry
+}
+
+func newEvictionPolicy(policy EvictionPolicy, maxEntries int, maxBytes int64) (evictionPolicy, error) {
+	switch policy {
+	case EvictionLRU:
+		return &lruPolicy{recent: newRegion(0, 0)}, nil
+	case EvictionLFU:
+		return &lfuPolicy{}, nil
+	case EvictionTinyLFU:
+		return newTinyLFUPolicy(maxEntries, maxBytes), nil
+	default:
+		return nil, fmt.Errorf("unknown eviction policy: %s", policy)
+	}
+}
+
+// region is a part of storage kept in LRU order (the most recently used entries go first),
+// it overflows once it holds more than maxEntries entries or maxBytes bytes (0 means no limit).
+type region struct {
+	entries    *list.List
+	bytes      int64
+	maxEntries int
+	maxBytes   int64
+}
+
+func newRegion(maxEntries int, maxBytes int64) *region {
+	return &region{
+		entries:    list.New(),
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+	}
+}
+
+func (r *region) pushFront(e *entry) {
+	e.region = r
+	e.elem = r.entries.PushFront(e)
+	r.bytes += e.size
+}
+
+func (r *region) remove(e *entry) {
+	r.entries.Remove(e.elem)
+	r.bytes -= e.size
+	e.region = nil
+	e.elem = nil
+}
+
+func (r *region) back() *entry {
+	elem := r.entries.Back()
+	if elem == nil {
+		return nil
+	}
+
+	return elem.Value.(*entry)
+}
+
+func (r *region) overflows() bool {
+	return (r.maxEntries > 0 && r.entries.Len() > r.maxEntries) || (r.maxBytes > 0 && r.bytes > r.maxBytes)
+}
+
+type lruPolicy struct {
+	recent *region
+}
+
+func (p *lruPolicy) added(e *entry) []*entry {
+	p.recent.pushFront(e)
+
+	return nil
+}
+
+func (p *lruPolicy) accessed(e *entry) {
+	p.recent.entries.MoveToFront(e.elem)
+}
+
+func (p *lruPolicy) removed(e *entry) {
+	p.recent.remove(e)
+}
+
+func (p *lruPolicy) victim() *entry {
+	return p.recent.back()
+}
+
+type lfuPolicy struct {
+	entries lfuHeap
+	// tick orders uses of entries in time.
+	tick uint64
+}
+
+func (p *lfuPolicy) added(e *entry) []*entry {
+	// Entry that is being overwritten keeps its hits.
+	p.touch(e)
+	heap.Push(&p.entries, e)
+
+	return nil
+}
+
+func (p *lfuPolicy) accessed(e *entry) {
+	p.touch(e)
+	heap.Fix(&p.entries, e.index)
+}
+
+func (p *lfuPolicy) removed(e *entry) {
+	heap.Remove(&p.entries, e.index)
+}
+
+func (p *lfuPolicy) victim() *entry {
+	if len(p.entries) == 0 {
+		return nil
+	}
+
+	return p.entries[0]
+}
+
+func (p *lfuPolicy) touch(e *entry) {
+	p.tick++
+
+	e.hits++
+	e.usedAt = p.tick
+}
+
+// lfuHeap keeps the least frequently used entry on top.
+type lfuHeap []*entry
+
+func (h lfuHeap) Len() int {
+	return len(h)
+}
+
+func (h lfuHeap) Less(i, j int) bool {
+	if h[i].hits != h[j].hits {
+		return h[i].hits < h[j].hits
+	}
+
+	return h[i].usedAt < h[j].usedAt
+}
+
+func (h lfuHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *lfuHeap) Push(x interface{}) {
+	e := x.(*entry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *lfuHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+
+	return e
+}
+
+const (
+	// tinyLFUWindowShare is the share of storage budget taken by the window of new entries.
+	tinyLFUWindowShare = 0.01
+	// tinyLFUCountersPerEntry is the width of frequency sketch per entry storage can hold,
+	// the sketch has to be wide enough for estimates not to be skewed by collisions of keys.
+	tinyLFUCountersPerEntry = 8
+	// tinyLFUSketchWidth is the width of frequency sketch when the amount of entries isn't bounded.
+	tinyLFUSketchWidth = 1 << 16
+)
+
+type tinyLFUPolicy struct {
+	sketch *sketch
+	// window holds new entries, main holds the ones that have been admitted out of window.
+	window *region
+	main   *region
+}
+
+func newTinyLFUPolicy(maxEntries int, maxBytes int64) *tinyLFUPolicy {
+	windowEntries := int(float64(maxEntries) * tinyLFUWindowShare)
+	if maxEntries > 0 && windowEntries < 1 {
+		windowEntries = 1
+	}
+	windowBytes := int64(float64(maxBytes) * tinyLFUWindowShare)
+	if maxBytes > 0 && windowBytes < 1 {
+		windowBytes = 1
+	}
+
+	// Limits of main are only approximate for tiny budgets (0 would mean no limit at all).
+	mainEntries := maxEntries - windowEntries
+	if maxEntries > 0 && mainEntries < 1 {
+		mainEntries = 1
+	}
+	mainBytes := maxBytes - windowBytes
+	if maxBytes > 0 && mainBytes < 1 {
+		mainBytes = 1
+	}
+
+	width := tinyLFUCountersPerEntry * maxEntries
+	if width <= 0 {
+		width = tinyLFUSketchWidth
+	}
+
+	return &tinyLFUPolicy{
+		sketch: newSketch(width),
+		window: newRegion(windowEntries, windowBytes),
+		main:   newRegion(mainEntries, mainBytes),
+	}
+}
+
+func (p *tinyLFUPolicy) added(e *entry) []*entry {
+	p.sketch.add(e.url)
+	p.window.pushFront(e)
+
+	var evicted []*entry
+	// The newest entry always stays in window, even if it alone overflows it.
+	for p.window.overflows() && p.window.entries.Len() > 1 {
+		candidate := p.window.back()
+		p.window.remove(candidate)
+
+		victim := p.main.back()
+		p.main.pushFront(candidate)
+		if victim == nil || !p.main.overflows() {
+			continue
+		}
+
+		if p.sketch.estimate(candidate.url) <= p.sketch.estimate(victim.url) {
+			// Candidate isn't worth displacing anything.
+			p.main.remove(candidate)
+			evicted = append(evicted, candidate)
+			continue
+		}
+
+		for p.main.overflows() && p.main.back() != candidate {
+			victim := p.main.back()
+			p.main.remove(victim)
+			evicted = append(evicted, victim)
+		}
+	}
+
+	return evicted
+}
+
+func (p *tinyLFUPolicy) accessed(e *entry) {
+	p.sketch.add(e.url)
+	e.region.entries.MoveToFront(e.elem)
+}
+
+func (p *tinyLFUPolicy) removed(e *entry) {
+	// Entries evicted by added aren't tracked anymore.
+	if e.region != nil {
+		e.region.remove(e)
+	}
+}
+
+func (p *tinyLFUPolicy) victim() *entry {
+	if victim := p.main.back(); victim != nil {
+		return victim
+	}
+
+	return p.window.back()
+}
+
+// sketch estimates how often keys occur (Count-Min sketch with 4-bit counters), the estimates are halved
+// every time the amount of samples reaches 10 times the width of the sketch, so that they reflect recent history.
+type sketch struct {
+	rows    [sketchDepth][]uint8
+	mask    uint64
+	samples int
+	resetAt int
+}
+
+const (
+	sketchDepth      = 4
+	sketchMaxCounter = 15
+)
+
+func newSketch(width int) *sketch {
+	size := 16
+	for size < width {
+		size *= 2
+	}
+
+	s := &sketch{
+		mask:    uint64(size - 1),
+		resetAt: 10 * size,
+	}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, size)
+	}
+
+	return s
+}
+
+func (s *sketch) add(key string) {
+	for i, idx := range s.indexes(key) {
+		if s.rows[i][idx] < sketchMaxCounter {
+			s.rows[i][idx]++
+		}
+	}
+
+	s.samples++
+	if s.samples >= s.resetAt {
+		s.age()
+	}
+}
+
+func (s *sketch) estimate(key string) uint8 {
+	result := uint8(sketchMaxCounter)
+	for i, idx := range s.indexes(key) {
+		if s.rows[i][idx] < result {
+			result = s.rows[i][idx]
+		}
+	}
+
+	return result
+}
+
+func (s *sketch) age() {
+	for i := range s.rows {
+		for j := range s.rows[i] {
+			s.rows[i][j] /= 2
+		}
+	}
+
+	s.samples /= 2
+}
+
+func (s *sketch) indexes(key string) [sketchDepth]uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	sum := h.Sum64()
+
+	// Derive the rest of hashes out of a single one (double hashing).
+	h1, h2 := sum&0xffffffff, sum>>32|1
+
+	var result [sketchDepth]uint64
+	for i := range result {
+		result[i] = (h1 + uint64(i)*h2) & s.mask
+	}
+
+	return result
+}
@@ -1,20 +1,25 @@
 package inmemory
 
 import (
+	"context"
+	"fmt"
 	"hash/fnv"
-	"sync"
+	"sync/atomic"
+
+	"github.com/LasTshaMAN/streaming"
 )
 
 type Locker struct {
-	locks []*sync.Mutex
+	// locks are semaphores of size 1, a lock is held while there is a value in its channel.
+	locks []chan struct{}
 	size  int
 }
 
 func NewLocker(size int) *Locker {
-	locks := make([]*sync.Mutex, size)
+	locks := make([]chan struct{}, size)
 
 	for i := 0; i < size; i++ {
-		locks[i] = &sync.Mutex{}
+		locks[i] = make(chan struct{}, 1)
 	}
 
 	return &Locker{
@@ -26,20 +31,55 @@ func NewLocker(size int) *Locker {
 func (locker *Locker) Lock(url string) error {
 	lock := locker.getLock(url)
 
-	lock.Lock()
+	lock <- struct{}{}
 
 	return nil
 }
 
+func (locker *Locker) LockContext(ctx context.Context, url string) (streaming.Lease, error) {
+	lock := locker.getLock(url)
+
+	select {
+	case lock <- struct{}{}:
+		return newLease(func() { <-lock }), nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("url: %s, ctx err: %v, err: %w", url, ctx.Err(), streaming.ErrLockTimeout)
+	}
+}
+
+func (locker *Locker) TryLock(url string) (streaming.Lease, bool, error) {
+	lock := locker.getLock(url)
+
+	select {
+	case lock <- struct{}{}:
+		return newLease(func() { <-lock }), true, nil
+	default:
+		return nil, false, nil
+	}
+}
+
 func (locker *Locker) Unlock(url string) (bool, error) {
 	lock := locker.getLock(url)
 
-	lock.Unlock()
+	select {
+	case <-lock:
+		return true, nil
+	default:
+		// The lock isn't held.
+		return false, nil
+	}
+}
+
+// Key returns the name of the lock (stripe) url maps to.
+func (locker *Locker) Key(url string) string {
+	return fmt.Sprintf("stripe %d", locker.getIndex(url))
+}
 
-	return true, nil
+func (locker *Locker) getLock(url string) chan struct{} {
+	return locker.locks[locker.getIndex(url)]
 }
 
-func (locker *Locker) getLock(url string) *sync.Mutex {
+func (locker *Locker) getIndex(url string) uint64 {
 	h := fnv.New64()
 
 	_, _ = h.Write([]byte(url))
@@ -47,5 +87,42 @@ func (locker *Locker) getLock(url string) *sync.Mutex {
 
 	hash := h.Sum64()
 
-	return locker.locks[hash%uint64(locker.size)]
+	return hash % uint64(locker.size)
+}
+
+// lease is held until it's unlocked, in-memory locks don't expire and thus can't be lost.
+type lease struct {
+	unlock func()
+
+	// released is set to 1 once the lock is released.
+	released int32
+}
+
+func newLease(unlock func()) *lease {
+	return &lease{unlock: unlock}
+}
+
+func (l *lease) Held() bool {
+	return atomic.LoadInt32(&l.released) == 0
+}
+
+func (l *lease) Lost() <-chan struct{} {
+	// Nil channel blocks forever.
+	return nil
+}
+
+func (l *lease) Token() int64 {
+	// In-memory locks can't be lost, there is no need for fencing.
+	return 0
+}
+
+func (l *lease) Unlock() (bool, error) {
+	if !atomic.CompareAndSwapInt32(&l.released, 0, 1) {
+		// The lock has been released already.
+		return false, nil
+	}
+
+	l.unlock()
+
+	return true, nil
 }
@@ -0,0 +1,47 @@
+package inmemory_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/LasTshaMAN/streaming"
+	"github.com/LasTshaMAN/streaming/internal/inmemory"
+)
+
+// BenchmarkLocker shows how much unrelated URLs wait on each other in every lock mode.
+func BenchmarkLocker(b *testing.B) {
+	const (
+		urlCount = 1000
+		// holdTime stands for a fetch from fallback provider (done while holding the lock).
+		holdTime = 50 * time.Microsecond
+	)
+
+	urls := benchURLs(urlCount)
+
+	benchmarks := []struct {
+		name   string
+		locker streaming.Locker
+	}{
+		{name: "stripe 1", locker: inmemory.NewLocker(1)},
+		{name: "stripe 100", locker: inmemory.NewLocker(100)},
+		{name: "url", locker: inmemory.NewURLLocker()},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			walkURLs(b, urls, func(url string) {
+				lease, err := bm.locker.LockContext(context.Background(), url)
+				if err != nil {
+					b.Fatal(err)
+				}
+
+				time.Sleep(holdTime)
+
+				_, err = lease.Unlock()
+				if err != nil {
+					b.Fatal(err)
+				}
+			})
+		})
+	}
+}
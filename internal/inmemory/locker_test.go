@@ -0,0 +1,107 @@
+package inmemory_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/LasTshaMAN/streaming"
+	"github.com/LasTshaMAN/streaming/internal/inmemory"
+)
+
+func TestLocker(t *testing.T) {
+	const url = "some url"
+
+	t.Run("try lock", func(t *testing.T) {
+		locker := inmemory.NewLocker(1)
+
+		lease, ok, err := locker.TryLock(url)
+		assert.Nil(t, err)
+		assert.True(t, ok)
+		assert.True(t, lease.Held())
+
+		_, ok, err = locker.TryLock(url)
+		assert.Nil(t, err)
+		assert.False(t, ok)
+
+		success, err := lease.Unlock()
+		assert.Nil(t, err)
+		assert.True(t, success)
+		assert.False(t, lease.Held())
+
+		success, err = lease.Unlock()
+		assert.Nil(t, err)
+		assert.False(t, success)
+
+		_, ok, err = locker.TryLock(url)
+		assert.Nil(t, err)
+		assert.True(t, ok)
+	})
+
+	t.Run("lock context times out", func(t *testing.T) {
+		locker := inmemory.NewLocker(1)
+
+		err := locker.Lock(url)
+		assert.Nil(t, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		_, err = locker.LockContext(ctx, url)
+		assert.True(t, errors.Is(err, streaming.ErrLockTimeout), err)
+	})
+
+	t.Run("lock context is cancelled", func(t *testing.T) {
+		locker := inmemory.NewLocker(1)
+
+		err := locker.Lock(url)
+		assert.Nil(t, err)
+
+		ctx, cancel := context.WithCancel(context.Background())
+
+		done := make(chan error)
+		go func() {
+			_, err := locker.LockContext(ctx, url)
+			done <- err
+		}()
+
+		cancel()
+
+		select {
+		case err := <-done:
+			assert.True(t, errors.Is(err, streaming.ErrLockTimeout), err)
+		case <-time.After(time.Second):
+			t.Fatal("waiting for the lock hasn't stopped")
+		}
+	})
+
+	t.Run("lock context acquires released lock", func(t *testing.T) {
+		locker := inmemory.NewLocker(1)
+
+		err := locker.Lock(url)
+		assert.Nil(t, err)
+
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			_, _ = locker.Unlock(url)
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		lease, err := locker.LockContext(ctx, url)
+		assert.Nil(t, err)
+		assert.True(t, lease.Held())
+	})
+
+	t.Run("unlock of free lock", func(t *testing.T) {
+		locker := inmemory.NewLocker(1)
+
+		success, err := locker.Unlock(url)
+		assert.Nil(t, err)
+		assert.False(t, success)
+	})
+}
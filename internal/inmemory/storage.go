@@ -1,57 +1,260 @@
 package inmemory
 
 import (
+	"container/list"
 	"context"
+	"fmt"
 	"sync"
 	"time"
 
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/metrics/discard"
+
 	"github.com/LasTshaMAN/streaming"
 )
 
+// StorageMetrics describes usage of Storage.
+type StorageMetrics struct {
+	// Evictions counts entries removed from storage before being overwritten, it has "reason" label,
+	// one of: expired (ttl has elapsed), capacity (storage has run out of its budget), too_large (entry is replaced by one that alone exceeds the budget of its shard).
+	Evictions metrics.Counter
+	// Entries is the amount of entries in storage.
+	Entries metrics.Gauge
+	// Bytes is the size of entries in storage, see Storage.
+	Bytes metrics.Gauge
+}
+
+// Storage keeps data in memory, within the budget of maxEntries entries and maxBytes bytes (0 means no limit).
+// The size of an entry is the length of its URL plus the length of its data.
+//
+// Once storage runs out of its budget it evicts entries according to its EvictionPolicy (expired entries are evicted
+// as soon as they are found), so data might disappear from Storage before its ttl elapses.
+//
+// Storage is split into shards (by URL), so that concurrent calls for different URLs mostly don't wait on each other,
+// every shard gets its share of the budget and evicts entries on its own.
 type Storage struct {
-	storage sync.Map
+	shards  []*storageShard
+	metrics StorageMetrics
 
 	now func() time.Time
 }
 
+const (
+	// storageShards is the amount of shards Storage is split into.
+	storageShards = 16
+	// minShardEntries and minShardBytes are the least budget of a shard, storage with a smaller budget has fewer shards
+	// (down to a single one), otherwise eviction policy wouldn't have enough entries to choose from.
+	minShardEntries = 64
+	minShardBytes   = 1 << 20
+)
+
+type storageShard struct {
+	maxEntries int
+	maxBytes   int64
+
+	mu      sync.Mutex
+	entries map[string]*entry
+	bytes   int64
+	policy  evictionPolicy
+}
+
+// NewStorage returns Storage that never runs out of its budget.
 func NewStorage(now func() time.Time) *Storage {
+	s, _ := NewBoundedStorage(0, 0, EvictionLRU, StorageMetrics{
+		Evictions: discard.NewCounter(),
+		Entries:   discard.NewGauge(),
+		Bytes:     discard.NewGauge(),
+	}, now)
+
+	return s
+}
+
+func NewBoundedStorage(
+	maxEntries int,
+	maxBytes int64,
+	policy EvictionPolicy,
+	metrics StorageMetrics,
+	now func() time.Time,
+) (*Storage, error) {
+	if maxEntries < 0 || maxBytes < 0 {
+		return nil, fmt.Errorf("storage budget must not be negative, got: %d entries, %d bytes", maxEntries, maxBytes)
+	}
+
+	n := shardCount(maxEntries, maxBytes)
+
+	shards := make([]*storageShard, n)
+	for i := range shards {
+		// The remainder of the budget goes to the first shards.
+		shardEntries := maxEntries / n
+		if i < maxEntries%n {
+			shardEntries++
+		}
+		shardBytes := maxBytes / int64(n)
+		if int64(i) < maxBytes%int64(n) {
+			shardBytes++
+		}
+
+		p, err := newEvictionPolicy(policy, shardEntries, shardBytes)
+		if err != nil {
+			return nil, err
+		}
+
+		shards[i] = &storageShard{
+			maxEntries: shardEntries,
+			maxBytes:   shardBytes,
+			entries:    make(map[string]*entry),
+			policy:     p,
+		}
+	}
+
 	return &Storage{
-		now: now,
+		shards:  shards,
+		metrics: metrics,
+		now:     now,
+	}, nil
+}
+
+func shardCount(maxEntries int, maxBytes int64) int {
+	n := storageShards
+	if maxEntries > 0 && maxEntries/minShardEntries < n {
+		n = maxEntries / minShardEntries
+	}
+	if maxBytes > 0 && maxBytes/minShardBytes < int64(n) {
+		n = int(maxBytes / minShardBytes)
+	}
+	if n < 1 {
+		n = 1
 	}
+
+	return n
+}
+
+func (s *Storage) shard(url string) *storageShard {
+	if len(s.shards) == 1 {
+		return s.shards[0]
+	}
+
+	// FNV-1a, computed in place since hash.Hash would allocate on every call.
+	h := uint32(2166136261)
+	for i := 0; i < len(url); i++ {
+		h ^= uint32(url[i])
+		h *= 16777619
+	}
+
+	return s.shards[h%uint32(len(s.shards))]
 }
 
 func (s *Storage) Get(_ context.Context, url string) (string, time.Duration, error) {
-	eObj, ok := s.storage.Load(url)
+	sh := s.shard(url)
+
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	e, ok := sh.entries[url]
 	if !ok {
 		return "", 0, streaming.ErrDataNotFoundInStorage
 	}
 
-	e := eObj.(entry)
-
-	expiresAt := e.createdAt.Add(e.ttl)
-	ttl := expiresAt.Sub(s.now())
+	ttl := e.expiresAt().Sub(s.now())
 
 	if ttl < 0 {
+		s.evict(sh, e, "expired")
+
 		return "", 0, streaming.ErrDataNotFoundInStorage
 	}
 
+	sh.policy.accessed(e)
+
 	return e.data, ttl, nil
 }
 
 func (s *Storage) Set(_ context.Context, url string, data string, ttl time.Duration) error {
-	e := entry{
-		data:      data,
-		createdAt: s.now(),
-		ttl:       ttl,
+	now := s.now()
+	size := int64(len(url) + len(data))
+
+	sh := s.shard(url)
+
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	e, ok := sh.entries[url]
+	if ok {
+		s.remove(sh, e)
+	} else {
+		e = &entry{url: url}
 	}
 
-	s.storage.Store(url, e)
+	if sh.maxBytes > 0 && size > sh.maxBytes {
+		// Whatever has been stored under url is outdated anyway.
+		if ok {
+			s.metrics.Evictions.With("reason", "too_large").Add(1)
+		}
+
+		return nil
+	}
+
+	e.data = data
+	e.createdAt = now
+	e.ttl = ttl
+	e.size = size
+
+	sh.entries[url] = e
+	sh.bytes += e.size
+	s.metrics.Entries.Add(1)
+	s.metrics.Bytes.Add(float64(e.size))
+
+	for _, victim := range sh.policy.added(e) {
+		s.evict(sh, victim, evictionReason(victim, now))
+	}
+	for sh.overflows() {
+		victim := sh.policy.victim()
+		s.evict(sh, victim, evictionReason(victim, now))
+	}
 
 	return nil
 }
 
+func (s *Storage) evict(sh *storageShard, e *entry, reason string) {
+	s.remove(sh, e)
+	s.metrics.Evictions.With("reason", reason).Add(1)
+}
+
+func (s *Storage) remove(sh *storageShard, e *entry) {
+	delete(sh.entries, e.url)
+	sh.bytes -= e.size
+	sh.policy.removed(e)
+
+	s.metrics.Entries.Add(-1)
+	s.metrics.Bytes.Add(-float64(e.size))
+}
+
+func (sh *storageShard) overflows() bool {
+	return (sh.maxEntries > 0 && len(sh.entries) > sh.maxEntries) || (sh.maxBytes > 0 && sh.bytes > sh.maxBytes)
+}
+
+func evictionReason(e *entry, now time.Time) string {
+	if e.expiresAt().Before(now) {
+		return "expired"
+	}
+
+	return "capacity"
+}
+
 type entry struct {
+	url       string
 	data      string
 	createdAt time.Time
 	ttl       time.Duration
+	size      int64
+
+	// The fields below belong to evictionPolicy.
+	region *region
+	elem   *list.Element
+	index  int
+	hits   int
+	usedAt uint64
+}
+
+func (e *entry) expiresAt() time.Time {
+	return e.createdAt.Add(e.ttl)
 }
@@ -0,0 +1,50 @@
+package inmemory_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/metrics/discard"
+
+	"github.com/LasTshaMAN/streaming/internal/inmemory"
+)
+
+// BenchmarkStorage_Get shows how much concurrent reads of different URLs contend with each other
+// (every read updates the state of eviction policy).
+func BenchmarkStorage_Get(b *testing.B) {
+	const (
+		urlCount   = 1000
+		maxEntries = 10000
+	)
+
+	urls := benchURLs(urlCount)
+
+	policies := []inmemory.EvictionPolicy{inmemory.EvictionLRU, inmemory.EvictionLFU, inmemory.EvictionTinyLFU}
+	for _, policy := range policies {
+		b.Run(string(policy), func(b *testing.B) {
+			storage, err := inmemory.NewBoundedStorage(maxEntries, 0, policy, inmemory.StorageMetrics{
+				Evictions: discard.NewCounter(),
+				Entries:   discard.NewGauge(),
+				Bytes:     discard.NewGauge(),
+			}, time.Now)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			for _, url := range urls {
+				err := storage.Set(context.Background(), url, "some data", time.Hour)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+
+			walkURLs(b, urls, func(url string) {
+				_, _, err := storage.Get(context.Background(), url)
+				if err != nil {
+					b.Fatal(err)
+				}
+			})
+		})
+	}
+}
@@ -3,10 +3,16 @@ package inmemory_test
 import (
 	"context"
 	"errors"
+	"fmt"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/metrics/discard"
+	"github.com/go-kit/kit/metrics/generic"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"github.com/LasTshaMAN/streaming"
 	"github.com/LasTshaMAN/streaming/internal/inmemory"
@@ -94,3 +100,220 @@ func TestStorage(t *testing.T) {
 		assert.Equal(t, actTTL, time.Duration(0))
 	})
 }
+
+func TestStorage_Bounded(t *testing.T) {
+	const ttl = time.Minute
+
+	ctx := context.Background()
+
+	setup := func(t *testing.T, maxEntries int, maxBytes int64, policy inmemory.EvictionPolicy) (*inmemory.Storage, *fakeCounter) {
+		evictions := &fakeCounter{}
+
+		storage, err := inmemory.NewBoundedStorage(maxEntries, maxBytes, policy, inmemory.StorageMetrics{
+			Evictions: evictions,
+			Entries:   discard.NewGauge(),
+			Bytes:     discard.NewGauge(),
+		}, time.Now)
+		require.Nil(t, err)
+
+		return storage, evictions
+	}
+
+	stored := func(storage *inmemory.Storage, urls ...string) []string {
+		var result []string
+		for _, url := range urls {
+			if _, _, err := storage.Get(ctx, url); err == nil {
+				result = append(result, url)
+			}
+		}
+
+		return result
+	}
+
+	t.Run("lru evicts the least recently used entry", func(t *testing.T) {
+		storage, evictions := setup(t, 3, 0, inmemory.EvictionLRU)
+
+		for _, url := range []string{"a", "b", "c"} {
+			require.Nil(t, storage.Set(ctx, url, "data", ttl))
+		}
+		_, _, err := storage.Get(ctx, "a")
+		require.Nil(t, err)
+
+		require.Nil(t, storage.Set(ctx, "d", "data", ttl))
+
+		assert.Equal(t, []string{"a", "c", "d"}, stored(storage, "a", "b", "c", "d"))
+		assert.Equal(t, map[string]float64{"capacity": 1}, evictions.values())
+	})
+
+	t.Run("lfu evicts the least frequently used entry", func(t *testing.T) {
+		storage, evictions := setup(t, 3, 0, inmemory.EvictionLFU)
+
+		for _, url := range []string{"a", "b", "c"} {
+			require.Nil(t, storage.Set(ctx, url, "data", ttl))
+		}
+		// b is the only one that hasn't been read, even though a has been read before b was stored.
+		for _, url := range []string{"a", "a", "c"} {
+			_, _, err := storage.Get(ctx, url)
+			require.Nil(t, err)
+		}
+
+		require.Nil(t, storage.Set(ctx, "d", "data", ttl))
+
+		assert.Equal(t, []string{"a", "c", "d"}, stored(storage, "a", "b", "c", "d"))
+		assert.Equal(t, map[string]float64{"capacity": 1}, evictions.values())
+	})
+
+	t.Run("tinylfu doesn't let one-off entries displace popular ones", func(t *testing.T) {
+		const size = 100
+
+		storage, _ := setup(t, size, 0, inmemory.EvictionTinyLFU)
+
+		popular := make([]string, 0, size/2)
+		for i := 0; i < size/2; i++ {
+			url := fmt.Sprintf("popular %d", i)
+			popular = append(popular, url)
+
+			require.Nil(t, storage.Set(ctx, url, "data", ttl))
+		}
+		for i := 0; i < 3; i++ {
+			stored(storage, popular...)
+		}
+
+		// A scan through many URLs nobody is going to read again.
+		for i := 0; i < 10*size; i++ {
+			require.Nil(t, storage.Set(ctx, fmt.Sprintf("one-off %d", i), "data", ttl))
+		}
+
+		assert.Equal(t, popular, stored(storage, popular...))
+	})
+
+	t.Run("byte budget", func(t *testing.T) {
+		for _, policy := range []inmemory.EvictionPolicy{inmemory.EvictionLRU, inmemory.EvictionLFU, inmemory.EvictionTinyLFU} {
+			t.Run(string(policy), func(t *testing.T) {
+				// Every entry takes 10 bytes (1 byte of URL and 9 bytes of data).
+				storage, evictions := setup(t, 0, 35, policy)
+
+				for _, url := range []string{"a", "b", "c", "d", "e"} {
+					require.Nil(t, storage.Set(ctx, url, "some data", ttl))
+				}
+
+				assert.Len(t, stored(storage, "a", "b", "c", "d", "e"), 3)
+				assert.Equal(t, map[string]float64{"capacity": 2}, evictions.values())
+			})
+		}
+	})
+
+	t.Run("entry exceeding the budget isn't stored", func(t *testing.T) {
+		storage, evictions := setup(t, 0, 20, inmemory.EvictionLRU)
+
+		require.Nil(t, storage.Set(ctx, "a", "data", ttl))
+		require.Nil(t, storage.Set(ctx, "a", "data that doesn't fit", ttl))
+
+		_, _, err := storage.Get(ctx, "a")
+		assert.True(t, errors.Is(err, streaming.ErrDataNotFoundInStorage), err)
+		assert.Equal(t, map[string]float64{"too_large": 1}, evictions.values())
+
+		// Nothing is evicted when there is nothing stored under the URL.
+		require.Nil(t, storage.Set(ctx, "b", "data that doesn't fit", ttl))
+		assert.Equal(t, map[string]float64{"too_large": 1}, evictions.values())
+	})
+
+	t.Run("overwriting entry doesn't evict anything", func(t *testing.T) {
+		storage, evictions := setup(t, 2, 0, inmemory.EvictionLRU)
+
+		require.Nil(t, storage.Set(ctx, "a", "data", ttl))
+		require.Nil(t, storage.Set(ctx, "b", "data", ttl))
+		require.Nil(t, storage.Set(ctx, "b", "new data", ttl))
+
+		data, _, err := storage.Get(ctx, "b")
+		assert.Nil(t, err)
+		assert.Equal(t, "new data", data)
+		assert.Equal(t, []string{"a", "b"}, stored(storage, "a", "b"))
+		assert.Empty(t, evictions.values())
+	})
+
+	t.Run("expired entries are evicted", func(t *testing.T) {
+		storage, evictions := setup(t, 2, 0, inmemory.EvictionLRU)
+
+		require.Nil(t, storage.Set(ctx, "a", "data", -time.Second))
+		require.Nil(t, storage.Set(ctx, "b", "data", -time.Second))
+		_, _, err := storage.Get(ctx, "a")
+		assert.True(t, errors.Is(err, streaming.ErrDataNotFoundInStorage), err)
+
+		require.Nil(t, storage.Set(ctx, "c", "data", ttl))
+		require.Nil(t, storage.Set(ctx, "d", "data", ttl))
+
+		assert.Equal(t, []string{"c", "d"}, stored(storage, "a", "b", "c", "d"))
+		assert.Equal(t, map[string]float64{"expired": 2}, evictions.values())
+	})
+
+	t.Run("sharded storage stays within its budget", func(t *testing.T) {
+		const (
+			maxEntries = 1024
+			urlCount   = 4 * maxEntries
+		)
+
+		evictions := &fakeCounter{}
+		entries := generic.NewGauge("entries")
+		bytes := generic.NewGauge("bytes")
+
+		storage, err := inmemory.NewBoundedStorage(maxEntries, 0, inmemory.EvictionLRU, inmemory.StorageMetrics{
+			Evictions: evictions,
+			Entries:   entries,
+			Bytes:     bytes,
+		}, time.Now)
+		require.Nil(t, err)
+
+		urls := make([]string, urlCount)
+		for i := range urls {
+			urls[i] = fmt.Sprintf("https://example.com/%04d", i)
+			require.Nil(t, storage.Set(ctx, urls[i], "data", ttl))
+		}
+
+		held := stored(storage, urls...)
+		assert.Equal(t, maxEntries, len(held))
+		assert.Equal(t, map[string]float64{"capacity": urlCount - maxEntries}, evictions.values())
+		assert.Equal(t, float64(maxEntries), entries.Value())
+		assert.Equal(t, float64(maxEntries*len("https://example.com/0000data")), bytes.Value())
+	})
+
+	t.Run("unknown policy", func(t *testing.T) {
+		_, err := inmemory.NewBoundedStorage(1, 0, "fifo", inmemory.StorageMetrics{}, time.Now)
+		assert.NotNil(t, err)
+	})
+}
+
+// fakeCounter counts by the value of the last label.
+type fakeCounter struct {
+	mu     sync.Mutex
+	counts map[string]float64
+
+	label  string
+	parent *fakeCounter
+}
+
+func (c *fakeCounter) With(labelValues ...string) metrics.Counter {
+	return &fakeCounter{label: labelValues[len(labelValues)-1], parent: c}
+}
+
+func (c *fakeCounter) Add(delta float64) {
+	c.parent.mu.Lock()
+	defer c.parent.mu.Unlock()
+
+	if c.parent.counts == nil {
+		c.parent.counts = make(map[string]float64)
+	}
+	c.parent.counts[c.label] += delta
+}
+
+func (c *fakeCounter) values() map[string]float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	result := make(map[string]float64, len(c.counts))
+	for k, v := range c.counts {
+		result[k] = v
+	}
+
+	return result
+}
@@ -0,0 +1,125 @@
+package inmemory
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/LasTshaMAN/streaming"
+)
+
+// URLLocker creates a lock per URL on demand (unlike Locker, it never makes unrelated URLs wait on each other),
+// a lock is removed once nobody holds it or waits for it.
+type URLLocker struct {
+	mu    sync.Mutex
+	locks map[string]*urlLock
+}
+
+// urlLock is a semaphore of size 1 (same as Locker uses), refs is the amount of its holders and waiters.
+type urlLock struct {
+	sem  chan struct{}
+	refs int
+}
+
+func NewURLLocker() *URLLocker {
+	return &URLLocker{
+		locks: make(map[string]*urlLock),
+	}
+}
+
+func (locker *URLLocker) Lock(url string) error {
+	lock := locker.ref(url)
+
+	lock.sem <- struct{}{}
+
+	return nil
+}
+
+func (locker *URLLocker) LockContext(ctx context.Context, url string) (streaming.Lease, error) {
+	lock := locker.ref(url)
+
+	select {
+	case lock.sem <- struct{}{}:
+		return locker.newLease(url, lock), nil
+	case <-ctx.Done():
+		locker.unref(url, lock)
+		return nil, fmt.Errorf("url: %s, ctx err: %v, err: %w", url, ctx.Err(), streaming.ErrLockTimeout)
+	}
+}
+
+func (locker *URLLocker) TryLock(url string) (streaming.Lease, bool, error) {
+	lock := locker.ref(url)
+
+	select {
+	case lock.sem <- struct{}{}:
+		return locker.newLease(url, lock), true, nil
+	default:
+		locker.unref(url, lock)
+		return nil, false, nil
+	}
+}
+
+func (locker *URLLocker) Unlock(url string) (bool, error) {
+	locker.mu.Lock()
+	lock, ok := locker.locks[url]
+	locker.mu.Unlock()
+
+	if !ok {
+		// Nobody holds the lock.
+		return false, nil
+	}
+
+	select {
+	case <-lock.sem:
+		locker.unref(url, lock)
+		return true, nil
+	default:
+		// The lock isn't held (there are only the ones waiting for it).
+		return false, nil
+	}
+}
+
+// Key returns the name of the lock url maps to, which is url itself.
+func (locker *URLLocker) Key(url string) string {
+	return url
+}
+
+// Size returns the amount of locks currently in use (held or waited for).
+func (locker *URLLocker) Size() int {
+	locker.mu.Lock()
+	defer locker.mu.Unlock()
+
+	return len(locker.locks)
+}
+
+func (locker *URLLocker) newLease(url string, lock *urlLock) *lease {
+	return newLease(func() {
+		<-lock.sem
+		locker.unref(url, lock)
+	})
+}
+
+// ref returns the lock for url (creating it if necessary), the caller must unref it once done with it.
+func (locker *URLLocker) ref(url string) *urlLock {
+	locker.mu.Lock()
+	defer locker.mu.Unlock()
+
+	lock, ok := locker.locks[url]
+	if !ok {
+		lock = &urlLock{sem: make(chan struct{}, 1)}
+		locker.locks[url] = lock
+	}
+	lock.refs++
+
+	return lock
+}
+
+func (locker *URLLocker) unref(url string, lock *urlLock) {
+	locker.mu.Lock()
+	defer locker.mu.Unlock()
+
+	lock.refs--
+	if lock.refs == 0 {
+		delete(locker.locks, url)
+	}
+}
@@ -0,0 +1,115 @@
+package inmemory_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/LasTshaMAN/streaming"
+	"github.com/LasTshaMAN/streaming/internal/inmemory"
+)
+
+func TestURLLocker(t *testing.T) {
+	const (
+		url      = "some url"
+		otherURL = "other url"
+	)
+
+	t.Run("try lock", func(t *testing.T) {
+		locker := inmemory.NewURLLocker()
+
+		lease, ok, err := locker.TryLock(url)
+		assert.Nil(t, err)
+		assert.True(t, ok)
+
+		_, ok, err = locker.TryLock(url)
+		assert.Nil(t, err)
+		assert.False(t, ok)
+
+		// Unrelated URLs don't wait on each other.
+		otherLease, ok, err := locker.TryLock(otherURL)
+		assert.Nil(t, err)
+		assert.True(t, ok)
+
+		success, err := lease.Unlock()
+		assert.Nil(t, err)
+		assert.True(t, success)
+
+		success, err = otherLease.Unlock()
+		assert.Nil(t, err)
+		assert.True(t, success)
+
+		assert.Equal(t, 0, locker.Size())
+	})
+
+	t.Run("lock and unlock by url", func(t *testing.T) {
+		locker := inmemory.NewURLLocker()
+
+		err := locker.Lock(url)
+		assert.Nil(t, err)
+
+		success, err := locker.Unlock(otherURL)
+		assert.Nil(t, err)
+		assert.False(t, success)
+
+		success, err = locker.Unlock(url)
+		assert.Nil(t, err)
+		assert.True(t, success)
+
+		success, err = locker.Unlock(url)
+		assert.Nil(t, err)
+		assert.False(t, success)
+
+		assert.Equal(t, 0, locker.Size())
+	})
+
+	t.Run("lock context times out", func(t *testing.T) {
+		locker := inmemory.NewURLLocker()
+
+		lease, ok, err := locker.TryLock(url)
+		assert.Nil(t, err)
+		assert.True(t, ok)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		_, err = locker.LockContext(ctx, url)
+		assert.True(t, errors.Is(err, streaming.ErrLockTimeout), err)
+
+		_, err = lease.Unlock()
+		assert.Nil(t, err)
+
+		// The one who gave up waiting doesn't keep the lock around.
+		assert.Equal(t, 0, locker.Size())
+	})
+
+	t.Run("lock is kept while waited for", func(t *testing.T) {
+		locker := inmemory.NewURLLocker()
+
+		lease, ok, err := locker.TryLock(url)
+		assert.Nil(t, err)
+		assert.True(t, ok)
+
+		done := make(chan streaming.Lease)
+		go func() {
+			waiterLease, err := locker.LockContext(context.Background(), url)
+			assert.Nil(t, err)
+
+			done <- waiterLease
+		}()
+		time.Sleep(10 * time.Millisecond)
+
+		_, err = lease.Unlock()
+		assert.Nil(t, err)
+
+		waiterLease := <-done
+		assert.Equal(t, 1, locker.Size())
+
+		_, err = waiterLease.Unlock()
+		assert.Nil(t, err)
+		assert.Equal(t, 0, locker.Size())
+	})
+}
@@ -0,0 +1,34 @@
+package locking
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+// NewDebugHandler returns HTTP handler listing the locks currently held through lockers (as JSON),
+// the ones held for the longest go first.
+func NewDebugHandler(lockers ...*InstrumentingMiddleware) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		held := make([]HeldLock, 0)
+		for _, locker := range lockers {
+			held = append(held, locker.Held()...)
+		}
+
+		sort.SliceStable(held, func(i, j int) bool {
+			return held[i].Since.Before(held[j].Since)
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+
+		err := json.NewEncoder(w).Encode(struct {
+			Locks []HeldLock `json:"locks"`
+		}{
+			Locks: held,
+		})
+		if err != nil {
+			http.Error(w, fmt.Sprintf("encode held locks, err: %v", err), http.StatusInternalServerError)
+		}
+	})
+}
@@ -0,0 +1,245 @@
+// Package locking provides decorators for streaming.Locker.
+package locking
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/metrics"
+
+	"github.com/LasTshaMAN/streaming"
+)
+
+// Metrics describes lock usage, every metric has "locker" label (the name of the locker),
+// Holders also has "stripe" label (the name of the lock if locks are striped, empty otherwise - locks per URL are
+// listed by NewDebugHandler instead, since there is no bound on the amount of them).
+type Metrics struct {
+	// WaitTime observes how long (in seconds) it takes to acquire a lock (or to give up on it).
+	WaitTime metrics.Histogram
+	// HoldTime observes how long (in seconds) locks are held for.
+	HoldTime metrics.Histogram
+	// AcquireFailures counts locks that haven't been acquired, it also has "reason" label,
+	// one of: timeout (caller has given up waiting), busy (TryLock found the lock held), error.
+	AcquireFailures metrics.Counter
+	// UnlockFailures counts unlocks that have failed (including the ones of locks that haven't been held anymore).
+	UnlockFailures metrics.Counter
+	// Holders is the amount of current holders of a lock.
+	Holders metrics.Gauge
+}
+
+// HeldLock describes a lock that is currently held.
+type HeldLock struct {
+	Locker string    `json:"locker"`
+	Key    string    `json:"key"`
+	URL    string    `json:"url"`
+	Since  time.Time `json:"since"`
+	// Age is how long the lock has been held for.
+	Age time.Duration `json:"age_ns"`
+}
+
+type InstrumentingMiddleware struct {
+	name    string
+	locker  streaming.Locker
+	key     func(url string) string
+	striped bool
+	metrics Metrics
+	now     func() time.Time
+
+	mu sync.Mutex
+	// held are all the locks currently held through this middleware.
+	held map[*holder]struct{}
+	// locked are the locks acquired with Lock (by key), they are released with Unlock.
+	locked map[string]*holder
+}
+
+// holder is a single acquisition of a lock.
+type holder struct {
+	url   string
+	key   string
+	since time.Time
+}
+
+// NewInstrumentingMiddleware wraps locker with instrumenting middleware, name tells lockers apart in metrics,
+// key returns the name of the lock url maps to, striped tells whether these names are stripes (so that there is
+// a fixed amount of them).
+func NewInstrumentingMiddleware(
+	name string,
+	locker streaming.Locker,
+	key func(url string) string,
+	striped bool,
+	metrics Metrics,
+	now func() time.Time,
+) *InstrumentingMiddleware {
+	return &InstrumentingMiddleware{
+		name:    name,
+		locker:  locker,
+		key:     key,
+		striped: striped,
+		metrics: metrics,
+		now:     now,
+		held:    make(map[*holder]struct{}),
+		locked:  make(map[string]*holder),
+	}
+}
+
+func (mw *InstrumentingMiddleware) Lock(url string) error {
+	begin := mw.now()
+
+	err := mw.locker.Lock(url)
+	mw.observeWait(begin)
+	if err != nil {
+		mw.acquireFailed(err)
+		return err
+	}
+
+	h := mw.acquired(url)
+
+	mw.mu.Lock()
+	defer mw.mu.Unlock()
+
+	mw.locked[h.key] = h
+
+	return nil
+}
+
+func (mw *InstrumentingMiddleware) LockContext(ctx context.Context, url string) (streaming.Lease, error) {
+	begin := mw.now()
+
+	lease, err := mw.locker.LockContext(ctx, url)
+	mw.observeWait(begin)
+	if err != nil {
+		mw.acquireFailed(err)
+		return nil, err
+	}
+
+	return &instrumentedLease{Lease: lease, mw: mw, holder: mw.acquired(url)}, nil
+}
+
+func (mw *InstrumentingMiddleware) TryLock(url string) (streaming.Lease, bool, error) {
+	lease, ok, err := mw.locker.TryLock(url)
+	if err != nil {
+		mw.acquireFailed(err)
+		return nil, false, err
+	}
+	if !ok {
+		mw.metrics.AcquireFailures.With("locker", mw.name, "reason", "busy").Add(1)
+		return nil, false, nil
+	}
+
+	return &instrumentedLease{Lease: lease, mw: mw, holder: mw.acquired(url)}, true, nil
+}
+
+func (mw *InstrumentingMiddleware) Unlock(url string) (bool, error) {
+	key := mw.key(url)
+
+	mw.mu.Lock()
+	h, ok := mw.locked[key]
+	delete(mw.locked, key)
+	mw.mu.Unlock()
+
+	success, err := mw.locker.Unlock(url)
+	if ok {
+		mw.released(h)
+	}
+	if err != nil || !success {
+		mw.metrics.UnlockFailures.With("locker", mw.name).Add(1)
+	}
+
+	return success, err
+}
+
+// Held returns the locks currently held (through this middleware), the ones held for the longest go first.
+func (mw *InstrumentingMiddleware) Held() []HeldLock {
+	now := mw.now()
+
+	mw.mu.Lock()
+	result := make([]HeldLock, 0, len(mw.held))
+	for h := range mw.held {
+		result = append(result, HeldLock{
+			Locker: mw.name,
+			Key:    h.key,
+			URL:    h.url,
+			Since:  h.since,
+			Age:    now.Sub(h.since),
+		})
+	}
+	mw.mu.Unlock()
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Since.Before(result[j].Since)
+	})
+
+	return result
+}
+
+func (mw *InstrumentingMiddleware) observeWait(begin time.Time) {
+	mw.metrics.WaitTime.With("locker", mw.name).Observe(mw.now().Sub(begin).Seconds())
+}
+
+func (mw *InstrumentingMiddleware) acquireFailed(err error) {
+	reason := "error"
+	if errors.Is(err, streaming.ErrLockTimeout) {
+		reason = "timeout"
+	}
+
+	mw.metrics.AcquireFailures.With("locker", mw.name, "reason", reason).Add(1)
+}
+
+func (mw *InstrumentingMiddleware) acquired(url string) *holder {
+	h := &holder{
+		url:   url,
+		key:   mw.key(url),
+		since: mw.now(),
+	}
+
+	mw.mu.Lock()
+	mw.held[h] = struct{}{}
+	mw.mu.Unlock()
+
+	mw.metrics.Holders.With("locker", mw.name, "stripe", mw.stripe(h)).Add(1)
+
+	return h
+}
+
+func (mw *InstrumentingMiddleware) released(h *holder) {
+	mw.mu.Lock()
+	delete(mw.held, h)
+	mw.mu.Unlock()
+
+	mw.metrics.Holders.With("locker", mw.name, "stripe", mw.stripe(h)).Add(-1)
+	mw.metrics.HoldTime.With("locker", mw.name).Observe(mw.now().Sub(h.since).Seconds())
+}
+
+// stripe returns the value of "stripe" label for h.
+func (mw *InstrumentingMiddleware) stripe(h *holder) string {
+	if !mw.striped {
+		return ""
+	}
+
+	return h.key
+}
+
+type instrumentedLease struct {
+	streaming.Lease
+
+	mw     *InstrumentingMiddleware
+	holder *holder
+
+	once sync.Once
+}
+
+func (l *instrumentedLease) Unlock() (bool, error) {
+	success, err := l.Lease.Unlock()
+
+	l.once.Do(func() {
+		l.mw.released(l.holder)
+	})
+	if err != nil || !success {
+		l.mw.metrics.UnlockFailures.With("locker", l.mw.name).Add(1)
+	}
+
+	return success, err
+}
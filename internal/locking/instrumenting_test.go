@@ -0,0 +1,233 @@
+package locking_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/metrics"
+	kitprometheus "github.com/go-kit/kit/metrics/prometheus"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/LasTshaMAN/streaming"
+	"github.com/LasTshaMAN/streaming/internal/inmemory"
+	"github.com/LasTshaMAN/streaming/internal/locking"
+)
+
+func TestInstrumentingMiddleware(t *testing.T) {
+	const (
+		name = "inmem"
+		url  = "some url"
+	)
+
+	type fixture struct {
+		mw              *locking.InstrumentingMiddleware
+		clock           *fakeClock
+		waitTime        *fakeHistogram
+		holdTime        *fakeHistogram
+		acquireFailures *stdprometheus.CounterVec
+		unlockFailures  *stdprometheus.CounterVec
+		holders         *stdprometheus.GaugeVec
+	}
+
+	setup := func() fixture {
+		f := fixture{
+			clock:           &fakeClock{now: time.Now()},
+			waitTime:        &fakeHistogram{},
+			holdTime:        &fakeHistogram{},
+			acquireFailures: stdprometheus.NewCounterVec(stdprometheus.CounterOpts{Name: "acquire_failures"}, []string{"locker", "reason"}),
+			unlockFailures:  stdprometheus.NewCounterVec(stdprometheus.CounterOpts{Name: "unlock_failures"}, []string{"locker"}),
+			holders:         stdprometheus.NewGaugeVec(stdprometheus.GaugeOpts{Name: "holders"}, []string{"locker", "stripe"}),
+		}
+
+		locker := inmemory.NewURLLocker()
+
+		f.mw = locking.NewInstrumentingMiddleware(name, locker, locker.Key, false, locking.Metrics{
+			WaitTime:        f.waitTime,
+			HoldTime:        f.holdTime,
+			AcquireFailures: kitprometheus.NewCounter(f.acquireFailures),
+			UnlockFailures:  kitprometheus.NewCounter(f.unlockFailures),
+			Holders:         kitprometheus.NewGauge(f.holders),
+		}, f.clock.Now)
+
+		return f
+	}
+
+	t.Run("lease", func(t *testing.T) {
+		f := setup()
+
+		lease, err := f.mw.LockContext(context.Background(), url)
+		require.Nil(t, err)
+
+		assert.Equal(t, 1, f.waitTime.count())
+		assert.Equal(t, float64(1), testutil.ToFloat64(f.holders.WithLabelValues(name, "")))
+
+		f.clock.Add(time.Second)
+
+		held := f.mw.Held()
+		require.Len(t, held, 1)
+		assert.Equal(t, name, held[0].Locker)
+		assert.Equal(t, url, held[0].Key)
+		assert.Equal(t, time.Second, held[0].Age)
+
+		success, err := lease.Unlock()
+		assert.Nil(t, err)
+		assert.True(t, success)
+
+		assert.Equal(t, []float64{1}, f.holdTime.values())
+		assert.Equal(t, float64(0), testutil.ToFloat64(f.holders.WithLabelValues(name, "")))
+		assert.Empty(t, f.mw.Held())
+
+		// The second unlock fails.
+		success, err = lease.Unlock()
+		assert.Nil(t, err)
+		assert.False(t, success)
+
+		assert.Equal(t, float64(1), testutil.ToFloat64(f.unlockFailures.WithLabelValues(name)))
+		assert.Equal(t, float64(0), testutil.ToFloat64(f.holders.WithLabelValues(name, "")))
+	})
+
+	t.Run("lock and unlock by url", func(t *testing.T) {
+		f := setup()
+
+		err := f.mw.Lock(url)
+		require.Nil(t, err)
+		assert.Len(t, f.mw.Held(), 1)
+
+		success, err := f.mw.Unlock(url)
+		assert.Nil(t, err)
+		assert.True(t, success)
+		assert.Empty(t, f.mw.Held())
+		assert.Equal(t, 1, f.holdTime.count())
+
+		success, err = f.mw.Unlock(url)
+		assert.Nil(t, err)
+		assert.False(t, success)
+		assert.Equal(t, float64(1), testutil.ToFloat64(f.unlockFailures.WithLabelValues(name)))
+	})
+
+	t.Run("acquire failures", func(t *testing.T) {
+		f := setup()
+
+		lease, ok, err := f.mw.TryLock(url)
+		require.Nil(t, err)
+		require.True(t, ok)
+		defer lease.Unlock()
+
+		_, ok, err = f.mw.TryLock(url)
+		assert.Nil(t, err)
+		assert.False(t, ok)
+		assert.Equal(t, float64(1), testutil.ToFloat64(f.acquireFailures.WithLabelValues(name, "busy")))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err = f.mw.LockContext(ctx, url)
+		assert.True(t, errors.Is(err, streaming.ErrLockTimeout), err)
+		assert.Equal(t, float64(1), testutil.ToFloat64(f.acquireFailures.WithLabelValues(name, "timeout")))
+
+		// Only the one holding the lock is listed.
+		assert.Len(t, f.mw.Held(), 1)
+		assert.Equal(t, float64(1), testutil.ToFloat64(f.holders.WithLabelValues(name, "")))
+	})
+
+	t.Run("holders of stripes", func(t *testing.T) {
+		holders := stdprometheus.NewGaugeVec(stdprometheus.GaugeOpts{Name: "holders"}, []string{"locker", "stripe"})
+		locker := inmemory.NewLocker(4)
+
+		mw := locking.NewInstrumentingMiddleware(name, locker, locker.Key, true, locking.Metrics{
+			WaitTime:        &fakeHistogram{},
+			HoldTime:        &fakeHistogram{},
+			AcquireFailures: kitprometheus.NewCounter(stdprometheus.NewCounterVec(stdprometheus.CounterOpts{Name: "acquire_failures"}, []string{"locker", "reason"})),
+			UnlockFailures:  kitprometheus.NewCounter(stdprometheus.NewCounterVec(stdprometheus.CounterOpts{Name: "unlock_failures"}, []string{"locker"})),
+			Holders:         kitprometheus.NewGauge(holders),
+		}, time.Now)
+
+		lease, err := mw.LockContext(context.Background(), url)
+		require.Nil(t, err)
+
+		assert.Equal(t, float64(1), testutil.ToFloat64(holders.WithLabelValues(name, locker.Key(url))))
+
+		_, err = lease.Unlock()
+		assert.Nil(t, err)
+
+		assert.Equal(t, float64(0), testutil.ToFloat64(holders.WithLabelValues(name, locker.Key(url))))
+	})
+
+	t.Run("debug handler", func(t *testing.T) {
+		f := setup()
+
+		_, err := f.mw.LockContext(context.Background(), url)
+		require.Nil(t, err)
+		f.clock.Add(time.Second)
+		_, err = f.mw.LockContext(context.Background(), "other url")
+		require.Nil(t, err)
+
+		rec := httptest.NewRecorder()
+		locking.NewDebugHandler(f.mw).ServeHTTP(rec, httptest.NewRequest("GET", "/debug/locks", nil))
+
+		var got struct {
+			Locks []locking.HeldLock `json:"locks"`
+		}
+		err = json.Unmarshal(rec.Body.Bytes(), &got)
+		require.Nil(t, err)
+
+		require.Len(t, got.Locks, 2)
+		assert.Equal(t, url, got.Locks[0].URL)
+		assert.Equal(t, time.Second, got.Locks[0].Age)
+		assert.Equal(t, "other url", got.Locks[1].URL)
+	})
+}
+
+type fakeHistogram struct {
+	mu           sync.Mutex
+	observations []float64
+}
+
+func (h *fakeHistogram) With(...string) metrics.Histogram {
+	return h
+}
+
+func (h *fakeHistogram) Observe(value float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.observations = append(h.observations, value)
+}
+
+func (h *fakeHistogram) values() []float64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return append([]float64(nil), h.observations...)
+}
+
+func (h *fakeHistogram) count() int {
+	return len(h.values())
+}
+
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+func (c *fakeClock) Add(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+}
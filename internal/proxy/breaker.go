@@ -0,0 +1,118 @@
+package proxy
+
+import (
+	"sync"
+	"time"
+)
+
+// breakerState is the state of circuit breaker.
+type breakerState string
+
+const (
+	// breakerClosed lets every call through.
+	breakerClosed breakerState = "closed"
+	// breakerOpen doesn't let calls through until probeInterval elapses.
+	breakerOpen breakerState = "open"
+	// breakerHalfOpen lets a single call (probe) through, its outcome decides whether to close the breaker.
+	breakerHalfOpen breakerState = "half_open"
+)
+
+// breaker is a circuit breaker, it opens after threshold consecutive failures and probes for recovery
+// every probeInterval after that. Breaker with non-positive threshold never opens.
+type breaker struct {
+	threshold     int
+	probeInterval time.Duration
+	now           func() time.Time
+	// onChange is called (with breaker locked) every time breaker changes its state.
+	onChange func(from breakerState, to breakerState)
+
+	mu       sync.Mutex
+	state    breakerState
+	failures int
+	// changedAt is when breaker has been opened (or when the latest probe has started).
+	changedAt time.Time
+}
+
+func newBreaker(
+	threshold int,
+	probeInterval time.Duration,
+	now func() time.Time,
+	onChange func(from breakerState, to breakerState),
+) *breaker {
+	return &breaker{
+		threshold:     threshold,
+		probeInterval: probeInterval,
+		now:           now,
+		onChange:      onChange,
+		state:         breakerClosed,
+	}
+}
+
+// allow tells whether a call is allowed through, the caller must report its outcome with success or failure.
+func (b *breaker) allow() bool {
+	if b.threshold <= 0 {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if b.now().Sub(b.changedAt) < b.probeInterval {
+			return false
+		}
+
+		// Let this call be the probe.
+		b.setState(breakerHalfOpen)
+		return true
+	case breakerHalfOpen:
+		// In case the outcome of the probe in progress is never reported (for example, its caller has gone away),
+		// let's start another one after a while.
+		if b.now().Sub(b.changedAt) < b.probeInterval {
+			return false
+		}
+
+		b.changedAt = b.now()
+		return true
+	default:
+		return true
+	}
+}
+
+func (b *breaker) success() {
+	if b.threshold <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = 0
+	if b.state != breakerClosed {
+		b.setState(breakerClosed)
+	}
+}
+
+func (b *breaker) failure() {
+	if b.threshold <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	if b.state == breakerHalfOpen || (b.state == breakerClosed && b.failures >= b.threshold) {
+		b.setState(breakerOpen)
+	}
+}
+
+func (b *breaker) setState(state breakerState) {
+	from := b.state
+
+	b.state = state
+	b.changedAt = b.now()
+
+	b.onChange(from, state)
+}
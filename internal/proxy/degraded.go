@@ -0,0 +1,109 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+
+	"github.com/LasTshaMAN/streaming"
+)
+
+// DegradedPolicy defines what Proxy does while its storage (or locker) is unavailable.
+type DegradedPolicy string
+
+const (
+	// DegradedFailFast returns streaming.ErrStorageUnavailable right away (as well as when storage fails
+	// before it's considered unavailable).
+	DegradedFailFast DegradedPolicy = "fail_fast"
+	// DegradedBypass gets the data from fallback provider directly (without caching it or taking the lock),
+	// concurrent calls for the same URL are still coalesced within the process.
+	DegradedBypass DegradedPolicy = "bypass"
+	// DegradedL1Only returns streaming.ErrDataCurrentlyUnavailable right away, so that the data is served
+	// only from the tier in front of this one (stale data included).
+	DegradedL1Only DegradedPolicy = "l1_only"
+)
+
+// ParseDegradedPolicy returns DegradedPolicy named s.
+func ParseDegradedPolicy(s string) (DegradedPolicy, error) {
+	switch p := DegradedPolicy(s); p {
+	case DegradedFailFast, DegradedBypass, DegradedL1Only:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown degraded policy: %s", s)
+	}
+}
+
+// storageError is an error of storage (or locker) of a particular Proxy, as opposed to an error of fallback provider.
+//
+// Fallback provider might be Proxy as well, its storage errors must not be taken for the ones of the Proxy in front of it.
+type storageError struct {
+	proxy *Proxy
+	err   error
+}
+
+func (e *storageError) Error() string {
+	return e.err.Error()
+}
+
+func (e *storageError) Unwrap() error {
+	return e.err
+}
+
+func (srv *Proxy) storageErr(err error) error {
+	return &storageError{proxy: srv, err: err}
+}
+
+// isStorageErr tells whether err has been caused by storage (or locker) of this Proxy.
+func (srv *Proxy) isStorageErr(err error) bool {
+	for err != nil {
+		if se, ok := err.(*storageError); ok && se.proxy == srv {
+			return true
+		}
+
+		err = errors.Unwrap(err)
+	}
+
+	return false
+}
+
+// getDegraded gets the data behind url while storage is unavailable, according to the degraded policy.
+func (srv *Proxy) getDegraded(ctx context.Context, url string) (string, time.Duration, error) {
+	switch srv.opts.Degraded.Policy {
+	case DegradedBypass:
+		data, ttl, _, err := srv.bypassFlights.Do(ctx, url, func() (string, time.Duration, error) {
+			data, ttl, err := srv.fallback.Get(ctx, url)
+			if err != nil && !errors.Is(err, streaming.ErrDataCurrentlyUnavailable) {
+				return "", 0, fmt.Errorf("get data from fallback provider (bypassing storage), err: %w", err)
+			}
+
+			return data, srv.adjustTTL(ttl), err
+		})
+
+		return data, ttl, err
+	case DegradedL1Only:
+		return "", 0, streaming.ErrDataCurrentlyUnavailable
+	default:
+		return "", 0, fmt.Errorf("url: %s, err: %w", url, streaming.ErrStorageUnavailable)
+	}
+}
+
+// onBreakerChange logs and counts transitions between normal and degraded modes.
+func (srv *Proxy) onBreakerChange(from breakerState, to breakerState) {
+	srv.metrics.ModeTransitions.With("tier", srv.opts.Name, "from", string(from), "to", string(to)).Add(1)
+
+	switch to {
+	case breakerOpen:
+		_ = level.Warn(srv.logger).Log("msg", fmt.Sprintf(
+			"Proxy %s: storage is unavailable, switching to degraded mode (policy: %s)",
+			srv.opts.Name,
+			srv.opts.Degraded.Policy,
+		))
+	case breakerHalfOpen:
+		_ = level.Info(srv.logger).Log("msg", fmt.Sprintf("Proxy %s: probing whether storage has recovered", srv.opts.Name))
+	case breakerClosed:
+		_ = level.Info(srv.logger).Log("msg", fmt.Sprintf("Proxy %s: storage has recovered, switching to normal mode", srv.opts.Name))
+	}
+}
@@ -0,0 +1,75 @@
+package proxy
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// entryPrefix marks values written by proxy.Proxy in storage, values without it have been written
+	// by an older version of proxy.Proxy (that didn't know about grace periods).
+	entryPrefix = "\x00proxy-entry:"
+
+	kindData        = "d"
+	kindUnavailable = "u"
+
+	dataUnavailableMarker = "data is currently unavailable"
+)
+
+// entry is what proxy.Proxy keeps in storage for a URL.
+//
+// Entry is kept in storage for ttl + grace, where ttl is how long the data is fresh for, and grace is how long
+// it can be served stale (while being revalidated, or when fallback provider fails).
+// Storage reports the remaining time to live of the entry as a whole, so that in order to tell fresh data from
+// stale data we keep grace along with the data.
+type entry struct {
+	data        string
+	unavailable bool
+	// ttl is how long the data has been fresh for when it was stored, it's set by Proxy.set.
+	ttl   time.Duration
+	grace time.Duration
+}
+
+func (e entry) encode() string {
+	kind := kindData
+	if e.unavailable {
+		kind = kindUnavailable
+	}
+
+	return entryPrefix +
+		strconv.FormatInt(int64(e.grace), 10) + ":" +
+		strconv.FormatInt(int64(e.ttl), 10) + ":" +
+		kind + ":" +
+		e.data
+}
+
+func decodeEntry(value string) entry {
+	if !strings.HasPrefix(value, entryPrefix) {
+		return entry{
+			data:        value,
+			unavailable: value == dataUnavailableMarker,
+		}
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(value, entryPrefix), ":", 4)
+	if len(parts) != 4 {
+		return entry{data: value}
+	}
+
+	grace, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return entry{data: value}
+	}
+	ttl, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return entry{data: value}
+	}
+
+	return entry{
+		data:        parts[3],
+		unavailable: parts[2] == kindUnavailable,
+		ttl:         time.Duration(ttl),
+		grace:       time.Duration(grace),
+	}
+}
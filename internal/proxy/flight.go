@@ -0,0 +1,105 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/LasTshaMAN/streaming"
+)
+
+// flight is a call to get the data behind a URL that is in progress.
+type flight struct {
+	done chan struct{}
+
+	data string
+	ttl  time.Duration
+	err  error
+}
+
+// flightGroup coalesces concurrent calls for the same URL (within a single process), so that only one of them
+// is actually executed, while the others wait for its result and share it.
+type flightGroup struct {
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+func newFlightGroup() *flightGroup {
+	return &flightGroup{
+		flights: make(map[string]*flight),
+	}
+}
+
+// Do executes fn unless there is a call for url in progress already, in which case it waits for that call to finish
+// (or ctx to be done) and returns its result. shared tells whether the result has come from another call.
+func (g *flightGroup) Do(
+	ctx context.Context,
+	url string,
+	fn func() (string, time.Duration, error),
+) (data string, ttl time.Duration, shared bool, err error) {
+	for {
+		g.mu.Lock()
+		f, ok := g.flights[url]
+		if !ok {
+			f = &flight{done: make(chan struct{})}
+			g.flights[url] = f
+			g.mu.Unlock()
+
+			f.data, f.ttl, f.err = fn()
+
+			g.mu.Lock()
+			delete(g.flights, url)
+			g.mu.Unlock()
+			close(f.done)
+
+			return f.data, f.ttl, false, f.err
+		}
+		g.mu.Unlock()
+
+		select {
+		case <-f.done:
+		case <-ctx.Done():
+			return "", 0, false, ctx.Err()
+		}
+
+		if isContextErr(f.err) && ctx.Err() == nil {
+			// The call we've been waiting for has been cancelled by its own caller, it says nothing about the data
+			// we are after, so let's try again.
+			continue
+		}
+
+		return f.data, f.ttl, true, f.err
+	}
+}
+
+func isContextErr(err error) bool {
+	return errors.Is(err, context.Canceled) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, streaming.ErrLockTimeout)
+}
+
+// Go executes fn in background unless there is a call for url in progress already, it returns whether fn
+// has been started.
+func (g *flightGroup) Go(url string, fn func() (string, time.Duration, error)) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if _, ok := g.flights[url]; ok {
+		return false
+	}
+
+	f := &flight{done: make(chan struct{})}
+	g.flights[url] = f
+
+	go func() {
+		f.data, f.ttl, f.err = fn()
+
+		g.mu.Lock()
+		delete(g.flights, url)
+		g.mu.Unlock()
+		close(f.done)
+	}()
+
+	return true
+}
@@ -0,0 +1,60 @@
+package proxy
+
+import (
+	"sync"
+	"time"
+)
+
+// hitCounter estimates how many times each URL has been requested within a sliding window.
+//
+// The estimate is based on 2 fixed windows: the current one, and the previous one (weighted by the share of it
+// that overlaps with the sliding window).
+//
+// hitCounter can be safely used concurrently from multiple go-routines.
+type hitCounter struct {
+	window time.Duration
+	now    func() time.Time
+
+	mu          sync.Mutex
+	windowStart time.Time
+	current     map[string]int
+	previous    map[string]int
+}
+
+func newHitCounter(window time.Duration, now func() time.Time) *hitCounter {
+	return &hitCounter{
+		window:      window,
+		now:         now,
+		windowStart: now(),
+		current:     make(map[string]int),
+		previous:    make(map[string]int),
+	}
+}
+
+// Hit records a request for url and returns the amount of requests for it within the sliding window.
+func (c *hitCounter) Hit(url string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+
+	elapsed := now.Sub(c.windowStart)
+	if elapsed >= c.window {
+		if elapsed < 2*c.window {
+			c.previous = c.current
+		} else {
+			// The previous window has passed without a single hit.
+			c.previous = make(map[string]int)
+		}
+		c.current = make(map[string]int)
+
+		c.windowStart = c.windowStart.Add(elapsed / c.window * c.window)
+		elapsed = now.Sub(c.windowStart)
+	}
+
+	c.current[url]++
+
+	overlap := 1 - float64(elapsed)/float64(c.window)
+
+	return c.current[url] + int(float64(c.previous[url])*overlap)
+}
@@ -8,6 +8,7 @@ import (
 
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
+	"github.com/go-kit/kit/metrics"
 
 	"github.com/LasTshaMAN/streaming"
 )
@@ -16,9 +17,18 @@ type Proxy struct {
 	logger log.Logger
 
 	storage streaming.TempDataStorage
+	// fenced is storage that supports fencing tokens, it's nil if storage doesn't.
+	fenced streaming.FencedDataStorage
 
 	locker streaming.Locker
 
+	// flights makes concurrent misses for the same URL (within this process) wait on locker and fallback only once.
+	flights *flightGroup
+	// bypassFlights coalesces calls to fallback provider made while storage is bypassed (see DegradedBypass).
+	bypassFlights *flightGroup
+	// breaker tracks whether storage is available.
+	breaker *breaker
+
 	fallback streaming.DataProvider
 
 	// adjustTTL based on different factors (these factors are defined by the user of this struct -> hence this is a func).
@@ -33,6 +43,55 @@ type Proxy struct {
 	// this ttl value is already stale.
 	// That's why we need to adjust the ttl value (to avoid serving stale data) fallback provider returns.
 	adjustTTL func(fallbackTTL time.Duration) time.Duration
+
+	opts Options
+
+	// hits tracks how popular URLs are, it's nil when refresh-ahead is disabled.
+	hits *hitCounter
+
+	metrics Metrics
+}
+
+// Metrics describes Proxy modes of operation.
+type Metrics struct {
+	// ModeTransitions counts transitions between normal and degraded modes, it has "tier", "from" and "to" labels,
+	// where "from" and "to" are one of: closed (normal mode), open (degraded mode), half_open (probing storage).
+	ModeTransitions metrics.Counter
+}
+
+// Options tune caching behaviour of Proxy.
+type Options struct {
+	// Name identifies this tier in logs and metrics.
+	Name string
+	// Grace is how long data is kept in storage past its ttl.
+	//
+	// During this period the data is stale, but we can still serve it:
+	// - right away, while refreshing it in background (stale-while-revalidate)
+	// - when fallback provider fails to get fresh data (stale-if-error)
+	Grace time.Duration
+	// RefreshAheadShare is the share of ttl (between 0 and 1) after which hot data is refreshed in background,
+	// so that it doesn't expire while being in demand. 0 disables refresh-ahead.
+	RefreshAheadShare float64
+	// RefreshAheadMinHits is the amount of requests within RefreshAheadWindow it takes for data to become hot.
+	RefreshAheadMinHits int
+	RefreshAheadWindow  time.Duration
+	// BackgroundFetchTimeout limits fetches made in background (revalidation of stale data, refresh-ahead),
+	// nobody waits for them, so without a deadline a hung fallback provider would keep the lock (which is extended
+	// for as long as the fetch goes on) forever. 0 means no limit.
+	BackgroundFetchTimeout time.Duration
+	// Degraded decides what happens while storage (or locker) is unavailable.
+	Degraded DegradedOptions
+}
+
+// DegradedOptions configure degraded mode of Proxy.
+//
+// Proxy switches to degraded mode after FailureThreshold consecutive storage failures, and probes storage
+// every ProbeInterval after that until it recovers. Non-positive FailureThreshold means Proxy never switches
+// to degraded mode (but it still follows Policy when storage fails).
+type DegradedOptions struct {
+	Policy           DegradedPolicy
+	FailureThreshold int
+	ProbeInterval    time.Duration
 }
 
 func NewProxy(
@@ -41,67 +100,253 @@ func NewProxy(
 	locker streaming.Locker,
 	fallback streaming.DataProvider,
 	adjustTTL func(fallbackTTL time.Duration) time.Duration,
+	opts Options,
+	metrics Metrics,
+	now func() time.Time,
 ) *Proxy {
-	return &Proxy{
-		logger:    logger,
-		storage:   storage,
-		fallback:  fallback,
-		locker:    locker,
-		adjustTTL: adjustTTL,
+	var hits *hitCounter
+	if opts.RefreshAheadShare > 0 && opts.RefreshAheadWindow > 0 {
+		hits = newHitCounter(opts.RefreshAheadWindow, now)
+	}
+
+	fenced, _ := storage.(streaming.FencedDataStorage)
+
+	srv := &Proxy{
+		logger:        logger,
+		storage:       storage,
+		fenced:        fenced,
+		fallback:      fallback,
+		locker:        locker,
+		flights:       newFlightGroup(),
+		bypassFlights: newFlightGroup(),
+		adjustTTL:     adjustTTL,
+		opts:          opts,
+		hits:          hits,
+		metrics:       metrics,
 	}
+	srv.breaker = newBreaker(opts.Degraded.FailureThreshold, opts.Degraded.ProbeInterval, now, srv.onBreakerChange)
+
+	return srv
 }
 
+// Get returns the data behind url, stale data is returned with 0 ttl.
+//
+// While storage is unavailable, Get follows degraded policy (see DegradedOptions).
 func (srv *Proxy) Get(ctx context.Context, url string) (string, time.Duration, error) {
-	found, data, ttl, err := srv.tryStorage(ctx, url)
-	if found {
+	if !srv.breaker.allow() {
+		return srv.getDegraded(ctx, url)
+	}
+
+	data, ttl, err := srv.get(ctx, url)
+	if ctx.Err() != nil {
+		// Whatever has happened, it says nothing about storage.
 		return data, ttl, err
 	}
+	if srv.isStorageErr(err) {
+		srv.breaker.failure()
+
+		if srv.opts.Degraded.Policy == DegradedFailFast || srv.opts.Degraded.Policy == "" {
+			return data, ttl, fmt.Errorf("%w: %v", streaming.ErrStorageUnavailable, err)
+		}
+
+		_ = level.Error(srv.logger).Log("err", fmt.Errorf("get data (degrading), err: %w", err))
+
+		return srv.getDegraded(ctx, url)
+	}
+	srv.breaker.success()
+
+	return data, ttl, err
+}
+
+func (srv *Proxy) get(ctx context.Context, url string) (string, time.Duration, error) {
+	e, ttl, found, err := srv.tryStorage(ctx, url)
 	if err != nil {
 		return "", 0, fmt.Errorf("try storage, err: %w", err)
 	}
+	if found && ttl > 0 {
+		if srv.isHot(url) && srv.needsRefresh(e, ttl) {
+			srv.refreshAhead(url)
+		}
+
+		return e.result(ttl)
+	}
+	if found && !e.unavailable {
+		// The data is stale, but it's still within grace period, so we'd rather not keep our caller waiting.
+		srv.revalidate(url)
 
-	err = srv.locker.Lock(url)
+		return e.data, 0, nil
+	}
+
+	data, ttl, _, err := srv.flights.Do(ctx, url, func() (string, time.Duration, error) {
+		return srv.getLocked(ctx, url)
+	})
+
+	return data, ttl, err
+}
+
+// revalidate refreshes the data behind url in background, unless it's being refreshed already.
+func (srv *Proxy) revalidate(url string) {
+	srv.flights.Go(url, func() (string, time.Duration, error) {
+		_ = level.Info(srv.logger).Log("msg", fmt.Sprintf("Proxy: revalidate stale data, URL: %s", url))
+
+		ctx, cancel := srv.backgroundContext()
+		defer cancel()
+
+		data, ttl, err := srv.getLocked(ctx, url)
+		if err != nil && !errors.Is(err, streaming.ErrDataCurrentlyUnavailable) {
+			_ = level.Error(srv.logger).Log("err", fmt.Errorf("revalidate stale data, URL: %s, err: %w", url, err))
+		}
+
+		return data, ttl, err
+	})
+}
+
+// backgroundContext returns the context for a fetch made in background (see Options.BackgroundFetchTimeout).
+func (srv *Proxy) backgroundContext() (context.Context, context.CancelFunc) {
+	if srv.opts.BackgroundFetchTimeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
+
+	return context.WithTimeout(context.Background(), srv.opts.BackgroundFetchTimeout)
+}
+
+// isHot records a request for url and tells whether url is popular enough to be refreshed ahead of time.
+func (srv *Proxy) isHot(url string) bool {
+	if srv.hits == nil {
+		return false
+	}
+
+	return srv.hits.Hit(url) >= srv.opts.RefreshAheadMinHits
+}
+
+// needsRefresh tells whether the share of e ttl that has passed (ttl is the remaining part of it) calls for
+// refresh-ahead.
+func (srv *Proxy) needsRefresh(e entry, ttl time.Duration) bool {
+	if srv.opts.RefreshAheadShare <= 0 || e.unavailable || e.ttl <= 0 {
+		return false
+	}
+
+	elapsed := float64(e.ttl-ttl) / float64(e.ttl)
+
+	return elapsed >= srv.opts.RefreshAheadShare
+}
+
+// refreshAhead refreshes the data behind url in background before it expires, unless it's being refreshed already.
+func (srv *Proxy) refreshAhead(url string) {
+	srv.flights.Go(url, func() (string, time.Duration, error) {
+		ctx, cancel := srv.backgroundContext()
+		defer cancel()
+
+		data, ttl, err := srv.refresh(ctx, url)
+		if err != nil && !errors.Is(err, streaming.ErrDataCurrentlyUnavailable) {
+			_ = level.Error(srv.logger).Log("err", fmt.Errorf("refresh data ahead of time, URL: %s, err: %w", url, err))
+		}
+
+		return data, ttl, err
+	})
+}
+
+// refresh fetches fresh data from fallback provider and stores it unless somebody else (possibly another process)
+// has done it already, it returns the freshest data available.
+func (srv *Proxy) refresh(ctx context.Context, url string) (string, time.Duration, error) {
+	lease, ok, err := srv.locker.TryLock(url)
 	if err != nil {
-		return "", 0, fmt.Errorf("lock locker, err: %w", err)
+		return "", 0, fmt.Errorf("try lock locker, err: %w", srv.storageErr(err))
 	}
-	defer func() {
-		success, unlockErr := srv.locker.Unlock(url)
-		if unlockErr != nil {
-			_ = level.Error(srv.logger).Log("err", fmt.Errorf("unlock locker, err: %w", unlockErr))
-			return
+	if !ok {
+		// Somebody else is refreshing the data (or fetching other data under the same lock) already,
+		// there is still some time left before the data expires, so we can afford not to wait.
+		current, currentTTL, found, err := srv.tryStorage(ctx, url)
+		if err != nil {
+			return "", 0, fmt.Errorf("try storage, err: %w", err)
 		}
-		if !success {
-			_ = level.Error(srv.logger).Log("err", "release lock, operation is unsuccessful")
-			return
+		if found && currentTTL > 0 {
+			return current.result(currentTTL)
 		}
-	}()
+		// The ones waiting for us (if any) will try on their own.
+		return "", 0, fmt.Errorf("url: %s, err: %w", url, streaming.ErrLockTimeout)
+	}
+	defer srv.unlock(lease)
+
+	current, currentTTL, found, err := srv.tryStorage(ctx, url)
+	if err != nil {
+		return "", 0, fmt.Errorf("try storage, err: %w", err)
+	}
+	if found && currentTTL > 0 && !srv.needsRefresh(current, currentTTL) {
+		// Somebody has refreshed the data while we were waiting for the lock.
+		return current.result(currentTTL)
+	}
+
+	_ = level.Info(srv.logger).Log("msg", fmt.Sprintf("Proxy: refresh data ahead of time, URL: %s", url))
+
+	data, ttl, err := srv.fallback.Get(ctx, url)
+	if err != nil && found && currentTTL > 0 {
+		// Let's keep the data we have, there is still some time left before it expires.
+		return current.result(currentTTL)
+	}
+	if err != nil {
+		return "", 0, fmt.Errorf("get data from fallback provider, err: %w", err)
+	}
+
+	ttl = srv.adjustTTL(ttl)
+
+	err = srv.set(ctx, lease, url, entry{data: data, grace: srv.opts.Grace}, ttl)
+	if err != nil {
+		return "", 0, fmt.Errorf("set data (with expiration) for url in storage, err: %w", err)
+	}
+
+	return data, ttl, nil
+}
+
+// getLocked gets the data behind url from fallback provider (and caches it in storage) unless somebody else does it,
+// it coordinates with other processes through locker.
+func (srv *Proxy) getLocked(ctx context.Context, url string) (string, time.Duration, error) {
+	lease, err := srv.locker.LockContext(ctx, url)
+	if err != nil && !errors.Is(err, streaming.ErrLockTimeout) {
+		return "", 0, fmt.Errorf("lock locker, err: %w", srv.storageErr(err))
+	}
+	if err != nil {
+		return "", 0, fmt.Errorf("lock locker, err: %w", err)
+	}
+	defer srv.unlock(lease)
 
 	// Check once again whether the data is in storage, since another go-routine might have put it there while we
 	// were performing "the fast scenario" (a piece of code above).
 
-	found, data, ttl, err = srv.tryStorage(ctx, url)
-	if found {
-		return data, ttl, err
-	}
+	stale, staleTTL, found, err := srv.tryStorage(ctx, url)
 	if err != nil {
 		return "", 0, fmt.Errorf("try storage, err: %w", err)
 	}
+	if found && staleTTL > 0 {
+		return stale.result(staleTTL)
+	}
+	hasStale := found && !stale.unavailable
 
 	// At this point nobody concurrently with us can to fetch the data from fallback provider and cache it in our storage.
+	// Even if our lock expires in the meantime (and somebody else acquires it), storage rejects our write
+	// (given it supports fencing tokens), see set.
 
 	_ = level.Info(srv.logger).Log("msg", fmt.Sprintf("Proxy: fetch data from fallback provider, URL: %s", url))
 
-	data, ttl, err = srv.fallback.Get(ctx, url)
+	data, ttl, err := srv.fallback.Get(ctx, url)
 	if err != nil && !errors.Is(err, streaming.ErrDataCurrentlyUnavailable) {
+		if hasStale && ctx.Err() == nil {
+			_ = level.Error(srv.logger).Log("err", fmt.Errorf("get data from fallback provider (serving stale data), err: %w", err))
+			return stale.data, 0, nil
+		}
 		return "", 0, fmt.Errorf("get data from fallback provider, err: %w", err)
 	}
 
 	if errors.Is(err, streaming.ErrDataCurrentlyUnavailable) {
-		// We are caching "temporary unavailable" error response for efficiency / performance reasons.
-
 		ttl = srv.adjustTTL(ttl)
 
-		setErr := srv.storage.Set(ctx, url, dataUnavailableMarker, ttl)
+		if hasStale {
+			return srv.keepStale(ctx, lease, url, stale, staleTTL, ttl)
+		}
+
+		// We are caching "temporary unavailable" error response for efficiency / performance reasons.
+
+		setErr := srv.set(ctx, lease, url, entry{unavailable: true, grace: srv.opts.Grace}, ttl)
 		if setErr != nil {
 			return "", 0, fmt.Errorf("set data unavailable marker (with expiration) for url in storage, err: %w", setErr)
 		}
@@ -111,7 +356,7 @@ func (srv *Proxy) Get(ctx context.Context, url string) (string, time.Duration, e
 
 	ttl = srv.adjustTTL(ttl)
 
-	setErr := srv.storage.Set(ctx, url, data, ttl)
+	setErr := srv.set(ctx, lease, url, entry{data: data, grace: srv.opts.Grace}, ttl)
 	if setErr != nil {
 		return "", 0, fmt.Errorf("set data (with expiration) for url in storage, err: %w", setErr)
 	}
@@ -119,24 +364,102 @@ func (srv *Proxy) Get(ctx context.Context, url string) (string, time.Duration, e
 	return data, ttl, nil
 }
 
-func (srv *Proxy) tryStorage(ctx context.Context, url string) (found bool, data string, ttl time.Duration, err error) {
-	data, ttl, err = srv.storage.Get(ctx, url)
+func (srv *Proxy) unlock(lease streaming.Lease) {
+	success, err := lease.Unlock()
+	if err != nil {
+		_ = level.Error(srv.logger).Log("err", fmt.Errorf("unlock locker, err: %w", err))
+		return
+	}
+	if !success {
+		_ = level.Error(srv.logger).Log("err", "release lock, operation is unsuccessful")
+		return
+	}
+}
+
+// keepStale serves stale data instead of "data is currently unavailable" error (stale-if-error).
+//
+// Stale data is considered good for unavailableTTL (so that we don't hammer fallback provider), but it's never kept
+// past its original grace period, staleTTL is the (negative) ttl of stale data.
+func (srv *Proxy) keepStale(
+	ctx context.Context,
+	lease streaming.Lease,
+	url string,
+	stale entry,
+	staleTTL time.Duration,
+	unavailableTTL time.Duration,
+) (string, time.Duration, error) {
+	remaining := staleTTL + stale.grace
+
+	ttl := unavailableTTL
+	if ttl > remaining {
+		ttl = remaining
+	}
+	if ttl < 0 {
+		ttl = 0
+	}
+
+	setErr := srv.set(ctx, lease, url, entry{data: stale.data, grace: remaining - ttl}, ttl)
+	if setErr != nil {
+		return "", 0, fmt.Errorf("set stale data (with expiration) for url in storage, err: %w", setErr)
+	}
+
+	return stale.data, ttl, nil
+}
+
+// set stores e (that is fresh for ttl) in storage for ttl + e.grace, as long as lease is still held.
+func (srv *Proxy) set(ctx context.Context, lease streaming.Lease, url string, e entry, ttl time.Duration) error {
+	if ttl+e.grace <= 0 {
+		// There is nothing to keep.
+		return nil
+	}
+	if !lease.Held() {
+		// Somebody else might have fetched (and stored) fresher data while we weren't holding the lock,
+		// we don't want to overwrite it.
+		_ = level.Warn(srv.logger).Log("msg", fmt.Sprintf("Proxy: lock lease has been lost, not storing data, URL: %s", url))
+		return nil
+	}
+
+	e.ttl = ttl
+
+	var err error
+	if srv.fenced == nil || lease.Token() == 0 {
+		err = srv.storage.Set(ctx, url, e.encode(), ttl+e.grace)
+	} else {
+		err = srv.fenced.SetFenced(ctx, url, e.encode(), ttl+e.grace, lease.Token())
+	}
+	if errors.Is(err, streaming.ErrFencingTokenRejected) {
+		// Somebody has acquired the lock after us (and stored the data already).
+		_ = level.Warn(srv.logger).Log("msg", fmt.Sprintf("Proxy: fencing token has been rejected, not storing data, URL: %s", url))
+		return nil
+	}
+	if err != nil {
+		return srv.storageErr(err)
+	}
+
+	return nil
+}
+
+// tryStorage returns the entry for url along with its ttl, negative (or 0) ttl means the entry is stale.
+func (srv *Proxy) tryStorage(ctx context.Context, url string) (e entry, ttl time.Duration, found bool, err error) {
+	value, storageTTL, err := srv.storage.Get(ctx, url)
 
 	if err != nil && !errors.Is(err, streaming.ErrDataNotFoundInStorage) {
-		return false, "", 0, fmt.Errorf("get data from storage, err: %w", err)
+		return entry{}, 0, false, srv.storageErr(fmt.Errorf("get data from storage, err: %w", err))
 	}
 
 	if errors.Is(err, streaming.ErrDataNotFoundInStorage) {
-		return false, "", 0, nil
+		return entry{}, 0, false, nil
 	}
 
-	if data == dataUnavailableMarker {
-		return true, "", ttl, streaming.ErrDataCurrentlyUnavailable
-	}
+	e = decodeEntry(value)
 
-	return true, data, ttl, nil
+	return e, storageTTL - e.grace, true, nil
 }
 
-const (
-	dataUnavailableMarker = "data is currently unavailable"
-)
+func (e entry) result(ttl time.Duration) (string, time.Duration, error) {
+	if e.unavailable {
+		return "", ttl, streaming.ErrDataCurrentlyUnavailable
+	}
+
+	return e.data, ttl, nil
+}
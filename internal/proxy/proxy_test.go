@@ -0,0 +1,847 @@
+package proxy_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/metrics/discard"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/LasTshaMAN/streaming"
+	"github.com/LasTshaMAN/streaming/internal/inmemory"
+	"github.com/LasTshaMAN/streaming/internal/proxy"
+)
+
+func TestProxy_Coalescing(t *testing.T) {
+	const (
+		url     = "some url"
+		data    = "some data"
+		ttl     = time.Minute
+		callers = 50
+	)
+
+	tests := []struct {
+		name     string
+		fallback *blockingProvider
+		data     string
+		err      error
+	}{
+		{
+			name:     "data",
+			fallback: &blockingProvider{data: data, ttl: ttl},
+			data:     data,
+		},
+		{
+			name:     "data is currently unavailable",
+			fallback: &blockingProvider{ttl: ttl, err: streaming.ErrDataCurrentlyUnavailable},
+			err:      streaming.ErrDataCurrentlyUnavailable,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fallback.release = make(chan struct{})
+			locker := &countingLocker{Locker: inmemory.NewLocker(1)}
+
+			p := proxy.NewProxy(log.NewNopLogger(), inmemory.NewStorage(time.Now), locker, tt.fallback, noAdjustment, proxy.Options{}, noMetrics(), time.Now)
+
+			var wg sync.WaitGroup
+			for i := 0; i < callers; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+
+					got, _, err := p.Get(context.Background(), url)
+					assert.Equal(t, tt.data, got)
+					assert.True(t, errors.Is(err, tt.err), err)
+				}()
+			}
+
+			// Let all the callers pile up behind the first one.
+			time.Sleep(50 * time.Millisecond)
+			close(tt.fallback.release)
+			wg.Wait()
+
+			assert.EqualValues(t, 1, atomic.LoadInt64(&tt.fallback.calls))
+			assert.EqualValues(t, 1, atomic.LoadInt64(&locker.locks))
+		})
+	}
+
+	t.Run("cancelled caller doesn't fail the others", func(t *testing.T) {
+		fallback := &blockingProvider{data: data, ttl: ttl, release: make(chan struct{}), honorCtx: true}
+
+		p := proxy.NewProxy(log.NewNopLogger(), inmemory.NewStorage(time.Now), inmemory.NewLocker(1), fallback, noAdjustment, proxy.Options{}, noMetrics(), time.Now)
+
+		ctx, cancel := context.WithCancel(context.Background())
+
+		firstDone := make(chan struct{})
+		go func() {
+			defer close(firstDone)
+
+			_, _, err := p.Get(ctx, url)
+			assert.True(t, errors.Is(err, context.Canceled), err)
+		}()
+		time.Sleep(20 * time.Millisecond)
+
+		secondDone := make(chan struct{})
+		go func() {
+			defer close(secondDone)
+
+			got, _, err := p.Get(context.Background(), url)
+			assert.Nil(t, err)
+			assert.Equal(t, data, got)
+		}()
+		time.Sleep(20 * time.Millisecond)
+
+		cancel()
+		<-firstDone
+
+		close(fallback.release)
+		<-secondDone
+
+		assert.EqualValues(t, 2, atomic.LoadInt64(&fallback.calls))
+	})
+}
+
+func TestProxy_LockTimeout(t *testing.T) {
+	const url = "some url"
+
+	locker := inmemory.NewLocker(1)
+	fallback := &sequenceProvider{results: []providerResult{{data: "some data", ttl: time.Minute}}}
+
+	p := proxy.NewProxy(log.NewNopLogger(), inmemory.NewStorage(time.Now), locker, fallback, noAdjustment, proxy.Options{}, noMetrics(), time.Now)
+
+	// Somebody else is holding the lock.
+	err := locker.Lock(url)
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, _, err = p.Get(ctx, url)
+	assert.True(t, errors.Is(err, streaming.ErrLockTimeout), err)
+	assert.Equal(t, 0, fallback.callCount())
+}
+
+func TestProxy_LeaseLost(t *testing.T) {
+	const url = "some url"
+
+	storage := inmemory.NewStorage(time.Now)
+	fallback := &sequenceProvider{results: []providerResult{{data: "some data", ttl: time.Minute}}}
+
+	p := proxy.NewProxy(log.NewNopLogger(), storage, losingLocker{Locker: inmemory.NewLocker(1)}, fallback, noAdjustment, proxy.Options{}, noMetrics(), time.Now)
+
+	// The caller still gets the data, ...
+	got, ttl, err := p.Get(context.Background(), url)
+	assert.Nil(t, err)
+	assert.Equal(t, "some data", got)
+	assert.Equal(t, time.Minute, ttl)
+
+	// ... but it isn't stored.
+	_, _, err = storage.Get(context.Background(), url)
+	assert.True(t, errors.Is(err, streaming.ErrDataNotFoundInStorage), err)
+}
+
+func TestProxy_Fencing(t *testing.T) {
+	const url = "some url"
+
+	storage := &fencedStorage{TempDataStorage: inmemory.NewStorage(time.Now)}
+	fallback := &sequenceProvider{results: []providerResult{{data: "some data", ttl: time.Minute}}}
+	locker := &fencingLocker{Locker: inmemory.NewLocker(1)}
+
+	p := proxy.NewProxy(log.NewNopLogger(), storage, locker, fallback, noAdjustment, proxy.Options{}, noMetrics(), time.Now)
+
+	// Somebody with a newer token has stored the data already.
+	storage.lastToken = 10
+	locker.token = 5
+
+	got, _, err := p.Get(context.Background(), url)
+	assert.Nil(t, err)
+	assert.Equal(t, "some data", got)
+
+	_, _, err = storage.Get(context.Background(), url)
+	assert.True(t, errors.Is(err, streaming.ErrDataNotFoundInStorage), err)
+
+	// Our token is the newest one now.
+	locker.token = 11
+
+	got, _, err = p.Get(context.Background(), url)
+	assert.Nil(t, err)
+	assert.Equal(t, "some data", got)
+
+	_, _, err = storage.Get(context.Background(), url)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 11, storage.lastToken)
+}
+
+func TestProxy_Stale(t *testing.T) {
+	const (
+		url   = "some url"
+		ttl   = time.Minute
+		grace = 10 * time.Minute
+	)
+
+	var (
+		ctx = context.Background()
+
+		start = time.Now()
+	)
+
+	// setup caches "old data" and moves the clock past its ttl (but within grace period).
+	setup := func(fallback streaming.DataProvider, locker streaming.Locker, opts proxy.Options) (*proxy.Proxy, *fakeClock) {
+		clock := &fakeClock{now: start}
+
+		opts.Grace = grace
+		p := proxy.NewProxy(log.NewNopLogger(), inmemory.NewStorage(clock.Now), locker, fallback, noAdjustment, opts, noMetrics(), clock.Now)
+
+		got, gotTTL, err := p.Get(ctx, url)
+		assert.Nil(t, err)
+		assert.Equal(t, "old data", got)
+		assert.Equal(t, ttl, gotTTL)
+
+		clock.Add(ttl + time.Second)
+
+		return p, clock
+	}
+
+	t.Run("stale data is served while being revalidated", func(t *testing.T) {
+		fallback := &sequenceProvider{
+			results: []providerResult{
+				{data: "old data", ttl: ttl},
+				{data: "new data", ttl: ttl},
+			},
+			block: make(chan struct{}),
+		}
+		p, _ := setup(fallback, inmemory.NewLocker(1), proxy.Options{})
+
+		for i := 0; i < 10; i++ {
+			got, gotTTL, err := p.Get(ctx, url)
+			assert.Nil(t, err)
+			assert.Equal(t, "old data", got)
+			assert.Equal(t, time.Duration(0), gotTTL)
+		}
+
+		close(fallback.block)
+
+		assert.Eventually(t, func() bool {
+			got, _, err := p.Get(ctx, url)
+			return err == nil && got == "new data"
+		}, time.Second, time.Millisecond)
+		assert.Equal(t, 2, fallback.callCount())
+	})
+
+	t.Run("revalidation is given up on after background fetch timeout", func(t *testing.T) {
+		// Fallback hangs until revalidation is given up on.
+		fallback := &sequenceProvider{
+			results: []providerResult{
+				{data: "old data", ttl: ttl},
+			},
+			block: make(chan struct{}),
+		}
+		locker := inmemory.NewLocker(1)
+		p, _ := setup(fallback, locker, proxy.Options{BackgroundFetchTimeout: 50 * time.Millisecond})
+
+		got, _, err := p.Get(ctx, url)
+		assert.Nil(t, err)
+		assert.Equal(t, "old data", got)
+
+		// The lock is released once revalidation is given up on.
+		assert.Eventually(t, func() bool {
+			lease, ok, err := locker.TryLock(url)
+			if err != nil || !ok {
+				return false
+			}
+			_, _ = lease.Unlock()
+			return true
+		}, time.Second, time.Millisecond)
+
+		// Stale data is revalidated once again.
+		assert.Eventually(t, func() bool {
+			_, _, _ = p.Get(ctx, url)
+			return fallback.callCount() == 3
+		}, time.Second, time.Millisecond)
+	})
+
+	t.Run("stale data is served when fallback is unavailable", func(t *testing.T) {
+		const unavailableTTL = 5 * time.Second
+
+		fallback := &sequenceProvider{
+			results: []providerResult{
+				{data: "old data", ttl: ttl},
+				{ttl: unavailableTTL, err: streaming.ErrDataCurrentlyUnavailable},
+				{data: "new data", ttl: ttl},
+			},
+		}
+		p, clock := setup(fallback, inmemory.NewLocker(1), proxy.Options{})
+
+		// The first call triggers revalidation in background.
+		_, _, _ = p.Get(ctx, url)
+		assert.Eventually(t, func() bool {
+			return fallback.callCount() == 2
+		}, time.Second, time.Millisecond)
+
+		// Stale data is now considered good for unavailableTTL.
+		assert.Eventually(t, func() bool {
+			got, gotTTL, err := p.Get(ctx, url)
+			return err == nil && got == "old data" && gotTTL > 0 && gotTTL <= unavailableTTL
+		}, time.Second, time.Millisecond)
+		assert.Equal(t, 2, fallback.callCount())
+
+		// Once it's up, fallback provider is asked for fresh data.
+		clock.Add(unavailableTTL + time.Second)
+		_, _, _ = p.Get(ctx, url)
+		assert.Eventually(t, func() bool {
+			got, _, err := p.Get(ctx, url)
+			return err == nil && got == "new data"
+		}, time.Second, time.Millisecond)
+	})
+
+	t.Run("stale data isn't served past grace period", func(t *testing.T) {
+		fallback := &sequenceProvider{
+			results: []providerResult{
+				{data: "old data", ttl: ttl},
+				{ttl: ttl, err: streaming.ErrDataCurrentlyUnavailable},
+			},
+		}
+		p, clock := setup(fallback, inmemory.NewLocker(1), proxy.Options{})
+
+		clock.Add(grace)
+
+		_, _, err := p.Get(ctx, url)
+		assert.True(t, errors.Is(err, streaming.ErrDataCurrentlyUnavailable), err)
+	})
+}
+
+func TestProxy_RefreshAhead(t *testing.T) {
+	const (
+		url = "some url"
+		ttl = time.Minute
+	)
+
+	var (
+		ctx = context.Background()
+
+		opts = proxy.Options{
+			RefreshAheadShare:   0.5,
+			RefreshAheadMinHits: 3,
+			RefreshAheadWindow:  time.Minute,
+		}
+	)
+
+	// setup caches "v1", refreshes (but the first call to fallback) block until block is closed (if it's set).
+	setup := func(opts proxy.Options, locker streaming.Locker, block chan struct{}) (*proxy.Proxy, *sequenceProvider, *fakeClock) {
+		clock := &fakeClock{now: time.Now()}
+		fallback := &sequenceProvider{
+			results: []providerResult{
+				{data: "v1", ttl: ttl},
+				{data: "v2", ttl: ttl},
+			},
+			block: block,
+		}
+
+		p := proxy.NewProxy(log.NewNopLogger(), inmemory.NewStorage(clock.Now), locker, fallback, noAdjustment, opts, noMetrics(), clock.Now)
+
+		got, _, err := p.Get(ctx, url)
+		assert.Nil(t, err)
+		assert.Equal(t, "v1", got)
+
+		return p, fallback, clock
+	}
+
+	t.Run("hot data is refreshed before it expires", func(t *testing.T) {
+		p, fallback, clock := setup(opts, inmemory.NewLocker(1), nil)
+
+		clock.Add(40 * time.Second)
+
+		for i := 0; i < opts.RefreshAheadMinHits; i++ {
+			got, gotTTL, err := p.Get(ctx, url)
+			assert.Nil(t, err)
+			assert.Equal(t, "v1", got)
+			assert.Equal(t, 20*time.Second, gotTTL)
+		}
+
+		assert.Eventually(t, func() bool {
+			got, gotTTL, err := p.Get(ctx, url)
+			return err == nil && got == "v2" && gotTTL == ttl
+		}, time.Second, time.Millisecond)
+		assert.Equal(t, 2, fallback.callCount())
+	})
+
+	t.Run("cold data isn't refreshed", func(t *testing.T) {
+		p, fallback, clock := setup(opts, inmemory.NewLocker(1), nil)
+
+		clock.Add(40 * time.Second)
+
+		got, _, err := p.Get(ctx, url)
+		assert.Nil(t, err)
+		assert.Equal(t, "v1", got)
+
+		time.Sleep(10 * time.Millisecond)
+		assert.Equal(t, 1, fallback.callCount())
+	})
+
+	t.Run("hot data isn't refreshed too early", func(t *testing.T) {
+		p, fallback, clock := setup(opts, inmemory.NewLocker(1), nil)
+
+		clock.Add(10 * time.Second)
+
+		for i := 0; i < 2*opts.RefreshAheadMinHits; i++ {
+			_, _, err := p.Get(ctx, url)
+			assert.Nil(t, err)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+		assert.Equal(t, 1, fallback.callCount())
+	})
+
+	t.Run("refresh is given up on after background fetch timeout", func(t *testing.T) {
+		opts := opts
+		opts.BackgroundFetchTimeout = 50 * time.Millisecond
+		locker := inmemory.NewLocker(1)
+		// Fallback hangs until refresh is given up on.
+		p, fallback, clock := setup(opts, locker, make(chan struct{}))
+
+		clock.Add(40 * time.Second)
+
+		for i := 0; i < opts.RefreshAheadMinHits; i++ {
+			got, _, err := p.Get(ctx, url)
+			assert.Nil(t, err)
+			assert.Equal(t, "v1", got)
+		}
+		assert.Eventually(t, func() bool {
+			return fallback.callCount() == 2
+		}, time.Second, time.Millisecond)
+
+		// The lock is released once refresh is given up on.
+		assert.Eventually(t, func() bool {
+			lease, ok, err := locker.TryLock(url)
+			if err != nil || !ok {
+				return false
+			}
+			_, _ = lease.Unlock()
+			return true
+		}, time.Second, time.Millisecond)
+
+		// Hot data is refreshed once again.
+		assert.Eventually(t, func() bool {
+			_, _, _ = p.Get(ctx, url)
+			return fallback.callCount() == 3
+		}, time.Second, time.Millisecond)
+	})
+}
+
+func TestProxy_Degraded(t *testing.T) {
+	const (
+		url  = "some url"
+		data = "some data"
+		ttl  = time.Minute
+
+		probeInterval = 10 * time.Second
+	)
+
+	setup := func(policy proxy.DegradedPolicy, threshold int, fallback streaming.DataProvider) (*proxy.Proxy, *flakyStorage, *fakeClock, *transitionCounter) {
+		storage := &flakyStorage{TempDataStorage: inmemory.NewStorage(time.Now), down: 1}
+		clock := &fakeClock{now: time.Now()}
+		transitions := &transitionCounter{}
+
+		opts := proxy.Options{
+			Name: "some tier",
+			Degraded: proxy.DegradedOptions{
+				Policy:           policy,
+				FailureThreshold: threshold,
+				ProbeInterval:    probeInterval,
+			},
+		}
+		p := proxy.NewProxy(
+			log.NewNopLogger(),
+			storage,
+			inmemory.NewLocker(1),
+			fallback,
+			noAdjustment,
+			opts,
+			proxy.Metrics{ModeTransitions: transitions},
+			clock.Now,
+		)
+
+		return p, storage, clock, transitions
+	}
+
+	t.Run("fail fast", func(t *testing.T) {
+		fallback := &sequenceProvider{results: []providerResult{{data: data, ttl: ttl}}}
+		p, storage, _, _ := setup(proxy.DegradedFailFast, 2, fallback)
+
+		// Storage failures are reported the same way before and after the breaker opens.
+		for i := 0; i < 3; i++ {
+			_, _, err := p.Get(context.Background(), url)
+			assert.True(t, errors.Is(err, streaming.ErrStorageUnavailable), err)
+		}
+
+		// The last call hasn't even tried storage.
+		assert.EqualValues(t, 2, atomic.LoadInt64(&storage.gets))
+		assert.Equal(t, 0, fallback.callCount())
+	})
+
+	t.Run("bypass coalesces calls to fallback provider", func(t *testing.T) {
+		const callers = 50
+
+		fallback := &blockingProvider{data: data, ttl: ttl, release: make(chan struct{})}
+		p, _, _, _ := setup(proxy.DegradedBypass, 1, fallback)
+
+		var wg sync.WaitGroup
+		for i := 0; i < callers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				got, gotTTL, err := p.Get(context.Background(), url)
+				assert.Nil(t, err)
+				assert.Equal(t, data, got)
+				assert.Equal(t, ttl, gotTTL)
+			}()
+		}
+
+		// Let all the callers pile up behind the first one.
+		time.Sleep(50 * time.Millisecond)
+		close(fallback.release)
+		wg.Wait()
+
+		assert.EqualValues(t, 1, atomic.LoadInt64(&fallback.calls))
+	})
+
+	t.Run("l1 only", func(t *testing.T) {
+		fallback := &sequenceProvider{results: []providerResult{{data: data, ttl: ttl}}}
+		p, _, _, _ := setup(proxy.DegradedL1Only, 1, fallback)
+
+		_, _, err := p.Get(context.Background(), url)
+		assert.True(t, errors.Is(err, streaming.ErrDataCurrentlyUnavailable), err)
+		assert.Equal(t, 0, fallback.callCount())
+	})
+
+	t.Run("probes storage until it recovers", func(t *testing.T) {
+		fallback := &sequenceProvider{results: []providerResult{{data: data, ttl: ttl}}}
+		p, storage, clock, transitions := setup(proxy.DegradedBypass, 2, fallback)
+
+		for i := 0; i < 5; i++ {
+			got, _, err := p.Get(context.Background(), url)
+			assert.Nil(t, err)
+			assert.Equal(t, data, got)
+		}
+		// Storage isn't tried once the breaker is open.
+		assert.EqualValues(t, 2, atomic.LoadInt64(&storage.gets))
+		assert.Equal(t, []string{"closed -> open"}, transitions.values())
+
+		// The probe fails, so we stay in degraded mode.
+		clock.Add(probeInterval)
+		_, _, err := p.Get(context.Background(), url)
+		assert.Nil(t, err)
+		_, _, err = p.Get(context.Background(), url)
+		assert.Nil(t, err)
+		assert.EqualValues(t, 3, atomic.LoadInt64(&storage.gets))
+		assert.Equal(t, []string{"closed -> open", "open -> half_open", "half_open -> open"}, transitions.values())
+
+		// The next probe succeeds, and storage is back in use.
+		atomic.StoreInt32(&storage.down, 0)
+		clock.Add(probeInterval)
+		_, _, err = p.Get(context.Background(), url)
+		assert.Nil(t, err)
+		gets := atomic.LoadInt64(&storage.gets)
+		_, _, err = p.Get(context.Background(), url)
+		assert.Nil(t, err)
+		assert.EqualValues(t, gets+1, atomic.LoadInt64(&storage.gets))
+		assert.Equal(
+			t,
+			[]string{"closed -> open", "open -> half_open", "half_open -> open", "open -> half_open", "half_open -> closed"},
+			transitions.values(),
+		)
+
+		// The data is stored again.
+		_, _, err = storage.TempDataStorage.Get(context.Background(), url)
+		assert.Nil(t, err)
+	})
+
+	t.Run("storage errors of the tier below don't open the breaker", func(t *testing.T) {
+		fallback := &sequenceProvider{results: []providerResult{{data: data, ttl: ttl}}}
+		lower, lowerStorage, _, lowerTransitions := setup(proxy.DegradedFailFast, 1, fallback)
+		upper, upperStorage, _, upperTransitions := setup(proxy.DegradedBypass, 1, lower)
+		atomic.StoreInt32(&upperStorage.down, 0)
+
+		_, _, err := upper.Get(context.Background(), url)
+		assert.NotNil(t, err)
+
+		// The tier below has failed fast (and switched to degraded mode), ...
+		assert.EqualValues(t, 1, atomic.LoadInt64(&lowerStorage.gets))
+		assert.Equal(t, []string{"closed -> open"}, lowerTransitions.values())
+		// ... but the tier in front of it is fine, and it hasn't called the tier below once again (bypassing its storage).
+		assert.Empty(t, upperTransitions.values())
+		assert.Equal(t, 0, fallback.callCount())
+
+		_, _, err = upper.Get(context.Background(), url)
+		assert.True(t, errors.Is(err, streaming.ErrStorageUnavailable), err)
+		assert.EqualValues(t, 1, atomic.LoadInt64(&lowerStorage.gets))
+		assert.Empty(t, upperTransitions.values())
+	})
+
+	t.Run("fallback errors don't open the breaker", func(t *testing.T) {
+		fallback := &sequenceProvider{results: []providerResult{{err: errors.New("some error")}}}
+		p, storage, _, transitions := setup(proxy.DegradedBypass, 1, fallback)
+		atomic.StoreInt32(&storage.down, 0)
+
+		for i := 0; i < 3; i++ {
+			_, _, err := p.Get(context.Background(), url)
+			assert.NotNil(t, err)
+		}
+
+		assert.Equal(t, 3, fallback.callCount())
+		assert.Empty(t, transitions.values())
+	})
+}
+
+func noMetrics() proxy.Metrics {
+	return proxy.Metrics{ModeTransitions: discard.NewCounter()}
+}
+
+func noAdjustment(ttl time.Duration) time.Duration {
+	return ttl
+}
+
+// blockingProvider blocks every call until release is closed.
+type blockingProvider struct {
+	data string
+	ttl  time.Duration
+	err  error
+
+	release chan struct{}
+	// honorCtx makes calls return as soon as their ctx is done.
+	honorCtx bool
+
+	calls int64
+}
+
+func (p *blockingProvider) Get(ctx context.Context, _ string) (string, time.Duration, error) {
+	atomic.AddInt64(&p.calls, 1)
+
+	if p.honorCtx {
+		select {
+		case <-p.release:
+		case <-ctx.Done():
+			return "", 0, ctx.Err()
+		}
+	} else {
+		<-p.release
+	}
+
+	return p.data, p.ttl, p.err
+}
+
+type providerResult struct {
+	data string
+	ttl  time.Duration
+	err  error
+}
+
+// sequenceProvider returns results one by one (repeating the last one), calls but the first one block until
+// block is closed (if it's set) or their ctx is done.
+type sequenceProvider struct {
+	results []providerResult
+	block   chan struct{}
+
+	mu    sync.Mutex
+	calls int
+}
+
+func (p *sequenceProvider) Get(ctx context.Context, _ string) (string, time.Duration, error) {
+	p.mu.Lock()
+	idx := p.calls
+	p.calls++
+	p.mu.Unlock()
+
+	if idx > 0 && p.block != nil {
+		select {
+		case <-p.block:
+		case <-ctx.Done():
+			return "", 0, ctx.Err()
+		}
+	}
+
+	if idx >= len(p.results) {
+		idx = len(p.results) - 1
+	}
+	r := p.results[idx]
+
+	return r.data, r.ttl, r.err
+}
+
+func (p *sequenceProvider) callCount() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.calls
+}
+
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+func (c *fakeClock) Add(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+}
+
+type countingLocker struct {
+	streaming.Locker
+
+	locks int64
+}
+
+func (l *countingLocker) LockContext(ctx context.Context, url string) (streaming.Lease, error) {
+	atomic.AddInt64(&l.locks, 1)
+
+	return l.Locker.LockContext(ctx, url)
+}
+
+// losingLocker loses every lease right after it's acquired.
+type losingLocker struct {
+	streaming.Locker
+}
+
+func (l losingLocker) LockContext(ctx context.Context, url string) (streaming.Lease, error) {
+	lease, err := l.Locker.LockContext(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+
+	return lostLease{Lease: lease}, nil
+}
+
+type lostLease struct {
+	streaming.Lease
+}
+
+func (lostLease) Held() bool {
+	return false
+}
+
+// fencingLocker hands out leases with the fencing token it's been set up with.
+type fencingLocker struct {
+	streaming.Locker
+
+	token int64
+}
+
+func (l *fencingLocker) LockContext(ctx context.Context, url string) (streaming.Lease, error) {
+	lease, err := l.Locker.LockContext(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+
+	return tokenLease{Lease: lease, token: l.token}, nil
+}
+
+type tokenLease struct {
+	streaming.Lease
+
+	token int64
+}
+
+func (l tokenLease) Token() int64 {
+	return l.token
+}
+
+// fencedStorage rejects writes with tokens older than the last accepted one (and plain writes).
+type fencedStorage struct {
+	streaming.TempDataStorage
+
+	lastToken int64
+}
+
+func (s *fencedStorage) Set(context.Context, string, string, time.Duration) error {
+	return errors.New("unfenced write")
+}
+
+func (s *fencedStorage) SetFenced(ctx context.Context, url string, data string, ttl time.Duration, token int64) error {
+	if token < s.lastToken {
+		return streaming.ErrFencingTokenRejected
+	}
+	s.lastToken = token
+
+	return s.TempDataStorage.Set(ctx, url, data, ttl)
+}
+
+// flakyStorage fails every call while it's down.
+type flakyStorage struct {
+	streaming.TempDataStorage
+
+	down int32
+	gets int64
+}
+
+func (s *flakyStorage) Get(ctx context.Context, url string) (string, time.Duration, error) {
+	atomic.AddInt64(&s.gets, 1)
+
+	if atomic.LoadInt32(&s.down) == 1 {
+		return "", 0, errors.New("storage is down")
+	}
+
+	return s.TempDataStorage.Get(ctx, url)
+}
+
+func (s *flakyStorage) Set(ctx context.Context, url string, data string, ttl time.Duration) error {
+	if atomic.LoadInt32(&s.down) == 1 {
+		return errors.New("storage is down")
+	}
+
+	return s.TempDataStorage.Set(ctx, url, data, ttl)
+}
+
+// transitionCounter records mode transitions as "from -> to".
+type transitionCounter struct {
+	mu          sync.Mutex
+	transitions []string
+
+	lvs []string
+	// parent is the counter this one has been derived from with With.
+	parent *transitionCounter
+}
+
+func (c *transitionCounter) With(labelValues ...string) metrics.Counter {
+	return &transitionCounter{lvs: append(append([]string{}, c.lvs...), labelValues...), parent: c}
+}
+
+func (c *transitionCounter) Add(float64) {
+	labels := make(map[string]string)
+	for i := 0; i+1 < len(c.lvs); i += 2 {
+		labels[c.lvs[i]] = c.lvs[i+1]
+	}
+
+	root := c
+	for root.parent != nil {
+		root = root.parent
+	}
+
+	root.mu.Lock()
+	defer root.mu.Unlock()
+
+	root.transitions = append(root.transitions, labels["from"]+" -> "+labels["to"])
+}
+
+func (c *transitionCounter) values() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]string{}, c.transitions...)
+}
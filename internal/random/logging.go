@@ -6,6 +6,8 @@ import (
 
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
+
+	"github.com/LasTshaMAN/streaming"
 )
 
 type LoggingMiddleware struct {
@@ -21,7 +23,7 @@ func NewLoggingMiddleware(logger log.Logger, srv *Service) *LoggingMiddleware {
 	}
 }
 
-func (mw *LoggingMiddleware) GetNext(ctx context.Context) (string, error) {
+func (mw *LoggingMiddleware) GetNext(ctx context.Context, filter streaming.URLFilter) (string, string, time.Duration, error) {
 	defer func(begin time.Time) {
 		_ = level.Info(mw.logger).Log(
 			"method", "GetNext",
@@ -29,5 +31,5 @@ func (mw *LoggingMiddleware) GetNext(ctx context.Context) (string, error) {
 		)
 	}(time.Now())
 
-	return mw.srv.GetNext(ctx)
+	return mw.srv.GetNext(ctx, filter)
 }
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"math/rand"
+	"time"
 
 	"github.com/LasTshaMAN/streaming"
 )
@@ -11,24 +12,62 @@ import (
 type Service struct {
 	provider streaming.DataProvider
 	urls     []string
+	known    map[string]struct{}
+	groups   map[string][]string
 }
 
-func NewService(urls []string, provider streaming.DataProvider) *Service {
+func NewService(urls []string, groups map[string][]string, provider streaming.DataProvider) *Service {
+	known := make(map[string]struct{}, len(urls))
+	for _, url := range urls {
+		known[url] = struct{}{}
+	}
+
 	return &Service{
 		urls:     urls,
+		known:    known,
+		groups:   groups,
 		provider: provider,
 	}
 }
 
-func (srv *Service) GetNext(ctx context.Context) (string, error) {
-	idx := rand.Intn(len(srv.urls))
+func (srv *Service) GetNext(ctx context.Context, filter streaming.URLFilter) (string, string, time.Duration, error) {
+	urls, err := srv.resolve(filter)
+	if err != nil {
+		return "", "", 0, fmt.Errorf("resolve url filter, err: %w", err)
+	}
 
-	url := srv.urls[idx]
+	idx := rand.Intn(len(urls))
 
-	data, _, err := srv.provider.Get(ctx, url)
+	url := urls[idx]
+
+	data, ttl, err := srv.provider.Get(ctx, url)
 	if err != nil {
-		return "", fmt.Errorf("get url, err: %w", err)
+		return url, "", ttl, fmt.Errorf("get url, err: %w", err)
+	}
+
+	return url, data, ttl, nil
+}
+
+// resolve returns the (non-empty) list of URLs that pass filter.
+func (srv *Service) resolve(filter streaming.URLFilter) ([]string, error) {
+	if len(filter.URLs) > 0 {
+		for _, url := range filter.URLs {
+			if _, ok := srv.known[url]; !ok {
+				return nil, fmt.Errorf("url: %s, err: %w", url, streaming.ErrUnknownURL)
+			}
+		}
+
+		return filter.URLs, nil
+	}
+
+	if filter.Group != "" {
+		urls, ok := srv.groups[filter.Group]
+		if !ok || len(urls) == 0 {
+			return nil, fmt.Errorf("group: %s, err: %w", filter.Group, streaming.ErrUnknownURLGroup)
+		}
+
+		return urls, nil
 	}
 
-	return data, nil
+	return srv.urls, nil
 }
@@ -0,0 +1,36 @@
+package redis_test
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+)
+
+// benchURLs returns count distinct URLs.
+func benchURLs(count int) []string {
+	urls := make([]string, count)
+	for i := range urls {
+		urls[i] = fmt.Sprintf("https://example.com/%d", i)
+	}
+
+	return urls
+}
+
+// walkURLs calls do with urls from parallel go-routines, every go-routine walks through urls on its own,
+// so that they mostly want different URLs at the same time.
+func walkURLs(b *testing.B, urls []string, do func(url string)) {
+	var workers int64
+
+	b.SetParallelism(16)
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		i := int(atomic.AddInt64(&workers, 1)*97) % len(urls)
+
+		for pb.Next() {
+			i = (i*31 + 7) % len(urls)
+
+			do(urls[i])
+		}
+	})
+}
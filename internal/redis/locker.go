@@ -1,55 +1,320 @@
 package redis
 
 import (
+	"context"
 	"fmt"
 	"hash/fnv"
+	"math/rand"
+	"sync"
 	"time"
 
-	"github.com/go-redsync/redsync"
 	"github.com/gomodule/redigo/redis"
+
+	"github.com/LasTshaMAN/streaming"
 )
 
+// Locker holds its locks in a quorum (majority) of independent Redis nodes (pools), so that it keeps working
+// (and locks stay mutually exclusive) as long as the majority of nodes is up.
 type Locker struct {
-	pool *redis.Pool
+	pools []*redis.Pool
 
-	locks []*redsync.Mutex
-	size  int
-}
+	// name returns the name of the lock for url.
+	name       func(url string) string
+	lockExpiry time.Duration
+	retryDelay time.Duration
 
-func NewLocker(size int, lockExpiry time.Duration, pool *redis.Pool) *Locker {
-	r := redsync.New([]redsync.Pool{pool})
+	// held are the leases acquired with Lock (by lock name), they are released with Unlock.
+	held   map[string]*lease
+	heldMu sync.Mutex
+}
 
-	locks := make([]*redsync.Mutex, size)
+// NewLocker creates Locker that hashes URLs into size locks (stripes), so different URLs might share the same lock.
+func NewLocker(size int, lockExpiry time.Duration, retryDelay time.Duration, pools []*redis.Pool) *Locker {
+	names := make([]string, size)
 	for i := 0; i < size; i++ {
-		locks[i] = r.NewMutex(fmt.Sprintf("lock %d", i), redsync.SetExpiry(lockExpiry))
+		names[i] = fmt.Sprintf("lock %d", i)
+	}
+
+	name := func(url string) string {
+		h := fnv.New64()
+
+		_, _ = h.Write([]byte(url))
+		defer h.Reset()
+
+		hash := h.Sum64()
+
+		return names[hash%uint64(size)]
+	}
+
+	return newLocker(name, lockExpiry, retryDelay, pools)
+}
+
+// NewURLLocker creates Locker that has a lock per URL, there is nothing to clean up since locks in Redis expire
+// (and fencing tokens of all the locks come from a single counter).
+func NewURLLocker(lockExpiry time.Duration, retryDelay time.Duration, pools []*redis.Pool) *Locker {
+	name := func(url string) string {
+		return "lock " + url
 	}
 
+	return newLocker(name, lockExpiry, retryDelay, pools)
+}
+
+func newLocker(name func(url string) string, lockExpiry time.Duration, retryDelay time.Duration, pools []*redis.Pool) *Locker {
 	return &Locker{
-		pool:  pool,
-		locks: locks,
-		size:  size,
+		pools:      pools,
+		name:       name,
+		lockExpiry: lockExpiry,
+		retryDelay: retryDelay,
+		held:       make(map[string]*lease),
 	}
 }
 
+// Lock waits for the lock for as long as it takes, since every lock expires eventually it doesn't block forever
+// (unless there are always others acquiring the lock before us).
 func (locker *Locker) Lock(url string) error {
-	lock := locker.getLock(url)
+	l, err := locker.LockContext(context.Background(), url)
+	if err != nil {
+		return err
+	}
+
+	locker.heldMu.Lock()
+	defer locker.heldMu.Unlock()
+
+	locker.held[locker.name(url)] = l.(*lease)
 
-	return lock.Lock()
+	return nil
+}
+
+func (locker *Locker) LockContext(ctx context.Context, url string) (streaming.Lease, error) {
+	for {
+		l, ok, err := locker.tryLock(ctx, url)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return l, nil
+		}
+
+		// Randomize the delay a bit, so that the ones waiting for the same lock don't retry all at once.
+		delay := locker.retryDelay/2 + time.Duration(rand.Int63n(int64(locker.retryDelay)+1))
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, fmt.Errorf("url: %s, ctx err: %v, err: %w", url, ctx.Err(), streaming.ErrLockTimeout)
+		}
+	}
+}
+
+func (locker *Locker) TryLock(url string) (streaming.Lease, bool, error) {
+	l, ok, err := locker.tryLock(context.Background(), url)
+	if err != nil || !ok {
+		return nil, ok, err
+	}
+
+	return l, true, nil
 }
 
 func (locker *Locker) Unlock(url string) (bool, error) {
-	lock := locker.getLock(url)
+	name := locker.name(url)
+
+	locker.heldMu.Lock()
+	l, ok := locker.held[name]
+	delete(locker.held, name)
+	locker.heldMu.Unlock()
+
+	if !ok {
+		// The lock isn't held (by us).
+		return false, nil
+	}
+
+	return l.Unlock()
+}
+
+// Key returns the name of the lock url maps to.
+func (locker *Locker) Key(url string) string {
+	return locker.name(url)
+}
+
+// tryLock makes a single attempt to acquire the lock, the lock is extended in background until ctx is done.
+func (locker *Locker) tryLock(ctx context.Context, url string) (*lease, bool, error) {
+	// Every attempt gets its own mutex (with its own random value), so that only the one who has acquired the lock
+	// can extend or release it.
+	m, err := newMutex(locker.name(url), locker.lockExpiry, locker.pools)
+	if err != nil {
+		return nil, false, err
+	}
+
+	start := time.Now()
+
+	ok, err := m.lock()
+	if err != nil {
+		return nil, false, fmt.Errorf("acquire lock in Redis, err: %w", err)
+	}
+	if !ok {
+		// The lock is held by somebody else.
+		return nil, false, nil
+	}
+
+	token, err := m.nextToken()
+	if err != nil {
+		_, _ = m.unlock()
+		return nil, false, fmt.Errorf("issue fencing token in Redis, err: %w", err)
+	}
+
+	l := newLease(m, locker.lockExpiry, start, token)
+
+	go l.keepAlive(ctx)
 
-	return lock.Unlock()
+	return l, true, nil
 }
 
-func (locker *Locker) getLock(url string) *redsync.Mutex {
-	h := fnv.New64()
+// lease is a lock in Redis, it's extended in background (every third of its expiry) for as long as it's held.
+//
+// The lease is lost when an extension fails, or when it expires after its holder has stopped extending it
+// (because the context the lease has been acquired with is done).
+type lease struct {
+	mutex  *mutex
+	expiry time.Duration
+	token  int64
+
+	mu sync.Mutex
+	// validUntil is when the lock expires unless it's extended.
+	validUntil time.Time
+	released   bool
+
+	lost     chan struct{}
+	lostOnce sync.Once
+
+	// stop tells keepAlive to stop extending the lock, keepAlive closes stopped once it has stopped.
+	stop     chan struct{}
+	stopOnce sync.Once
+	stopped  chan struct{}
+}
+
+func newLease(m *mutex, expiry time.Duration, start time.Time, token int64) *lease {
+	l := &lease{
+		mutex:   m,
+		expiry:  expiry,
+		token:   token,
+		lost:    make(chan struct{}),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	l.setValidUntil(start)
+
+	return l
+}
+
+func (l *lease) Held() bool {
+	select {
+	case <-l.lost:
+		return false
+	default:
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return !l.released && time.Now().Before(l.validUntil)
+}
+
+func (l *lease) Lost() <-chan struct{} {
+	return l.lost
+}
+
+func (l *lease) Token() int64 {
+	return l.token
+}
+
+func (l *lease) Unlock() (bool, error) {
+	l.stopOnce.Do(func() {
+		close(l.stop)
+	})
+	<-l.stopped
+
+	if !l.Held() || !l.release() {
+		// Either we've released the lock already, or somebody else might be holding it already.
+		return false, nil
+	}
+
+	return l.mutex.unlock()
+}
+
+// release marks the lease released, it returns false if it's been released already.
+func (l *lease) release() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.released {
+		return false
+	}
+	l.released = true
+
+	return true
+}
+
+func (l *lease) keepAlive(ctx context.Context) {
+	defer close(l.stopped)
+
+	ticker := time.NewTicker(l.expiry / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ctx.Done():
+			l.expire()
+			return
+		case <-ticker.C:
+		}
+
+		start := time.Now()
+
+		ok, err := l.mutex.extend()
+		if err != nil || !ok {
+			// We can't tell whether the lock is still ours, so let's assume the worst.
+			l.markLost()
+			return
+		}
+
+		l.setValidUntil(start)
+	}
+}
+
+// expire waits for the lock to expire (since nobody extends it anymore) and marks it lost,
+// unless it's unlocked first.
+func (l *lease) expire() {
+	l.mu.Lock()
+	left := time.Until(l.validUntil)
+	l.mu.Unlock()
+
+	timer := time.NewTimer(left)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		l.markLost()
+	case <-l.stop:
+	}
+}
+
+func (l *lease) markLost() {
+	l.lostOnce.Do(func() {
+		close(l.lost)
+	})
+}
 
-	_, _ = h.Write([]byte(url))
-	defer h.Reset()
+// setValidUntil updates when the lock expires, given it's been locked (or extended) at start.
+func (l *lease) setValidUntil(start time.Time) {
+	// Take clock drift into account (the same way redsync does).
+	drift := time.Duration(float64(l.expiry)*0.01) + 2*time.Millisecond
 
-	hash := h.Sum64()
+	l.mu.Lock()
+	defer l.mu.Unlock()
 
-	return locker.locks[hash%uint64(locker.size)]
+	l.validUntil = start.Add(l.expiry - drift)
 }
@@ -0,0 +1,59 @@
+package redis_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	redigo "github.com/gomodule/redigo/redis"
+
+	"github.com/LasTshaMAN/streaming"
+	"github.com/LasTshaMAN/streaming/internal/redis"
+)
+
+// BenchmarkLocker shows how much unrelated URLs wait on each other in every lock mode.
+func BenchmarkLocker(b *testing.B) {
+	const (
+		host = "localhost:6379"
+		db   = 0
+
+		urlCount   = 1000
+		lockExpiry = time.Minute
+		retryDelay = time.Millisecond
+		// holdTime stands for a fetch from fallback provider (done while holding the lock).
+		holdTime = 50 * time.Microsecond
+	)
+
+	client := redis.NewClient(host, db, time.Minute, time.Minute, time.Minute, 64, 64, time.Minute)
+	defer client.Close()
+
+	pools := []*redigo.Pool{client}
+
+	urls := benchURLs(urlCount)
+
+	benchmarks := []struct {
+		name   string
+		locker streaming.Locker
+	}{
+		{name: "stripe 1", locker: redis.NewLocker(1, lockExpiry, retryDelay, pools)},
+		{name: "stripe 100", locker: redis.NewLocker(100, lockExpiry, retryDelay, pools)},
+		{name: "url", locker: redis.NewURLLocker(lockExpiry, retryDelay, pools)},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			walkURLs(b, urls, func(url string) {
+				lease, err := bm.locker.LockContext(context.Background(), url)
+				if err != nil {
+					b.Fatal(err)
+				}
+
+				time.Sleep(holdTime)
+
+				_, err = lease.Unlock()
+				if err != nil {
+					b.Fatal(err)
+				}
+			})
+		})
+	}
+}
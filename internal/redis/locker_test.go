@@ -0,0 +1,261 @@
+package redis_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	redigo "github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/LasTshaMAN/streaming"
+	"github.com/LasTshaMAN/streaming/internal/redis"
+)
+
+func TestLocker(t *testing.T) {
+	const (
+		host = "localhost:6379"
+		db   = 0
+
+		url = "some url"
+
+		lockExpiry = time.Minute
+		retryDelay = 5 * time.Millisecond
+	)
+
+	setupWithExpiry := func(t *testing.T, lockExpiry time.Duration) (*redis.Locker, *redis.Locker, *redigo.Pool) {
+		client := redis.NewClient(host, db, time.Minute, time.Minute, time.Minute, 16, 16, time.Minute)
+		t.Cleanup(func() {
+			err := client.Close()
+
+			assert.Nil(t, err)
+		})
+
+		flushRedis(t, client)
+
+		pools := []*redigo.Pool{client}
+
+		// 2 lockers stand for 2 instances of our service.
+		return redis.NewLocker(1, lockExpiry, retryDelay, pools), redis.NewLocker(1, lockExpiry, retryDelay, pools), client
+	}
+
+	setup := func(t *testing.T) (*redis.Locker, *redis.Locker) {
+		locker1, locker2, _ := setupWithExpiry(t, lockExpiry)
+
+		return locker1, locker2
+	}
+
+	t.Run("try lock", func(t *testing.T) {
+		locker1, locker2 := setup(t)
+
+		lease, ok, err := locker1.TryLock(url)
+		assert.Nil(t, err)
+		assert.True(t, ok)
+		assert.True(t, lease.Held())
+
+		_, ok, err = locker2.TryLock(url)
+		assert.Nil(t, err)
+		assert.False(t, ok)
+
+		success, err := lease.Unlock()
+		assert.Nil(t, err)
+		assert.True(t, success)
+		assert.False(t, lease.Held())
+
+		_, ok, err = locker2.TryLock(url)
+		assert.Nil(t, err)
+		assert.True(t, ok)
+	})
+
+	t.Run("fencing tokens increase", func(t *testing.T) {
+		locker1, locker2 := setup(t)
+
+		lease1, ok, err := locker1.TryLock(url)
+		assert.Nil(t, err)
+		assert.True(t, ok)
+
+		_, err = lease1.Unlock()
+		assert.Nil(t, err)
+
+		lease2, ok, err := locker2.TryLock(url)
+		assert.Nil(t, err)
+		assert.True(t, ok)
+
+		assert.True(t, lease1.Token() > 0)
+		assert.True(t, lease2.Token() > lease1.Token())
+	})
+
+	t.Run("lock and unlock by url", func(t *testing.T) {
+		locker1, locker2 := setup(t)
+
+		err := locker1.Lock(url)
+		assert.Nil(t, err)
+
+		// Only the one holding the lock can unlock it.
+		success, err := locker2.Unlock(url)
+		assert.Nil(t, err)
+		assert.False(t, success)
+
+		success, err = locker1.Unlock(url)
+		assert.Nil(t, err)
+		assert.True(t, success)
+
+		_, ok, err := locker2.TryLock(url)
+		assert.Nil(t, err)
+		assert.True(t, ok)
+	})
+
+	t.Run("lock context times out", func(t *testing.T) {
+		locker1, locker2 := setup(t)
+
+		err := locker1.Lock(url)
+		assert.Nil(t, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		start := time.Now()
+
+		_, err = locker2.LockContext(ctx, url)
+		assert.True(t, errors.Is(err, streaming.ErrLockTimeout), err)
+		assert.True(t, time.Since(start) < time.Second)
+	})
+
+	t.Run("lock context acquires released lock", func(t *testing.T) {
+		locker1, locker2 := setup(t)
+
+		err := locker1.Lock(url)
+		assert.Nil(t, err)
+
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			_, _ = locker1.Unlock(url)
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		lease, err := locker2.LockContext(ctx, url)
+		assert.Nil(t, err)
+		assert.True(t, lease.Held())
+	})
+
+	t.Run("lease is extended while held", func(t *testing.T) {
+		const shortExpiry = 150 * time.Millisecond
+
+		locker1, locker2, _ := setupWithExpiry(t, shortExpiry)
+
+		lease, err := locker1.LockContext(context.Background(), url)
+		assert.Nil(t, err)
+
+		time.Sleep(3 * shortExpiry)
+
+		assert.True(t, lease.Held())
+		_, ok, err := locker2.TryLock(url)
+		assert.Nil(t, err)
+		assert.False(t, ok)
+
+		success, err := lease.Unlock()
+		assert.Nil(t, err)
+		assert.True(t, success)
+
+		select {
+		case <-lease.Lost():
+			t.Fatal("unlocked lease has been lost")
+		default:
+		}
+	})
+
+	t.Run("lease isn't extended once ctx is done", func(t *testing.T) {
+		const shortExpiry = 150 * time.Millisecond
+
+		locker, _, _ := setupWithExpiry(t, shortExpiry)
+
+		ctx, cancel := context.WithCancel(context.Background())
+
+		lease, err := locker.LockContext(ctx, url)
+		assert.Nil(t, err)
+
+		cancel()
+
+		select {
+		case <-lease.Lost():
+		case <-time.After(3 * shortExpiry):
+			t.Fatal("lease hasn't been lost")
+		}
+		assert.False(t, lease.Held())
+
+		// The lock isn't ours anymore.
+		success, err := lease.Unlock()
+		assert.Nil(t, err)
+		assert.False(t, success)
+	})
+
+	t.Run("lease is lost when it can't be extended", func(t *testing.T) {
+		const shortExpiry = 150 * time.Millisecond
+
+		locker, _, client := setupWithExpiry(t, shortExpiry)
+
+		lease, err := locker.LockContext(context.Background(), url)
+		assert.Nil(t, err)
+
+		// Somebody has taken the lock over.
+		conn := client.Get()
+		_, err = conn.Do("SET", "lock 0", "somebody else's value")
+		assert.Nil(t, err)
+		assert.Nil(t, conn.Close())
+
+		select {
+		case <-lease.Lost():
+		case <-time.After(3 * shortExpiry):
+			t.Fatal("lease hasn't been lost")
+		}
+		assert.False(t, lease.Held())
+	})
+
+	t.Run("url locks are independent", func(t *testing.T) {
+		client := redis.NewClient(host, db, time.Minute, time.Minute, time.Minute, 16, 16, time.Minute)
+		defer client.Close()
+
+		flushRedis(t, client)
+
+		pools := []*redigo.Pool{client}
+
+		locker1, locker2 := redis.NewURLLocker(lockExpiry, retryDelay, pools), redis.NewURLLocker(lockExpiry, retryDelay, pools)
+
+		lease, ok, err := locker1.TryLock(url)
+		assert.Nil(t, err)
+		assert.True(t, ok)
+
+		_, ok, err = locker2.TryLock(url)
+		assert.Nil(t, err)
+		assert.False(t, ok)
+
+		_, ok, err = locker2.TryLock("other url")
+		assert.Nil(t, err)
+		assert.True(t, ok)
+
+		success, err := lease.Unlock()
+		assert.Nil(t, err)
+		assert.True(t, success)
+
+		_, ok, err = locker2.TryLock(url)
+		assert.Nil(t, err)
+		assert.True(t, ok)
+	})
+
+	t.Run("lock failure isn't a timeout", func(t *testing.T) {
+		client := redis.NewClient("localhost:1", db, time.Second, time.Second, time.Second, 1, 1, time.Minute)
+		defer client.Close()
+
+		locker := redis.NewLocker(1, lockExpiry, retryDelay, []*redigo.Pool{client})
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		_, err := locker.LockContext(ctx, url)
+		assert.NotNil(t, err)
+		assert.False(t, errors.Is(err, streaming.ErrLockTimeout), err)
+	})
+}
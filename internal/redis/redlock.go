@@ -0,0 +1,234 @@
+package redis
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// errQuorumUnreachable is returned when too many Redis nodes fail for a quorum to be reached.
+var errQuorumUnreachable = errors.New("quorum of Redis nodes is unreachable")
+
+var (
+	// releaseScript deletes the lock (KEYS[1]) only if it's still held with the provided value.
+	releaseScript = redis.NewScript(1, `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+	// extendScript resets expiry of the lock (KEYS[1]) only if it's still held with the provided value.
+	extendScript = redis.NewScript(1, `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+	// raiseScript sets the counter (KEYS[1]) to the provided value unless it's greater already.
+	raiseScript = redis.NewScript(1, `
+local current = tonumber(redis.call("GET", KEYS[1]) or "0")
+if current < tonumber(ARGV[1]) then
+	redis.call("SET", KEYS[1], ARGV[1])
+end
+return 1
+`)
+)
+
+// mutex is a lock held in a quorum (majority) of independent Redis nodes, see https://redis.io/topics/distlock.
+//
+// Unlike redsync.Mutex, it tells a lock held by somebody else apart from a quorum we can't reach
+// (because too many nodes are down).
+type mutex struct {
+	name   string
+	value  string
+	expiry time.Duration
+	pools  []*redis.Pool
+}
+
+func newMutex(name string, expiry time.Duration, pools []*redis.Pool) (*mutex, error) {
+	b := make([]byte, 16)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return nil, fmt.Errorf("generate lock value, err: %w", err)
+	}
+
+	return &mutex{
+		name:   name,
+		value:  base64.StdEncoding.EncodeToString(b),
+		expiry: expiry,
+		pools:  pools,
+	}, nil
+}
+
+// lock makes a single attempt to acquire the lock, it returns false (and no error) when the lock is held by somebody else.
+func (m *mutex) lock() (bool, error) {
+	start := time.Now()
+
+	acquired, err := m.onQuorum(func(conn redis.Conn) (bool, error) {
+		reply, err := redis.String(conn.Do("SET", m.name, m.value, "NX", "PX", int(m.expiry/time.Millisecond)))
+		if errors.Is(err, redis.ErrNil) {
+			return false, nil
+		}
+
+		return reply == "OK", err
+	})
+	if acquired && time.Since(start) < m.expiry {
+		return true, nil
+	}
+
+	// Let's not keep the nodes we've managed to lock (if any) locked.
+	_, _ = m.unlock()
+
+	return false, err
+}
+
+// extend resets expiry of the lock, it returns false when the lock isn't held by us on a quorum of nodes anymore.
+func (m *mutex) extend() (bool, error) {
+	return m.onQuorum(func(conn redis.Conn) (bool, error) {
+		status, err := redis.Int64(extendScript.Do(conn, m.name, m.value, int(m.expiry/time.Millisecond)))
+
+		return status != 0, err
+	})
+}
+
+func (m *mutex) unlock() (bool, error) {
+	return m.onQuorum(func(conn redis.Conn) (bool, error) {
+		status, err := redis.Int64(releaseScript.Do(conn, m.name, m.value))
+
+		return status != 0, err
+	})
+}
+
+// fencingTokenKey is the key of fencing token counter, there is a single counter per node (shared by all the locks),
+// so that the amount of keys doesn't grow with the amount of locks.
+const fencingTokenKey = "lock fencing token"
+
+// nextToken issues the next fencing token for the lock, tokens keep increasing as long as they are issued
+// while holding the lock (since quorums of any two holders intersect).
+//
+// Tokens are shared by all the locks, so tokens of a lock might skip some values, but they still keep increasing.
+func (m *mutex) nextToken() (int64, error) {
+	key := fencingTokenKey
+
+	var last int64
+	err := m.onEach(func(conn redis.Conn) (bool, error) {
+		token, err := redis.Int64(conn.Do("GET", key))
+		if errors.Is(err, redis.ErrNil) {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if token > last {
+			last = token
+		}
+
+		return true, nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("get last fencing token, err: %w", err)
+	}
+
+	token := last + 1
+
+	ok, err := m.onQuorum(func(conn redis.Conn) (bool, error) {
+		_, err := raiseScript.Do(conn, key, token)
+
+		return err == nil, err
+	})
+	if !ok {
+		return 0, fmt.Errorf("store fencing token, err: %w", err)
+	}
+
+	return token, nil
+}
+
+// onQuorum runs fn against every node concurrently, it returns true if fn has succeeded on a quorum of nodes.
+// Error is only returned when fn has failed on so many nodes that a quorum can't be reached.
+func (m *mutex) onQuorum(fn func(conn redis.Conn) (bool, error)) (bool, error) {
+	succeeded, errs := m.run(fn)
+
+	quorum := len(m.pools)/2 + 1
+	if len(errs) > len(m.pools)-quorum {
+		return false, fmt.Errorf("%w, errs: %s", errQuorumUnreachable, joinErrors(errs))
+	}
+
+	return succeeded >= quorum, nil
+}
+
+// onEach runs fn against every node one after another (so that fn doesn't have to be safe for concurrent use),
+// it returns an error unless fn has succeeded on a quorum of nodes.
+func (m *mutex) onEach(fn func(conn redis.Conn) (bool, error)) error {
+	succeeded := 0
+	var errs []error
+	for _, pool := range m.pools {
+		conn := pool.Get()
+		ok, err := fn(conn)
+		_ = conn.Close()
+
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if ok {
+			succeeded++
+		}
+	}
+
+	quorum := len(m.pools)/2 + 1
+	if succeeded < quorum {
+		return fmt.Errorf("%w, errs: %s", errQuorumUnreachable, joinErrors(errs))
+	}
+
+	return nil
+}
+
+// run runs fn against every node concurrently, it returns the amount of nodes fn has succeeded on,
+// along with the errors from the nodes it has failed on.
+func (m *mutex) run(fn func(conn redis.Conn) (bool, error)) (int, []error) {
+	type result struct {
+		ok  bool
+		err error
+	}
+
+	results := make(chan result, len(m.pools))
+	for _, pool := range m.pools {
+		go func(pool *redis.Pool) {
+			conn := pool.Get()
+			defer conn.Close()
+
+			ok, err := fn(conn)
+			results <- result{ok: ok, err: err}
+		}(pool)
+	}
+
+	succeeded := 0
+	var errs []error
+	for range m.pools {
+		r := <-results
+		if r.err != nil {
+			errs = append(errs, r.err)
+			continue
+		}
+		if r.ok {
+			succeeded++
+		}
+	}
+
+	return succeeded, errs
+}
+
+func joinErrors(errs []error) string {
+	msgs := make([]string, 0, len(errs))
+	for _, err := range errs {
+		msgs = append(msgs, err.Error())
+	}
+
+	return strings.Join(msgs, "; ")
+}
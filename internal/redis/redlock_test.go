@@ -0,0 +1,192 @@
+package redis_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	redigo "github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/LasTshaMAN/streaming"
+	"github.com/LasTshaMAN/streaming/internal/redis"
+)
+
+func TestLocker_Quorum(t *testing.T) {
+	const (
+		url = "some url"
+
+		lockExpiry = time.Minute
+		retryDelay = 5 * time.Millisecond
+	)
+
+	t.Run("majority of nodes is up", func(t *testing.T) {
+		c := startCluster(t, 3)
+		c.kill(0)
+
+		locker1 := redis.NewLocker(1, lockExpiry, retryDelay, c.pools)
+		locker2 := redis.NewLocker(1, lockExpiry, retryDelay, c.pools)
+
+		lease, ok, err := locker1.TryLock(url)
+		require.Nil(t, err)
+		require.True(t, ok)
+
+		// The lock is still mutually exclusive, and a dead node doesn't make it look like an error.
+		_, ok, err = locker2.TryLock(url)
+		assert.Nil(t, err)
+		assert.False(t, ok)
+
+		success, err := lease.Unlock()
+		assert.Nil(t, err)
+		assert.True(t, success)
+
+		_, ok, err = locker2.TryLock(url)
+		assert.Nil(t, err)
+		assert.True(t, ok)
+	})
+
+	t.Run("majority of nodes is down", func(t *testing.T) {
+		c := startCluster(t, 3)
+		c.kill(0)
+		c.kill(2)
+
+		locker := redis.NewLocker(1, lockExpiry, retryDelay, c.pools)
+
+		_, _, err := locker.TryLock(url)
+		assert.NotNil(t, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		// We don't wait for the nodes to come back.
+		_, err = locker.LockContext(ctx, url)
+		assert.NotNil(t, err)
+		assert.False(t, errors.Is(err, streaming.ErrLockTimeout), err)
+	})
+
+	t.Run("lock survives minority of nodes dying", func(t *testing.T) {
+		c := startCluster(t, 5)
+
+		locker1 := redis.NewLocker(1, lockExpiry, retryDelay, c.pools)
+		locker2 := redis.NewLocker(1, lockExpiry, retryDelay, c.pools)
+
+		_, ok, err := locker1.TryLock(url)
+		require.Nil(t, err)
+		require.True(t, ok)
+
+		// Some of the nodes holding locker1's lock are gone, but the remaining ones are still the majority.
+		c.kill(0)
+		c.kill(1)
+
+		_, ok, err = locker2.TryLock(url)
+		assert.Nil(t, err)
+		assert.False(t, ok)
+	})
+
+	t.Run("lease is lost once majority of nodes is down", func(t *testing.T) {
+		const shortExpiry = 150 * time.Millisecond
+
+		c := startCluster(t, 3)
+
+		locker := redis.NewLocker(1, shortExpiry, retryDelay, c.pools)
+
+		lease, err := locker.LockContext(context.Background(), url)
+		require.Nil(t, err)
+
+		// Losing a single node doesn't affect the lease.
+		c.kill(0)
+		time.Sleep(2 * shortExpiry)
+		assert.True(t, lease.Held())
+
+		c.kill(1)
+
+		select {
+		case <-lease.Lost():
+		case <-time.After(3 * shortExpiry):
+			t.Fatal("lease hasn't been lost")
+		}
+		assert.False(t, lease.Held())
+	})
+
+	t.Run("fencing tokens increase while nodes die", func(t *testing.T) {
+		c := startCluster(t, 5)
+
+		locker := redis.NewLocker(1, lockExpiry, retryDelay, c.pools)
+
+		var last int64
+		for i := 0; i < 3; i++ {
+			lease, ok, err := locker.TryLock(url)
+			require.Nil(t, err)
+			require.True(t, ok)
+
+			assert.True(t, lease.Token() > last, "token: %d, last: %d", lease.Token(), last)
+			last = lease.Token()
+
+			_, err = lease.Unlock()
+			assert.Nil(t, err)
+
+			if i < 2 {
+				c.kill(i)
+			}
+		}
+	})
+
+	t.Run("locks share fencing token counter", func(t *testing.T) {
+		c := startCluster(t, 3)
+
+		locker := redis.NewURLLocker(lockExpiry, retryDelay, c.pools)
+
+		var last int64
+		for _, url := range []string{"url 1", "url 2", "url 3", "url 1"} {
+			lease, ok, err := locker.TryLock(url)
+			require.Nil(t, err)
+			require.True(t, ok)
+
+			assert.True(t, lease.Token() > last, "token: %d, last: %d", lease.Token(), last)
+			last = lease.Token()
+
+			_, err = lease.Unlock()
+			assert.Nil(t, err)
+		}
+
+		// Nothing is left behind per URL.
+		for _, node := range c.nodes {
+			assert.Equal(t, []string{"lock fencing token"}, node.Keys())
+		}
+	})
+}
+
+// cluster is a bunch of independent stand-in Redis nodes.
+type cluster struct {
+	nodes []*miniredis.Miniredis
+	pools []*redigo.Pool
+}
+
+func startCluster(t *testing.T, size int) *cluster {
+	c := &cluster{}
+
+	for i := 0; i < size; i++ {
+		node, err := miniredis.Run()
+		require.Nil(t, err)
+
+		c.nodes = append(c.nodes, node)
+		c.pools = append(c.pools, redis.NewClient(node.Addr(), 0, time.Second, time.Second, time.Second, 4, 4, time.Minute))
+	}
+
+	t.Cleanup(func() {
+		for i := range c.nodes {
+			c.nodes[i].Close()
+			_ = c.pools[i].Close()
+		}
+	})
+
+	return c
+}
+
+// kill stops node i, it's safe to kill a node more than once.
+func (c *cluster) kill(i int) {
+	c.nodes[i].Close()
+}
@@ -13,13 +13,35 @@ import (
 // TODO
 // Pass in a logger here to log connection issues (when closing) and unexpected Redis replies
 
+// setFencedScript stores the value (KEYS[1]) with ttl in milliseconds unless the last accepted fencing token for it
+// (KEYS[2]) is newer than the provided one, it returns 1 if the value has been stored and 0 otherwise.
+//
+// The last accepted token is kept (ARGV[4] milliseconds) longer than the value, otherwise a write with an older token
+// could sneak in after the value has expired.
+var setFencedScript = redis.NewScript(2, `
+local last = tonumber(redis.call("GET", KEYS[2]) or "0")
+local token = tonumber(ARGV[3])
+if token < last then
+	return 0
+end
+redis.call("PSETEX", KEYS[1], ARGV[2], ARGV[1])
+redis.call("SET", KEYS[2], ARGV[3], "PX", tonumber(ARGV[2]) + tonumber(ARGV[4]))
+return 1
+`)
+
 type Storage struct {
 	pool *redis.Pool
+
+	// maxLockLifetime is how long a lock (the fencing tokens are issued with) might be held for at most,
+	// it's how long a write with an older token might arrive after the value has expired.
+	maxLockLifetime time.Duration
 }
 
-func NewStorage(pool *redis.Pool) *Storage {
+// NewStorage creates Storage, maxLockLifetime is only relevant to SetFenced (see Storage).
+func NewStorage(pool *redis.Pool, maxLockLifetime time.Duration) *Storage {
 	return &Storage{
-		pool: pool,
+		pool:            pool,
+		maxLockLifetime: maxLockLifetime,
 	}
 }
 
@@ -41,7 +63,7 @@ func (storage *Storage) Get(ctx context.Context, url string) (string, time.Durat
 		return "", 0, fmt.Errorf("get value from Redis, err: %w", err)
 	}
 
-	ttlSeconds, err := redis.Int(conn.Do("TTL", url))
+	ttlMilliseconds, err := redis.Int64(conn.Do("PTTL", url))
 	if err != nil {
 		if err == redis.ErrNil {
 			return value, 0, nil
@@ -49,7 +71,7 @@ func (storage *Storage) Get(ctx context.Context, url string) (string, time.Durat
 		return "", 0, fmt.Errorf("get ttl from Redis, err: %w", err)
 	}
 
-	return value, time.Duration(ttlSeconds) * time.Second, nil
+	return value, time.Duration(ttlMilliseconds) * time.Millisecond, nil
 }
 
 func (storage *Storage) Set(ctx context.Context, url string, data string, ttl time.Duration) error {
@@ -59,10 +81,46 @@ func (storage *Storage) Set(ctx context.Context, url string, data string, ttl ti
 	}
 	defer conn.Close()
 
-	_, err = conn.Do("SETEX", url, int(ttl/time.Second), data)
+	_, err = conn.Do("PSETEX", url, milliseconds(ttl), data)
 	if err != nil {
 		return fmt.Errorf("set value with ttl in Redis, err: %w", err)
 	}
 
 	return nil
 }
+
+func (storage *Storage) SetFenced(ctx context.Context, url string, data string, ttl time.Duration, token int64) error {
+	conn, err := storage.pool.GetContext(ctx)
+	if err != nil {
+		return fmt.Errorf("get Redis context, err: %w", err)
+	}
+	defer conn.Close()
+
+	stored, err := redis.Bool(setFencedScript.Do(
+		conn,
+		url,
+		"fencing token "+url,
+		data,
+		milliseconds(ttl),
+		token,
+		milliseconds(storage.maxLockLifetime),
+	))
+	if err != nil {
+		return fmt.Errorf("set value with ttl (fenced) in Redis, err: %w", err)
+	}
+	if !stored {
+		return fmt.Errorf("url: %s, token: %d, err: %w", url, token, streaming.ErrFencingTokenRejected)
+	}
+
+	return nil
+}
+
+// milliseconds rounds d up to milliseconds (Redis rejects 0 expiration).
+func milliseconds(d time.Duration) int64 {
+	ms := int64((d + time.Millisecond - 1) / time.Millisecond)
+	if ms < 1 {
+		return 1
+	}
+
+	return ms
+}
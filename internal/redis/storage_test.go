@@ -6,6 +6,7 @@ import (
 	"testing"
 	"time"
 
+	redigo "github.com/gomodule/redigo/redis"
 	"github.com/stretchr/testify/assert"
 
 	"github.com/LasTshaMAN/streaming"
@@ -20,6 +21,8 @@ func TestStorage(t *testing.T) {
 		url  = "some url"
 		data = "some data"
 		ttl  = 2 * time.Second
+
+		lockLifetime = time.Minute
 	)
 
 	ctx := context.Background()
@@ -34,7 +37,7 @@ func TestStorage(t *testing.T) {
 
 		flushRedis(t, client)
 
-		storage := redis.NewStorage(client)
+		storage := redis.NewStorage(client, lockLifetime)
 
 		err := storage.Set(ctx, url, data, ttl)
 
@@ -57,7 +60,7 @@ func TestStorage(t *testing.T) {
 
 		flushRedis(t, client)
 
-		storage := redis.NewStorage(client)
+		storage := redis.NewStorage(client, lockLifetime)
 
 		actData, actTTL, err := storage.Get(ctx, url)
 
@@ -75,7 +78,7 @@ func TestStorage(t *testing.T) {
 
 		flushRedis(t, client)
 
-		storage := redis.NewStorage(client)
+		storage := redis.NewStorage(client, lockLifetime)
 
 		err := storage.Set(ctx, url, data, ttl)
 
@@ -90,4 +93,69 @@ func TestStorage(t *testing.T) {
 		assert.Equal(t, "", actData)
 		assert.Equal(t, actTTL, time.Duration(0))
 	})
+	t.Run("set fenced", func(t *testing.T) {
+		client := redis.NewClient(host, db, time.Minute, time.Minute, time.Minute, 16, 16, time.Minute)
+		defer func() {
+			err := client.Close()
+
+			assert.Nil(t, err)
+		}()
+
+		flushRedis(t, client)
+
+		storage := redis.NewStorage(client, lockLifetime)
+
+		err := storage.SetFenced(ctx, url, "data 2", ttl, 2)
+		assert.Nil(t, err)
+
+		// The same token is still good (for the one holding the lock).
+		err = storage.SetFenced(ctx, url, "data 2 again", ttl, 2)
+		assert.Nil(t, err)
+
+		err = storage.SetFenced(ctx, url, "data 1", ttl, 1)
+		assert.True(t, errors.Is(err, streaming.ErrFencingTokenRejected), err)
+
+		actData, _, err := storage.Get(ctx, url)
+		assert.Nil(t, err)
+		assert.Equal(t, "data 2 again", actData)
+
+		err = storage.SetFenced(ctx, url, "data 3", ttl, 3)
+		assert.Nil(t, err)
+
+		actData, _, err = storage.Get(ctx, url)
+		assert.Nil(t, err)
+		assert.Equal(t, "data 3", actData)
+
+		// The last accepted token outlives the value (by the lifetime of the lock), but it doesn't stay forever.
+		conn := client.Get()
+		defer conn.Close()
+
+		tokenTTL, err := redigo.Int64(conn.Do("PTTL", "fencing token "+url))
+		assert.Nil(t, err)
+		assert.True(t, time.Duration(tokenTTL)*time.Millisecond > ttl, tokenTTL)
+		assert.True(t, time.Duration(tokenTTL)*time.Millisecond <= ttl+lockLifetime, tokenTTL)
+	})
+	t.Run("sub-second ttl", func(t *testing.T) {
+		client := redis.NewClient(host, db, time.Minute, time.Minute, time.Minute, 16, 16, time.Minute)
+		defer func() {
+			err := client.Close()
+
+			assert.Nil(t, err)
+		}()
+
+		flushRedis(t, client)
+
+		storage := redis.NewStorage(client, lockLifetime)
+
+		err := storage.Set(ctx, url, data, 500*time.Millisecond)
+		assert.Nil(t, err)
+
+		err = storage.SetFenced(ctx, "other url", data, 500*time.Microsecond, 1)
+		assert.Nil(t, err)
+
+		_, actTTL, err := storage.Get(ctx, url)
+		assert.Nil(t, err)
+		assert.True(t, actTTL > 0, actTTL)
+		assert.True(t, actTTL <= 500*time.Millisecond, actTTL)
+	})
 }
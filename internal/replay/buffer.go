@@ -0,0 +1,130 @@
+package replay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/LasTshaMAN/streaming"
+)
+
+// Buffer keeps the latest items sent within a session (stream), so that they can be sent once again
+// to a client that lost some of them (due to reconnect, for example).
+//
+// Buffer keeps its data in TempDataStorage, so as long as this storage is shared between service instances
+// a session can be continued on any of these instances.
+//
+// Every session keeps up to size latest items, each for ttl duration since it was appended.
+//
+// Buffer can be safely used concurrently from multiple go-routines.
+type Buffer struct {
+	storage streaming.TempDataStorage
+
+	size uint64
+	ttl  time.Duration
+}
+
+func NewBuffer(storage streaming.TempDataStorage, size int, ttl time.Duration) *Buffer {
+	return &Buffer{
+		storage: storage,
+		size:    uint64(size),
+		ttl:     ttl,
+	}
+}
+
+// Append adds item with sequence number seq to session, seq must be greater than that of any item appended before.
+//
+// Append writes to storage twice (the item, and the head of session), these writes are synchronous
+// so that the item is available for replay by the time Append returns.
+func (b *Buffer) Append(ctx context.Context, session string, seq uint64, item []byte) error {
+	// Items are stored in a ring of size slots, this way we don't need to clean up the old ones.
+	err := b.storage.Set(ctx, slotKey(session, seq%b.size), encodeItem(seq, item), b.ttl)
+	if err != nil {
+		return fmt.Errorf("set item, err: %w", err)
+	}
+
+	err = b.storage.Set(ctx, headKey(session), strconv.FormatUint(seq, 10), b.ttl)
+	if err != nil {
+		return fmt.Errorf("set head, err: %w", err)
+	}
+
+	return nil
+}
+
+// Since returns items of session appended after the item with sequence number seq, in the order they were appended,
+// along with the sequence number of the last item appended to session.
+//
+// streaming.ErrResumeTokenExpired is returned when some of these items are no longer available.
+func (b *Buffer) Since(ctx context.Context, session string, seq uint64) (items [][]byte, head uint64, err error) {
+	headValue, _, err := b.storage.Get(ctx, headKey(session))
+	if errors.Is(err, streaming.ErrDataNotFoundInStorage) {
+		return nil, 0, fmt.Errorf("session: %s is not found, err: %w", session, streaming.ErrResumeTokenExpired)
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("get head, err: %w", err)
+	}
+
+	head, err = strconv.ParseUint(headValue, 10, 64)
+	if err != nil {
+		return nil, 0, fmt.Errorf("parse head: %s, err: %w", headValue, err)
+	}
+
+	if seq > head {
+		return nil, 0, fmt.Errorf("seq: %d is ahead of head: %d, err: %w", seq, head, streaming.ErrResumeTokenInvalid)
+	}
+	if head-seq > b.size {
+		return nil, 0, fmt.Errorf("%d items were missed, err: %w", head-seq, streaming.ErrResumeTokenExpired)
+	}
+
+	for next := seq + 1; next <= head; next++ {
+		value, _, err := b.storage.Get(ctx, slotKey(session, next%b.size))
+		if errors.Is(err, streaming.ErrDataNotFoundInStorage) {
+			return nil, 0, fmt.Errorf("item: %d is not found, err: %w", next, streaming.ErrResumeTokenExpired)
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("get item: %d, err: %w", next, err)
+		}
+
+		itemSeq, item, err := decodeItem(value)
+		if err != nil {
+			return nil, 0, fmt.Errorf("decode item: %d, err: %w", next, err)
+		}
+		if itemSeq != next {
+			// The slot has been overwritten by a newer item.
+			return nil, 0, fmt.Errorf("item: %d is overwritten, err: %w", next, streaming.ErrResumeTokenExpired)
+		}
+
+		items = append(items, item)
+	}
+
+	return items, head, nil
+}
+
+func headKey(session string) string {
+	return fmt.Sprintf("replay:%s:head", session)
+}
+
+func slotKey(session string, slot uint64) string {
+	return fmt.Sprintf("replay:%s:%d", session, slot)
+}
+
+func encodeItem(seq uint64, item []byte) string {
+	return strconv.FormatUint(seq, 10) + ":" + string(item)
+}
+
+func decodeItem(value string) (uint64, []byte, error) {
+	idx := strings.IndexByte(value, ':')
+	if idx < 0 {
+		return 0, nil, errors.New("no sequence number")
+	}
+
+	seq, err := strconv.ParseUint(value[:idx], 10, 64)
+	if err != nil {
+		return 0, nil, fmt.Errorf("parse sequence number, err: %w", err)
+	}
+
+	return seq, []byte(value[idx+1:]), nil
+}
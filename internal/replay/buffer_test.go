@@ -0,0 +1,93 @@
+package replay_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/LasTshaMAN/streaming"
+	"github.com/LasTshaMAN/streaming/internal/inmemory"
+	"github.com/LasTshaMAN/streaming/internal/replay"
+)
+
+func TestBuffer(t *testing.T) {
+	const (
+		session = "some session"
+		size    = 3
+		ttl     = time.Minute
+	)
+
+	ctx := context.Background()
+
+	t.Run("replay missed items", func(t *testing.T) {
+		buffer := replay.NewBuffer(inmemory.NewStorage(time.Now), size, ttl)
+
+		for seq := uint64(1); seq <= 4; seq++ {
+			err := buffer.Append(ctx, session, seq, []byte{byte(seq)})
+			assert.Nil(t, err)
+		}
+
+		items, head, err := buffer.Since(ctx, session, 2)
+
+		assert.Nil(t, err)
+		assert.Equal(t, uint64(4), head)
+		assert.Equal(t, [][]byte{{3}, {4}}, items)
+	})
+	t.Run("nothing missed", func(t *testing.T) {
+		buffer := replay.NewBuffer(inmemory.NewStorage(time.Now), size, ttl)
+
+		err := buffer.Append(ctx, session, 1, []byte{1})
+		assert.Nil(t, err)
+
+		items, head, err := buffer.Since(ctx, session, 1)
+
+		assert.Nil(t, err)
+		assert.Equal(t, uint64(1), head)
+		assert.Empty(t, items)
+	})
+	t.Run("missed more than buffer holds", func(t *testing.T) {
+		buffer := replay.NewBuffer(inmemory.NewStorage(time.Now), size, ttl)
+
+		for seq := uint64(1); seq <= 5; seq++ {
+			err := buffer.Append(ctx, session, seq, []byte{byte(seq)})
+			assert.Nil(t, err)
+		}
+
+		_, _, err := buffer.Since(ctx, session, 1)
+
+		assert.True(t, errors.Is(err, streaming.ErrResumeTokenExpired), err)
+	})
+	t.Run("unknown session", func(t *testing.T) {
+		buffer := replay.NewBuffer(inmemory.NewStorage(time.Now), size, ttl)
+
+		_, _, err := buffer.Since(ctx, session, 1)
+
+		assert.True(t, errors.Is(err, streaming.ErrResumeTokenExpired), err)
+	})
+	t.Run("seq from the future", func(t *testing.T) {
+		buffer := replay.NewBuffer(inmemory.NewStorage(time.Now), size, ttl)
+
+		err := buffer.Append(ctx, session, 1, []byte{1})
+		assert.Nil(t, err)
+
+		_, _, err = buffer.Since(ctx, session, 2)
+
+		assert.True(t, errors.Is(err, streaming.ErrResumeTokenInvalid), err)
+	})
+}
+
+func TestToken(t *testing.T) {
+	token := replay.Token{Session: "some session", Seq: 42}
+
+	parsed, err := replay.ParseToken(token.String())
+
+	assert.Nil(t, err)
+	assert.Equal(t, token, parsed)
+
+	_, err = replay.ParseToken("garbage")
+
+	assert.True(t, errors.Is(err, streaming.ErrResumeTokenInvalid), err)
+}
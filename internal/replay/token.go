@@ -0,0 +1,57 @@
+package replay
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/LasTshaMAN/streaming"
+)
+
+// Token identifies a position within a session, it's opaque for clients.
+type Token struct {
+	Session string
+	Seq     uint64
+}
+
+// NewSession returns a new unique session identifier.
+func NewSession() (string, error) {
+	b := make([]byte, 16)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", fmt.Errorf("read random bytes, err: %w", err)
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+func (t Token) String() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(t.Session + ":" + strconv.FormatUint(t.Seq, 10)))
+}
+
+// ParseToken parses a token previously obtained with Token.String.
+func ParseToken(s string) (Token, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return Token{}, fmt.Errorf("decode token, err: %w", streaming.ErrResumeTokenInvalid)
+	}
+
+	parts := strings.SplitN(string(b), ":", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return Token{}, fmt.Errorf("malformed token, err: %w", streaming.ErrResumeTokenInvalid)
+	}
+
+	seq, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return Token{}, fmt.Errorf("parse sequence number, err: %w", streaming.ErrResumeTokenInvalid)
+	}
+
+	return Token{
+		Session: parts[0],
+		Seq:     seq,
+	}, nil
+}
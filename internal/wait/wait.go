@@ -0,0 +1,20 @@
+// Package wait provides helpers for waiting that respect context cancellation.
+package wait
+
+import (
+	"context"
+	"time"
+)
+
+// Sleep blocks for duration d or until ctx is done, whichever happens first.
+func Sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
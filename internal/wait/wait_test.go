@@ -0,0 +1,28 @@
+package wait_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/LasTshaMAN/streaming/internal/wait"
+)
+
+func TestSleep(t *testing.T) {
+	t.Run("sleeps for the duration", func(t *testing.T) {
+		start := time.Now()
+		err := wait.Sleep(context.Background(), 10*time.Millisecond)
+		assert.Nil(t, err)
+		assert.True(t, time.Since(start) >= 10*time.Millisecond)
+	})
+
+	t.Run("wakes up once context is done", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		err := wait.Sleep(ctx, time.Hour)
+		assert.Equal(t, context.Canceled, err)
+	})
+}
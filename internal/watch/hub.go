@@ -0,0 +1,187 @@
+package watch
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+
+	"github.com/LasTshaMAN/streaming"
+)
+
+// Update describes a new version of the data behind URL.
+type Update struct {
+	URL  string
+	Data string
+	TTL  time.Duration
+	// Err is whatever error DataProvider returned along with this version of the data.
+	Err error
+	// AsOf is the moment the data was got from DataProvider.
+	AsOf time.Time
+
+	// version identifies this version of the data, 2 updates with equal versions are considered to be the same.
+	version string
+}
+
+// Hub keeps track of the data behind watched URLs and notifies subscribers whenever this data changes.
+//
+// Every watched URL is refreshed by a single go-routine no matter how many subscribers are watching it,
+// this go-routine re-fetches the data from provider every time the previously fetched data expires.
+//
+// Hub can be safely used concurrently from multiple go-routines.
+type Hub struct {
+	logger log.Logger
+
+	provider streaming.DataProvider
+
+	// minInterval is the lower bound on how often the data behind a single URL is re-fetched from provider.
+	minInterval time.Duration
+
+	now func() time.Time
+
+	// mu protects topics as well as the state of every topic.
+	mu     sync.Mutex
+	topics map[string]*topic
+}
+
+func NewHub(logger log.Logger, provider streaming.DataProvider, minInterval time.Duration, now func() time.Time) *Hub {
+	return &Hub{
+		logger:      logger,
+		provider:    provider,
+		minInterval: minInterval,
+		now:         now,
+		topics:      make(map[string]*topic),
+	}
+}
+
+// Subscribe starts watching urls, use Subscription.Next to receive updates.
+//
+// Subscription must be closed once it's no longer needed.
+func (hub *Hub) Subscribe(urls ...string) *Subscription {
+	s := &Subscription{
+		hub:     hub,
+		urls:    make(map[string]struct{}),
+		pending: make(map[string]Update),
+		notify:  make(chan struct{}, 1),
+	}
+
+	s.Add(urls...)
+
+	return s
+}
+
+// topic represents a single watched URL.
+type topic struct {
+	subscribers map[*Subscription]struct{}
+
+	// last is the latest known version of the data, nil until the data is fetched for the first time.
+	last *Update
+
+	cancel context.CancelFunc
+}
+
+func (hub *Hub) attach(s *Subscription, url string) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	t, ok := hub.topics[url]
+	if !ok {
+		ctx, cancel := context.WithCancel(context.Background())
+
+		t = &topic{
+			subscribers: make(map[*Subscription]struct{}),
+			cancel:      cancel,
+		}
+		hub.topics[url] = t
+
+		go hub.refresh(ctx, url, t)
+	}
+
+	t.subscribers[s] = struct{}{}
+
+	// Let the new subscriber know what the data currently looks like.
+	if t.last != nil {
+		s.push(*t.last)
+	}
+}
+
+func (hub *Hub) detach(s *Subscription, url string) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	t, ok := hub.topics[url]
+	if !ok {
+		return
+	}
+
+	delete(t.subscribers, s)
+
+	if len(t.subscribers) == 0 {
+		t.cancel()
+		delete(hub.topics, url)
+	}
+}
+
+// refresh keeps fetching the data behind url until ctx is done, publishing every new version of it.
+func (hub *Hub) refresh(ctx context.Context, url string, t *topic) {
+	for {
+		data, ttl, err := hub.provider.Get(ctx, url)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil && !errors.Is(err, streaming.ErrDataCurrentlyUnavailable) {
+			_ = level.Error(hub.logger).Log("err", fmt.Errorf("refresh watched url: %s, err: %w", url, err))
+		}
+
+		hub.publish(t, Update{
+			URL:     url,
+			Data:    data,
+			TTL:     ttl,
+			Err:     err,
+			AsOf:    hub.now(),
+			version: version(data, err),
+		})
+
+		wait := ttl
+		if wait < hub.minInterval {
+			wait = hub.minInterval
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+}
+
+func (hub *Hub) publish(t *topic, u Update) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	if t.last != nil && t.last.version == u.version {
+		return
+	}
+
+	t.last = &u
+
+	for s := range t.subscribers {
+		s.push(u)
+	}
+}
+
+// version returns an identifier of the data version, based on the data content hash.
+func version(data string, err error) string {
+	if err != nil {
+		return "err: " + err.Error()
+	}
+
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(data)))
+}
@@ -0,0 +1,162 @@
+package watch_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/LasTshaMAN/streaming/internal/watch"
+)
+
+func TestHub(t *testing.T) {
+	const (
+		url      = "some url"
+		interval = 10 * time.Millisecond
+	)
+
+	t.Run("watchers share a single refresh", func(t *testing.T) {
+		provider := &fakeProvider{data: "v1"}
+
+		hub := watch.NewHub(log.NewNopLogger(), provider, interval, time.Now)
+
+		sub1 := hub.Subscribe(url)
+		defer sub1.Close()
+		sub2 := hub.Subscribe(url)
+		defer sub2.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		u1, err := sub1.Next(ctx)
+		assert.Nil(t, err)
+		assert.Equal(t, "v1", u1.Data)
+
+		u2, err := sub2.Next(ctx)
+		assert.Nil(t, err)
+		assert.Equal(t, "v1", u2.Data)
+
+		// Let the hub re-fetch the data a few times.
+		time.Sleep(10 * interval)
+
+		calls := provider.callsCnt()
+		// A single refresh loop makes at most one call per interval.
+		assert.True(t, calls <= 11, calls)
+	})
+	t.Run("only changes are delivered", func(t *testing.T) {
+		provider := &fakeProvider{data: "v1"}
+
+		hub := watch.NewHub(log.NewNopLogger(), provider, interval, time.Now)
+
+		sub := hub.Subscribe(url)
+		defer sub.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		u, err := sub.Next(ctx)
+		assert.Nil(t, err)
+		assert.Equal(t, "v1", u.Data)
+
+		// The data doesn't change, hence there must be no updates.
+		shortCtx, shortCancel := context.WithTimeout(ctx, 5*interval)
+		defer shortCancel()
+
+		_, err = sub.Next(shortCtx)
+		assert.Equal(t, context.DeadlineExceeded, err)
+
+		provider.set("v2")
+
+		u, err = sub.Next(ctx)
+		assert.Nil(t, err)
+		assert.Equal(t, "v2", u.Data)
+	})
+	t.Run("paused subscription holds on to the latest update", func(t *testing.T) {
+		provider := &fakeProvider{data: "v1"}
+
+		hub := watch.NewHub(log.NewNopLogger(), provider, interval, time.Now)
+
+		sub := hub.Subscribe(url)
+		defer sub.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		_, err := sub.Next(ctx)
+		assert.Nil(t, err)
+
+		sub.Pause()
+
+		provider.set("v2")
+		time.Sleep(3 * interval)
+		provider.set("v3")
+
+		shortCtx, shortCancel := context.WithTimeout(ctx, 5*interval)
+		defer shortCancel()
+
+		_, err = sub.Next(shortCtx)
+		assert.Equal(t, context.DeadlineExceeded, err)
+
+		sub.Resume()
+
+		u, err := sub.Next(ctx)
+		assert.Nil(t, err)
+		assert.Equal(t, "v3", u.Data)
+	})
+	t.Run("refresh stops once nobody watches", func(t *testing.T) {
+		provider := &fakeProvider{data: "v1"}
+
+		hub := watch.NewHub(log.NewNopLogger(), provider, interval, time.Now)
+
+		sub := hub.Subscribe(url)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		_, err := sub.Next(ctx)
+		assert.Nil(t, err)
+
+		sub.Close()
+
+		// Give refresh loop a chance to notice it's no longer needed.
+		time.Sleep(2 * interval)
+
+		calls := provider.callsCnt()
+
+		time.Sleep(5 * interval)
+
+		assert.Equal(t, calls, provider.callsCnt())
+	})
+}
+
+type fakeProvider struct {
+	mu    sync.Mutex
+	data  string
+	calls int
+}
+
+func (p *fakeProvider) Get(_ context.Context, _ string) (string, time.Duration, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.calls++
+
+	return p.data, 0, nil
+}
+
+func (p *fakeProvider) set(data string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.data = data
+}
+
+func (p *fakeProvider) callsCnt() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.calls
+}
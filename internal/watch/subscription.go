@@ -0,0 +1,194 @@
+package watch
+
+import (
+	"context"
+	"sync"
+)
+
+// Subscription delivers updates for a set of watched URLs.
+//
+// When a subscriber falls behind, only the latest update per URL is kept for it, older ones are discarded.
+//
+// Subscription can be safely used concurrently from multiple go-routines.
+type Subscription struct {
+	hub *Hub
+
+	// ctl serializes Add, Remove and Close calls.
+	ctl sync.Mutex
+
+	mu   sync.Mutex
+	urls map[string]struct{}
+	// pending holds the latest undelivered update per URL, queue holds these URLs in order of arrival.
+	pending map[string]Update
+	queue   []string
+	closed  bool
+	paused  bool
+
+	// notify signals that there are pending updates.
+	notify chan struct{}
+}
+
+// Add starts watching urls (in addition to those being watched already).
+func (s *Subscription) Add(urls ...string) {
+	s.ctl.Lock()
+	defer s.ctl.Unlock()
+
+	for _, url := range urls {
+		s.mu.Lock()
+		_, watched := s.urls[url]
+		closed := s.closed
+		if !watched && !closed {
+			s.urls[url] = struct{}{}
+		}
+		s.mu.Unlock()
+
+		if watched || closed {
+			continue
+		}
+
+		s.hub.attach(s, url)
+	}
+}
+
+// Remove stops watching urls, undelivered updates for these urls are discarded.
+func (s *Subscription) Remove(urls ...string) {
+	s.ctl.Lock()
+	defer s.ctl.Unlock()
+
+	s.remove(urls...)
+}
+
+// URLs returns the URLs currently being watched.
+func (s *Subscription) URLs() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	urls := make([]string, 0, len(s.urls))
+	for url := range s.urls {
+		urls = append(urls, url)
+	}
+
+	return urls
+}
+
+// Next blocks until there is an update to deliver or ctx is done.
+func (s *Subscription) Next(ctx context.Context) (Update, error) {
+	for {
+		u, ok := s.pop()
+		if ok {
+			return u, nil
+		}
+
+		select {
+		case <-s.notify:
+		case <-ctx.Done():
+			return Update{}, ctx.Err()
+		}
+	}
+}
+
+// Pause makes Next hold on to updates until Resume is called.
+func (s *Subscription) Pause() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.paused = true
+}
+
+// Resume lets Next deliver updates again, including those that arrived while paused.
+func (s *Subscription) Resume() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.paused = false
+
+	s.signal()
+}
+
+// Close stops watching all the URLs.
+func (s *Subscription) Close() {
+	s.ctl.Lock()
+	defer s.ctl.Unlock()
+
+	s.mu.Lock()
+	s.closed = true
+	urls := make([]string, 0, len(s.urls))
+	for url := range s.urls {
+		urls = append(urls, url)
+	}
+	s.mu.Unlock()
+
+	s.remove(urls...)
+}
+
+func (s *Subscription) remove(urls ...string) {
+	for _, url := range urls {
+		s.mu.Lock()
+		_, watched := s.urls[url]
+		delete(s.urls, url)
+		s.dropPending(url)
+		s.mu.Unlock()
+
+		if !watched {
+			continue
+		}
+
+		s.hub.detach(s, url)
+	}
+}
+
+func (s *Subscription) push(u Update) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.urls[u.URL]; !ok {
+		return
+	}
+
+	if _, ok := s.pending[u.URL]; !ok {
+		s.queue = append(s.queue, u.URL)
+	}
+	s.pending[u.URL] = u
+
+	s.signal()
+}
+
+// signal wakes up Next (if it's waiting).
+func (s *Subscription) signal() {
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Subscription) pop() (Update, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.paused || len(s.queue) == 0 {
+		return Update{}, false
+	}
+
+	url := s.queue[0]
+	s.queue = s.queue[1:]
+
+	u := s.pending[url]
+	delete(s.pending, url)
+
+	return u, true
+}
+
+func (s *Subscription) dropPending(url string) {
+	if _, ok := s.pending[url]; !ok {
+		return
+	}
+
+	delete(s.pending, url)
+
+	for i, queued := range s.queue {
+		if queued == url {
+			s.queue = append(s.queue[:i], s.queue[i+1:]...)
+			break
+		}
+	}
+}
@@ -8,8 +8,21 @@ import (
 
 // RandomDataProvider is designed to provide random data.
 type RandomDataProvider interface {
-	// GetNext returns the next chunk of random data.
-	GetNext(context.Context) (data string, err error)
+	// GetNext returns the next chunk of random data, picked from the URLs that pass filter,
+	// along with the url this data comes from and its ttl (as returned by DataProvider).
+	//
+	// ErrUnknownURL or ErrUnknownURLGroup is returned when filter refers to URLs this provider doesn't know about.
+	GetNext(ctx context.Context, filter URLFilter) (url string, data string, ttl time.Duration, err error)
+}
+
+// URLFilter narrows down the set of URLs random data is picked from.
+//
+// Zero value doesn't filter anything out.
+type URLFilter struct {
+	// URLs to pick from, takes precedence over Group.
+	URLs []string
+	// Group is the name of URL group to pick from.
+	Group string
 }
 
 // DataProvider is designed to provide data identified by URL.
@@ -39,9 +52,22 @@ type TempDataStorage interface {
 	// might disappear from this storage.
 	Get(ctx context.Context, url string) (data string, ttl time.Duration, err error)
 	// Set stores data identified by url within this data storage for ttl period.
+	// Note, ttl here is how long data is kept, which might be longer than how long data is fresh for
+	// (proxy.Proxy keeps data for a grace period past its ttl to be able to serve it stale).
 	Set(ctx context.Context, url string, data string, ttl time.Duration) error
 }
 
+// FencedDataStorage is TempDataStorage that can reject writes made by the ones who no longer hold the lock
+// (associated with url), see Lease.Token.
+//
+// FencedDataStorage can be safely used concurrently from multiple go-routines.
+type FencedDataStorage interface {
+	TempDataStorage
+	// SetFenced stores data the same way Set does, unless data identified by url has been stored with a newer
+	// fencing token already, it returns ErrFencingTokenRejected in this case.
+	SetFenced(ctx context.Context, url string, data string, ttl time.Duration, token int64) error
+}
+
 // Locker manages locks each of which is identified by a URL.
 //
 // For every two URLs && url1 == url2 Locker methods must operate on the same lock,
@@ -49,8 +75,37 @@ type TempDataStorage interface {
 //
 // Locker can be safely used concurrently from multiple go-routines.
 type Locker interface {
-	// Lock acquires a lock (associated with the provided url).
+	// Lock acquires a lock (associated with the provided url), such a lock is released with Unlock.
 	Lock(url string) error
-	// Unlock previously acquired lock (associated with the provided url).
+	// LockContext acquires a lock (associated with the provided url), it stops waiting for the lock when ctx is done
+	// and returns ErrLockTimeout in this case.
+	//
+	// The lock is held until Lease.Unlock is called, or until ctx is done (whichever happens first), but
+	// it might get lost before that (for example, when it expires), holders should check Lease.Held before
+	// acting on the assumption they still hold the lock.
+	LockContext(ctx context.Context, url string) (Lease, error)
+	// TryLock acquires a lock (associated with the provided url) only if it's free at the moment,
+	// it returns false (and no error) when the lock is held by somebody else.
+	//
+	// The lock is held until Lease.Unlock is called (or until it's lost).
+	TryLock(url string) (Lease, bool, error)
+	// Unlock previously acquired (with Lock) lock (associated with the provided url).
 	Unlock(url string) (bool, error)
 }
+
+// Lease represents a lock acquired with Locker.
+//
+// Lease can be safely used concurrently from multiple go-routines.
+type Lease interface {
+	// Held reports whether the lock is still held.
+	Held() bool
+	// Lost returns a channel that's closed once the lock is lost (before it's been unlocked),
+	// the channel is never closed for locks that can't be lost.
+	Lost() <-chan struct{}
+	// Token returns the fencing token of this lease, tokens increase monotonically with every acquisition
+	// of the lock, so that FencedDataStorage can tell writes made under an older (possibly lost) lease apart.
+	// 0 means Locker doesn't issue fencing tokens.
+	Token() int64
+	// Unlock releases the lock.
+	Unlock() (bool, error)
+}